@@ -1,6 +1,9 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"goga/internal/config"
 	"goga/internal/server"
 	"log"
 	"os"
@@ -8,26 +11,23 @@ import (
 
 func main() {
 	// Configuration
-	port := getEnv("PORT", "8080")
-	dbPath := getEnv("DB_PATH", "./goga.db")
-	uploadDir := getEnv("UPLOAD_DIR", "./uploads")
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Initialize server
-	srv, err := server.New(dbPath, uploadDir)
+	srv, err := server.New(cfg)
 	if err != nil {
 		log.Fatal("Failed to initialize server:", err)
 	}
 	defer srv.Close()
 
 	// Start server
-	if err := srv.Start(port); err != nil {
+	if err := srv.Start(); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
-}
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
 }
\ No newline at end of file
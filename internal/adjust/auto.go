@@ -0,0 +1,209 @@
+package adjust
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// White balance estimators for Auto.
+const (
+	// GrayWorld assumes the average colour of the scene is neutral.
+	GrayWorld = "gray_world"
+	// WhitePatch assumes the brightest tones of the scene are neutral.
+	WhitePatch = "white_patch"
+)
+
+const (
+	// autoClip is the fraction of pixels allowed to clip at either end when
+	// stretching levels.
+	autoClip = 0.005
+	// autoMidtone is the target median luminance for exposure correction.
+	autoMidtone = 0.45
+)
+
+// AutoOptions select the corrections Auto suggests. An empty WhiteBalance
+// leaves the white balance alone.
+type AutoOptions struct {
+	WhiteBalance string
+	Levels       bool
+	Exposure     bool
+}
+
+func (o AutoOptions) Validate() error {
+	switch o.WhiteBalance {
+	case "", GrayWorld, WhitePatch:
+		return nil
+	}
+	return fmt.Errorf("white balance must be %s or %s", GrayWorld, WhitePatch)
+}
+
+// AutoSettings are suggested corrections: white balance as WhiteBalance
+// sliders, and levels combining a contrast stretch with a midtone gamma
+// for exposure. Levels are measured after the white balance, the order in
+// which an edit applies them.
+type AutoSettings struct {
+	Temperature float64
+	Tint        float64
+	Levels      Levels
+}
+
+// Auto analyses img and suggests corrections. img is not modified.
+func Auto(img *image.NRGBA, opts AutoOptions) AutoSettings {
+	var s AutoSettings
+	switch opts.WhiteBalance {
+	case GrayWorld:
+		s.Temperature, s.Tint = neutralise(grayWorld(img))
+	case WhitePatch:
+		s.Temperature, s.Tint = neutralise(whitePatch(img))
+	}
+	if !opts.Levels && !opts.Exposure {
+		return s
+	}
+
+	// Luminance histogram as it will be after the white balance
+	gainR, gainG, gainB := whiteBalanceGains(s.Temperature, s.Tint)
+	var r, g, b [256]uint8
+	for i := range r {
+		r[i] = linearToSRGB(toLinear[i] * gainR)
+		g[i] = linearToSRGB(toLinear[i] * gainG)
+		b[i] = linearToSRGB(toLinear[i] * gainB)
+	}
+	var hist [256]int
+	total := 0
+	eachOpaque(img, func(pr, pg, pb uint8) {
+		hist[(54*int(r[pr])+183*int(g[pg])+19*int(b[pb]))>>8]++
+		total++
+	})
+	if total == 0 {
+		return s
+	}
+
+	black, white := 0.0, 255.0
+	if opts.Levels {
+		black = float64(percentile(&hist, total, autoClip))
+		white = float64(percentile(&hist, total, 1-autoClip))
+		// Leave low-key and high-key images their character, and do not
+		// stretch a nearly flat image into noise
+		black = math.Min(black, 64)
+		white = math.Max(white, 192)
+		if white-black < 32 {
+			black, white = 0, 255
+		}
+	}
+	s.Levels.RGB = Level{Black: black, White: white}
+	if white == 255 {
+		s.Levels.RGB.White = 0
+	}
+
+	if opts.Exposure {
+		median := (float64(percentile(&hist, total, 0.5)) - black) / (white - black)
+		median = math.Max(0.02, math.Min(0.98, median))
+		gamma := math.Log(median) / math.Log(autoMidtone)
+		// A gamma between 1/1.5 and 1.5 brightens or darkens the midtones
+		// by about a stop at most; small corrections are not worth making
+		gamma = math.Max(1/1.5, math.Min(1.5, gamma))
+		if math.Abs(math.Log2(gamma)) >= 0.05 {
+			s.Levels.RGB.Gamma = math.Round(gamma*100) / 100
+		}
+	}
+	return s
+}
+
+// whiteBalanceGains returns the linear channel gains WhiteBalance applies.
+func whiteBalanceGains(temperature, tint float64) (float64, float64, float64) {
+	t, m := clampSlider(temperature), clampSlider(tint)
+	// ±100 corresponds to about half a stop per channel
+	gainR := math.Exp2(0.5 * t)
+	gainB := math.Exp2(-0.5 * t)
+	gainG := math.Exp2(-0.4 * m)
+	norm := lumaR*gainR + lumaG*gainG + lumaB*gainB
+	return gainR / norm, gainG / norm, gainB / norm
+}
+
+// neutralise returns the temperature and tint sliders that make the linear
+// colour c neutral, by inverting the gains of WhiteBalance.
+func neutralise(c [3]float64) (float64, float64) {
+	if c[0] <= 0 || c[1] <= 0 || c[2] <= 0 {
+		return 0, 0
+	}
+	temperature := 100 * math.Log2(c[2]/c[0])
+	tint := -250 * math.Log2(math.Sqrt(c[0]*c[2])/c[1])
+	round := func(v float64) float64 {
+		v = math.Round(math.Max(-100, math.Min(100, v)))
+		if v == 0 {
+			return 0 // not -0
+		}
+		return v
+	}
+	return round(temperature), round(tint)
+}
+
+// grayWorld averages the linear colour of the pixels that are neither
+// clipped nor nearly black.
+func grayWorld(img *image.NRGBA) [3]float64 {
+	var sum [3]float64
+	eachOpaque(img, func(r, g, b uint8) {
+		if max(r, g, b) >= 250 || max(r, g, b) < 8 {
+			return
+		}
+		sum[0] += toLinear[r]
+		sum[1] += toLinear[g]
+		sum[2] += toLinear[b]
+	})
+	return sum
+}
+
+// whitePatch averages the linear colour of the brightest percent of the
+// pixels that are not clipped.
+func whitePatch(img *image.NRGBA) [3]float64 {
+	var hist [256]int
+	total := 0
+	eachOpaque(img, func(r, g, b uint8) {
+		if max(r, g, b) < 250 {
+			hist[(54*int(r)+183*int(g)+19*int(b))>>8]++
+			total++
+		}
+	})
+	if total == 0 {
+		return [3]float64{}
+	}
+	threshold := percentile(&hist, total, 0.99)
+
+	var sum [3]float64
+	eachOpaque(img, func(r, g, b uint8) {
+		if max(r, g, b) < 250 && (54*int(r)+183*int(g)+19*int(b))>>8 >= threshold {
+			sum[0] += toLinear[r]
+			sum[1] += toLinear[g]
+			sum[2] += toLinear[b]
+		}
+	})
+	return sum
+}
+
+// eachOpaque calls fn with the colour of every pixel that is not fully
+// transparent.
+func eachOpaque(img *image.NRGBA, fn func(r, g, b uint8)) {
+	w := img.Rect.Dx() * 4
+	for y := 0; y < img.Rect.Dy(); y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+w]
+		for i := 0; i < len(row); i += 4 {
+			if row[i+3] != 0 {
+				fn(row[i], row[i+1], row[i+2])
+			}
+		}
+	}
+}
+
+// percentile returns the level below which fraction p of the total lies.
+func percentile(hist *[256]int, total int, p float64) int {
+	target := int(p * float64(total))
+	n := 0
+	for i, count := range hist {
+		n += count
+		if n > target {
+			return i
+		}
+	}
+	return 255
+}
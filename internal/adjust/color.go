@@ -0,0 +1,171 @@
+// Package adjust implements the pixel-level colour and tone adjustments of
+// the editor. All functions modify an *image.NRGBA in place and take slider
+// values in the range -100..100, where 0 leaves the image unchanged.
+package adjust
+
+import (
+	"image"
+	"math"
+)
+
+// Rec. 709 luma weights.
+const (
+	lumaR = 0.2126
+	lumaG = 0.7152
+	lumaB = 0.0722
+)
+
+var (
+	// toLinear maps an 8-bit sRGB value to linear light.
+	toLinear [256]float64
+	// fromLinear maps linear light, quantised to 4096 steps, back to sRGB.
+	fromLinear [4096]uint8
+)
+
+func init() {
+	for i := range toLinear {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			toLinear[i] = v / 12.92
+		} else {
+			toLinear[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	for i := range fromLinear {
+		v := float64(i) / float64(len(fromLinear)-1)
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		fromLinear[i] = clamp8(v * 255)
+	}
+}
+
+func linearToSRGB(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 255
+	}
+	return fromLinear[int(v*float64(len(fromLinear)-1)+0.5)]
+}
+
+func clamp8(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+func clampSlider(v float64) float64 {
+	return math.Max(-100, math.Min(100, v)) / 100
+}
+
+// applyLUT maps every colour channel through its lookup table.
+func applyLUT(img *image.NRGBA, r, g, b *[256]uint8) {
+	ForEachRow(img, func(_ int, row []uint8) {
+		for i := 0; i < len(row); i += 4 {
+			row[i] = r[row[i]]
+			row[i+1] = g[row[i+1]]
+			row[i+2] = b[row[i+2]]
+		}
+	})
+}
+
+// WhiteBalance shifts the colour temperature (blue-yellow axis) and tint
+// (green-magenta axis) by scaling the channels in linear light, the way a
+// camera applies white balance. Positive temperature warms the image and
+// positive tint adds magenta. Gains are normalised so luminance is kept.
+func WhiteBalance(img *image.NRGBA, temperature, tint float64) {
+	t, m := clampSlider(temperature), clampSlider(tint)
+	if t == 0 && m == 0 {
+		return
+	}
+
+	gainR, gainG, gainB := whiteBalanceGains(temperature, tint)
+	var r, g, b [256]uint8
+	for i := range r {
+		r[i] = linearToSRGB(toLinear[i] * gainR)
+		g[i] = linearToSRGB(toLinear[i] * gainG)
+		b[i] = linearToSRGB(toLinear[i] * gainB)
+	}
+	applyLUT(img, &r, &g, &b)
+}
+
+// ShadowsHighlights brightens or darkens the dark and bright tones
+// separately. Each slider drives a smooth tone curve on luminance whose
+// effect is weighted by a luminance mask, so midtones move little and black
+// and white points stay fixed. Pixels are scaled as a whole to keep hue.
+func ShadowsHighlights(img *image.NRGBA, shadows, highlights float64) {
+	s, h := clampSlider(shadows), clampSlider(highlights)
+	if s == 0 && h == 0 {
+		return
+	}
+
+	// The masks peak at a quarter and three quarters of the range; the
+	// strengths are the largest that keep each curve monotonic
+	shadowCurve := func(l float64) float64 {
+		mask := (1 - l) * (1 - l) * (1 - l)
+		if s > 0 {
+			return l + 2.5*s*l*mask
+		}
+		return l + s*l*mask
+	}
+	highlightCurve := func(l float64) float64 {
+		mask := l * l * (1 - l)
+		if h > 0 {
+			return l + h*l*mask
+		}
+		return l + 2.5*h*l*mask
+	}
+
+	// gain[y] is the factor for pixels with 8-bit luma y
+	var gain [256]float64
+	for i := range gain {
+		l := float64(i) / 255
+		if i == 0 {
+			l = 0.5 / 255
+		}
+		gain[i] = highlightCurve(shadowCurve(l)) / l
+	}
+
+	ForEachRow(img, func(_ int, row []uint8) {
+		for i := 0; i < len(row); i += 4 {
+			luma := (54*int(row[i]) + 183*int(row[i+1]) + 19*int(row[i+2])) >> 8
+			k := gain[luma]
+			row[i] = clamp8(float64(row[i]) * k)
+			row[i+1] = clamp8(float64(row[i+1]) * k)
+			row[i+2] = clamp8(float64(row[i+2]) * k)
+		}
+	})
+}
+
+// Vibrance changes saturation in proportion to how unsaturated a pixel
+// already is, so muted colours gain most while saturated ones (and skin
+// tones, which tend to be) are protected from clipping.
+func Vibrance(img *image.NRGBA, amount float64) {
+	a := clampSlider(amount)
+	if a == 0 {
+		return
+	}
+
+	ForEachRow(img, func(_ int, row []uint8) {
+		for i := 0; i < len(row); i += 4 {
+			r, g, b := float64(row[i]), float64(row[i+1]), float64(row[i+2])
+			sat := (math.Max(r, math.Max(g, b)) - math.Min(r, math.Min(g, b))) / 255
+			factor := 1 + a*(1-sat)
+			if a > 0 {
+				factor = 1 + a*(1-sat)*(1-sat)
+			}
+			luma := lumaR*r + lumaG*g + lumaB*b
+			row[i] = clamp8(luma + (r-luma)*factor)
+			row[i+1] = clamp8(luma + (g-luma)*factor)
+			row[i+2] = clamp8(luma + (b-luma)*factor)
+		}
+	})
+}
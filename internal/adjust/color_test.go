@@ -0,0 +1,130 @@
+package adjust
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// greyRamp returns a 256×1 image with every grey level from black to white.
+func greyRamp() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{uint8(x), uint8(x), uint8(x), 255})
+	}
+	return img
+}
+
+// linearLuma returns the luminance of an 8-bit sRGB colour in linear light.
+func linearLuma(r, g, b uint8) float64 {
+	return lumaR*toLinear[r] + lumaG*toLinear[g] + lumaB*toLinear[b]
+}
+
+func TestWhiteBalanceKeepsGreysNeutral(t *testing.T) {
+	for _, tc := range []struct{ temperature, tint float64 }{
+		{100, 0}, {-100, 0}, {0, 100}, {0, -100}, {60, -40}, {-30, 80},
+	} {
+		img := greyRamp()
+		WhiteBalance(img, tc.temperature, tc.tint)
+		WhiteBalance(img, -tc.temperature, -tc.tint)
+		// Unclipped greys come back neutral, up to the rounding of two LUTs
+		for x := 16; x < 200; x++ {
+			p := img.NRGBAAt(x, 0)
+			lo := min(p.R, p.G, p.B)
+			hi := max(p.R, p.G, p.B)
+			if hi-lo > 2 {
+				t.Errorf("WhiteBalance(%v, %v) and back: grey %d became %v", tc.temperature, tc.tint, x, p)
+				break
+			}
+		}
+	}
+}
+
+func TestWhiteBalanceNeutralisesCast(t *testing.T) {
+	// A grey with a cast is made neutral by the sliders auto white balance
+	// derives from it
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{140, 128, 116, 255})
+	p := img.NRGBAAt(0, 0)
+	temperature, tint := neutralise([3]float64{toLinear[p.R], toLinear[p.G], toLinear[p.B]})
+	WhiteBalance(img, temperature, tint)
+	p = img.NRGBAAt(0, 0)
+	if hi, lo := max(p.R, p.G, p.B), min(p.R, p.G, p.B); hi-lo > 3 {
+		t.Errorf("WhiteBalance(%v, %v) left a cast: %v", temperature, tint, p)
+	}
+}
+
+func TestWhiteBalancePreservesLuminance(t *testing.T) {
+	for _, tc := range []struct{ temperature, tint float64 }{
+		{100, 0}, {-100, 0}, {0, 100}, {0, -100}, {50, 50}, {-70, -20},
+	} {
+		img := greyRamp()
+		WhiteBalance(img, tc.temperature, tc.tint)
+		// Bright greys clip in the boosted channel, so stop below that. The
+		// LUTs round every channel, so allow an 8-bit step of error.
+		for x := 16; x < 180; x++ {
+			want := linearLuma(uint8(x), uint8(x), uint8(x))
+			step := toLinear[x+1] - toLinear[x]
+			p := img.NRGBAAt(x, 0)
+			if got := linearLuma(p.R, p.G, p.B); math.Abs(got-want) > step {
+				t.Errorf("WhiteBalance(%v, %v): luminance of grey %d changed from %.4f to %.4f",
+					tc.temperature, tc.tint, x, want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestShadowsHighlightsMonotonic(t *testing.T) {
+	for _, tc := range []struct{ shadows, highlights float64 }{
+		{100, 0}, {-100, 0}, {0, 100}, {0, -100},
+		{100, 100}, {-100, -100}, {100, -100}, {-100, 100}, {40, -70},
+	} {
+		img := greyRamp()
+		ShadowsHighlights(img, tc.shadows, tc.highlights)
+		for x := 1; x < 256; x++ {
+			if prev, cur := img.Pix[(x-1)*4], img.Pix[x*4]; cur < prev {
+				t.Errorf("ShadowsHighlights(%v, %v): grey %d maps to %d, below %d for grey %d",
+					tc.shadows, tc.highlights, x, cur, prev, x-1)
+				break
+			}
+		}
+		if black := img.NRGBAAt(0, 0); black != (color.NRGBA{0, 0, 0, 255}) {
+			t.Errorf("ShadowsHighlights(%v, %v): black became %v", tc.shadows, tc.highlights, black)
+		}
+		if white := img.NRGBAAt(255, 0); white != (color.NRGBA{255, 255, 255, 255}) {
+			t.Errorf("ShadowsHighlights(%v, %v): white became %v", tc.shadows, tc.highlights, white)
+		}
+	}
+}
+
+func TestVibranceKeepsSaturatedColours(t *testing.T) {
+	saturated := []color.NRGBA{
+		{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255},
+		{255, 255, 0, 255}, {0, 255, 255, 255}, {255, 0, 255, 255},
+		{255, 128, 0, 255}, {0, 64, 255, 255},
+	}
+	for _, amount := range []float64{100, 50, -50, -100} {
+		img := image.NewNRGBA(image.Rect(0, 0, len(saturated), 1))
+		for x, c := range saturated {
+			img.SetNRGBA(x, 0, c)
+		}
+		Vibrance(img, amount)
+		for x, want := range saturated {
+			if got := img.NRGBAAt(x, 0); got != want {
+				t.Errorf("Vibrance(%v): %v became %v", amount, want, got)
+			}
+		}
+	}
+}
+
+func TestVibranceMovesMutedColours(t *testing.T) {
+	muted := color.NRGBA{140, 120, 110, 255}
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.SetNRGBA(0, 0, muted)
+	Vibrance(img, 100)
+	if got := img.NRGBAAt(0, 0); int(got.R)-int(got.B) <= int(muted.R)-int(muted.B) {
+		t.Errorf("Vibrance(100): %v did not gain saturation, got %v", muted, got)
+	}
+}
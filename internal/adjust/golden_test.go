@@ -0,0 +1,125 @@
+package adjust
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The golden tests run the colour tools on testdata/chart.png, a hue sweep
+// over dark to light, a grey ramp and a band of muted colours, and compare
+// the results with the reference renders in testdata/golden. After a
+// deliberate change, rewrite the references with
+//
+//	go test ./internal/adjust -run Golden -update
+//
+// and look at them before committing.
+var update = flag.Bool("update", false, "rewrite the golden images in testdata/golden")
+
+// goldenTolerance is how many 8-bit steps a channel may differ from the
+// reference, for floating point that rounds differently across platforms.
+const goldenTolerance = 1
+
+func TestGolden(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		apply func(img *image.NRGBA)
+	}{
+		{"temperature_warm", func(img *image.NRGBA) { WhiteBalance(img, 50, 0) }},
+		{"temperature_cool", func(img *image.NRGBA) { WhiteBalance(img, -50, 0) }},
+		{"tint_magenta", func(img *image.NRGBA) { WhiteBalance(img, 0, 50) }},
+		{"tint_green", func(img *image.NRGBA) { WhiteBalance(img, 0, -50) }},
+		{"shadows_lift", func(img *image.NRGBA) { ShadowsHighlights(img, 60, 0) }},
+		{"shadows_crush", func(img *image.NRGBA) { ShadowsHighlights(img, -60, 0) }},
+		{"highlights_recover", func(img *image.NRGBA) { ShadowsHighlights(img, 0, -60) }},
+		{"highlights_boost", func(img *image.NRGBA) { ShadowsHighlights(img, 0, 60) }},
+		{"vibrance_boost", func(img *image.NRGBA) { Vibrance(img, 60) }},
+		{"vibrance_mute", func(img *image.NRGBA) { Vibrance(img, -60) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			img := readPNG(t, filepath.Join("testdata", "chart.png"))
+			tc.apply(img)
+
+			path := filepath.Join("testdata", "golden", tc.name+".png")
+			if *update {
+				writePNG(t, path, img)
+				return
+			}
+			if err := compareImages(img, readPNG(t, path), goldenTolerance); err != nil {
+				t.Errorf("differs from %s: %v", path, err)
+			}
+		})
+	}
+}
+
+// compareImages reports the first pixel of got that differs from want by
+// more than tolerance in any channel, and how many do.
+func compareImages(got, want *image.NRGBA, tolerance int) error {
+	if got.Rect != want.Rect {
+		return fmt.Errorf("size is %v, want %v", got.Rect, want.Rect)
+	}
+	var first error
+	bad := 0
+	for y := got.Rect.Min.Y; y < got.Rect.Max.Y; y++ {
+		for x := got.Rect.Min.X; x < got.Rect.Max.X; x++ {
+			g, w := got.NRGBAAt(x, y), want.NRGBAAt(x, y)
+			diff := max(absDiff(g.R, w.R), absDiff(g.G, w.G), absDiff(g.B, w.B), absDiff(g.A, w.A))
+			if diff <= tolerance {
+				continue
+			}
+			if bad++; first == nil {
+				first = fmt.Errorf("(%d, %d) is %v, want %v", x, y, g, w)
+			}
+		}
+	}
+	if first != nil {
+		return fmt.Errorf("%v; %d pixels off by more than %d", first, bad, tolerance)
+	}
+	return nil
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func readPNG(t *testing.T, path string) *image.NRGBA {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	src, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewNRGBA(src.Bounds())
+	draw.Draw(img, img.Rect, src, src.Bounds().Min, draw.Src)
+	return img
+}
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
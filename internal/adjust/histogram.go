@@ -0,0 +1,33 @@
+package adjust
+
+import "image"
+
+// Histogram holds per-channel pixel counts for the 256 levels. Fully
+// transparent pixels are not counted.
+type Histogram struct {
+	Pixels    int      `json:"pixels"`
+	Red       [256]int `json:"red"`
+	Green     [256]int `json:"green"`
+	Blue      [256]int `json:"blue"`
+	Luminance [256]int `json:"luminance"`
+}
+
+func ComputeHistogram(img *image.NRGBA) *Histogram {
+	h := &Histogram{}
+	w := img.Rect.Dx() * 4
+	for y := 0; y < img.Rect.Dy(); y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+w]
+		for i := 0; i < len(row); i += 4 {
+			if row[i+3] == 0 {
+				continue
+			}
+			r, g, b := row[i], row[i+1], row[i+2]
+			h.Red[r]++
+			h.Green[g]++
+			h.Blue[b]++
+			h.Luminance[(54*int(r)+183*int(g)+19*int(b))>>8]++
+			h.Pixels++
+		}
+	}
+	return h
+}
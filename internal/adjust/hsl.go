@@ -0,0 +1,197 @@
+package adjust
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// colourRanges are the hue bands of the HSL tool and their centre hues in
+// degrees. A pixel's hue blends the two neighbouring bands linearly.
+var colourRanges = []struct {
+	name string
+	hue  float64
+}{
+	{"red", 0}, {"orange", 30}, {"yellow", 60}, {"green", 120},
+	{"aqua", 180}, {"blue", 240}, {"purple", 270}, {"magenta", 300},
+}
+
+// HSLShift adjusts one colour range. Hue -100..100 rotates by up to 30
+// degrees; Saturation and Luminance are -100..100.
+type HSLShift struct {
+	Hue        float64 `json:"hue"`
+	Saturation float64 `json:"saturation"`
+	Luminance  float64 `json:"luminance"`
+}
+
+// HSL maps colour range names (red, orange, yellow, green, aqua, blue,
+// purple, magenta) to their adjustments.
+type HSL map[string]HSLShift
+
+func (h HSL) Validate() error {
+	for name := range h {
+		if rangeIndex(name) < 0 {
+			return fmt.Errorf("hsl: unknown colour range %q", name)
+		}
+	}
+	return nil
+}
+
+func rangeIndex(name string) int {
+	for i, r := range colourRanges {
+		if r.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// ApplyHSL shifts hue, saturation and luminance per colour range. Grey
+// pixels have no hue and are left alone; the effect grows with saturation.
+func ApplyHSL(img *image.NRGBA, h HSL) {
+	var shifts [8]HSLShift
+	active := false
+	for name, s := range h {
+		if i := rangeIndex(name); i >= 0 && s != (HSLShift{}) {
+			shifts[i] = HSLShift{clampSlider(s.Hue) * 30, clampSlider(s.Saturation), clampSlider(s.Luminance)}
+			active = true
+		}
+	}
+	if !active {
+		return
+	}
+
+	ForEachRow(img, func(_ int, row []uint8) {
+		for i := 0; i < len(row); i += 4 {
+			hue, sat, lum := rgbToHSL(row[i], row[i+1], row[i+2])
+			if sat == 0 {
+				continue
+			}
+			s := blendShift(&shifts, hue)
+			hue = math.Mod(hue+s.Hue+360, 360)
+			sat = math.Max(0, math.Min(1, sat*(1+s.Saturation)))
+			if s.Luminance > 0 {
+				lum += (1 - lum) * s.Luminance * 0.5 * sat
+			} else {
+				lum += lum * s.Luminance * 0.5 * sat
+			}
+			row[i], row[i+1], row[i+2] = hslToRGB(hue, sat, lum)
+		}
+	})
+}
+
+// blendShift interpolates the shifts of the two ranges around hue.
+func blendShift(shifts *[8]HSLShift, hue float64) HSLShift {
+	n := len(colourRanges)
+	i := n - 1
+	for k := 0; k < n; k++ {
+		if colourRanges[k].hue > hue {
+			i = k - 1
+			break
+		}
+	}
+	j := (i + 1) % n
+	start, end := colourRanges[i].hue, colourRanges[j].hue
+	if end <= start {
+		end += 360
+	}
+	t := (hue - start) / (end - start)
+	a, b := shifts[i], shifts[j]
+	return HSLShift{
+		Hue:        a.Hue + (b.Hue-a.Hue)*t,
+		Saturation: a.Saturation + (b.Saturation-a.Saturation)*t,
+		Luminance:  a.Luminance + (b.Luminance-a.Luminance)*t,
+	}
+}
+
+func rgbToHSL(r8, g8, b8 uint8) (h, s, l float64) {
+	r, g, b := float64(r8)/255, float64(g8)/255, float64(b8)/255
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	l = (max + min) / 2
+	d := max - min
+	if d == 0 {
+		return 0, 0, l
+	}
+	if l > 0.5 {
+		s = d / (2 - max - min)
+	} else {
+		s = d / (max + min)
+	}
+	switch max {
+	case r:
+		h = math.Mod((g-b)/d+6, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	return h * 60, s, l
+}
+
+func hslToRGB(h, s, l float64) (uint8, uint8, uint8) {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return clamp8((r + m) * 255), clamp8((g + m) * 255), clamp8((b + m) * 255)
+}
+
+// Mixer is a channel mixer. Each output row gives the percentage of the
+// input red, green and blue that makes up that output channel; an omitted
+// row keeps the channel as it is.
+type Mixer struct {
+	Red   []float64 `json:"red"`
+	Green []float64 `json:"green"`
+	Blue  []float64 `json:"blue"`
+}
+
+func (m *Mixer) Validate() error {
+	for name, row := range map[string][]float64{"red": m.Red, "green": m.Green, "blue": m.Blue} {
+		if len(row) != 0 && len(row) != 3 {
+			return fmt.Errorf("mixer.%s: need 3 values", name)
+		}
+		for _, v := range row {
+			if v < -200 || v > 200 {
+				return fmt.Errorf("mixer.%s: values must be between -200 and 200", name)
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyMixer recomputes every channel as a weighted sum of the inputs.
+func ApplyMixer(img *image.NRGBA, m *Mixer) {
+	if m == nil || len(m.Red)+len(m.Green)+len(m.Blue) == 0 {
+		return
+	}
+	matrix := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	for i, row := range [][]float64{m.Red, m.Green, m.Blue} {
+		if len(row) == 3 {
+			matrix[i] = [3]float64{row[0] / 100, row[1] / 100, row[2] / 100}
+		}
+	}
+
+	ForEachRow(img, func(_ int, row []uint8) {
+		for i := 0; i < len(row); i += 4 {
+			r, g, b := float64(row[i]), float64(row[i+1]), float64(row[i+2])
+			row[i] = clamp8(matrix[0][0]*r + matrix[0][1]*g + matrix[0][2]*b)
+			row[i+1] = clamp8(matrix[1][0]*r + matrix[1][1]*g + matrix[1][2]*b)
+			row[i+2] = clamp8(matrix[2][0]*r + matrix[2][1]*g + matrix[2][2]*b)
+		}
+	})
+}
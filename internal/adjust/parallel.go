@@ -0,0 +1,49 @@
+package adjust
+
+import (
+	"image"
+	"runtime"
+	"sync"
+)
+
+// minParallelPixels is the image size below which rows are processed on
+// the calling goroutine; smaller images finish faster than workers start.
+const minParallelPixels = 64 * 1024
+
+// ForEachRow calls fn with every row of img's pixels, splitting the rows
+// into contiguous bands processed by up to GOMAXPROCS goroutines. y is
+// relative to img.Rect.Min.Y. fn must only write to the row it is given.
+func ForEachRow(img *image.NRGBA, fn func(y int, row []uint8)) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if width <= 0 || height <= 0 {
+		return
+	}
+	rowAt := func(y int) []uint8 {
+		return img.Pix[y*img.Stride : y*img.Stride+width*4]
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > height {
+		workers = height
+	}
+	if workers < 2 || width*height < minParallelPixels {
+		for y := 0; y < height; y++ {
+			fn(y, rowAt(y))
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	band := (height + workers - 1) / workers
+	for start := 0; start < height; start += band {
+		end := min(start+band, height)
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for y := start; y < end; y++ {
+				fn(y, rowAt(y))
+			}
+		}(start, end)
+	}
+	wg.Wait()
+}
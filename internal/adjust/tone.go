@@ -0,0 +1,184 @@
+package adjust
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// Point is a tone curve control point as [input, output], both 0..255.
+type Point [2]float64
+
+// Curves holds tone curves through control points. The per-channel curves
+// are applied first and the RGB curve after them. Curves that do not reach
+// 0 or 255 are anchored at (0,0) and (255,255); an empty curve is identity.
+type Curves struct {
+	RGB   []Point `json:"rgb"`
+	Red   []Point `json:"red"`
+	Green []Point `json:"green"`
+	Blue  []Point `json:"blue"`
+}
+
+func (c *Curves) Validate() error {
+	for name, points := range map[string][]Point{"rgb": c.RGB, "red": c.Red, "green": c.Green, "blue": c.Blue} {
+		seen := make(map[float64]bool, len(points))
+		for _, p := range points {
+			if p[0] < 0 || p[0] > 255 || p[1] < 0 || p[1] > 255 {
+				return fmt.Errorf("curves.%s: points must be within 0..255", name)
+			}
+			if seen[p[0]] {
+				return fmt.Errorf("curves.%s: duplicate input %g", name, p[0])
+			}
+			seen[p[0]] = true
+		}
+	}
+	return nil
+}
+
+// Level maps the input range Black..White onto 0..255 with a midtone gamma.
+// A zero White means 255 and a zero Gamma means 1.
+type Level struct {
+	Black float64 `json:"black"`
+	White float64 `json:"white"`
+	Gamma float64 `json:"gamma"`
+}
+
+func (l Level) isIdentity() bool {
+	return l.Black == 0 && (l.White == 0 || l.White == 255) && (l.Gamma == 0 || l.Gamma == 1)
+}
+
+func (l Level) validate(name string) error {
+	white := l.White
+	if white == 0 {
+		white = 255
+	}
+	if l.Black < 0 || white > 255 || l.Black >= white {
+		return fmt.Errorf("levels.%s: need 0 <= black < white <= 255", name)
+	}
+	if l.Gamma != 0 && (l.Gamma < 0.1 || l.Gamma > 10) {
+		return fmt.Errorf("levels.%s: gamma must be between 0.1 and 10", name)
+	}
+	return nil
+}
+
+// Levels holds per-channel levels, applied before the RGB levels.
+type Levels struct {
+	RGB   Level `json:"rgb"`
+	Red   Level `json:"red"`
+	Green Level `json:"green"`
+	Blue  Level `json:"blue"`
+}
+
+func (l *Levels) Validate() error {
+	for name, level := range map[string]Level{"rgb": l.RGB, "red": l.Red, "green": l.Green, "blue": l.Blue} {
+		if err := level.validate(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyLevels remaps each channel through its levels and then the RGB ones.
+func ApplyLevels(img *image.NRGBA, l *Levels) {
+	if l == nil || (l.RGB.isIdentity() && l.Red.isIdentity() && l.Green.isIdentity() && l.Blue.isIdentity()) {
+		return
+	}
+	master := levelLUT(l.RGB)
+	r, g, b := levelLUT(l.Red), levelLUT(l.Green), levelLUT(l.Blue)
+	for i := range r {
+		r[i], g[i], b[i] = master[r[i]], master[g[i]], master[b[i]]
+	}
+	applyLUT(img, &r, &g, &b)
+}
+
+func levelLUT(l Level) [256]uint8 {
+	white, gamma := l.White, l.Gamma
+	if white == 0 {
+		white = 255
+	}
+	if gamma == 0 {
+		gamma = 1
+	}
+	var lut [256]uint8
+	for i := range lut {
+		v := math.Max(0, math.Min(1, (float64(i)-l.Black)/(white-l.Black)))
+		lut[i] = clamp8(math.Pow(v, 1/gamma) * 255)
+	}
+	return lut
+}
+
+// ApplyCurves remaps each channel through its curve and then the RGB one.
+func ApplyCurves(img *image.NRGBA, c *Curves) {
+	if c == nil || len(c.RGB)+len(c.Red)+len(c.Green)+len(c.Blue) == 0 {
+		return
+	}
+	master := curveLUT(c.RGB)
+	r, g, b := curveLUT(c.Red), curveLUT(c.Green), curveLUT(c.Blue)
+	for i := range r {
+		r[i], g[i], b[i] = master[r[i]], master[g[i]], master[b[i]]
+	}
+	applyLUT(img, &r, &g, &b)
+}
+
+// curveLUT interpolates the control points with a monotone cubic spline
+// (Fritsch-Carlson), which passes through every point without overshoot.
+func curveLUT(points []Point) [256]uint8 {
+	var lut [256]uint8
+	if len(points) == 0 {
+		for i := range lut {
+			lut[i] = uint8(i)
+		}
+		return lut
+	}
+
+	pts := append([]Point(nil), points...)
+	sort.Slice(pts, func(i, j int) bool { return pts[i][0] < pts[j][0] })
+	if pts[0][0] > 0 {
+		pts = append([]Point{{0, 0}}, pts...)
+	}
+	if pts[len(pts)-1][0] < 255 {
+		pts = append(pts, Point{255, 255})
+	}
+
+	n := len(pts)
+	slopes := make([]float64, n-1)
+	for i := range slopes {
+		slopes[i] = (pts[i+1][1] - pts[i][1]) / (pts[i+1][0] - pts[i][0])
+	}
+	tangents := make([]float64, n)
+	tangents[0], tangents[n-1] = slopes[0], slopes[n-2]
+	for i := 1; i < n-1; i++ {
+		if slopes[i-1]*slopes[i] <= 0 {
+			continue
+		}
+		tangents[i] = (slopes[i-1] + slopes[i]) / 2
+	}
+	for i, s := range slopes {
+		if s == 0 {
+			tangents[i], tangents[i+1] = 0, 0
+			continue
+		}
+		a, b := tangents[i]/s, tangents[i+1]/s
+		if d := a*a + b*b; d > 9 {
+			k := 3 / math.Sqrt(d)
+			tangents[i], tangents[i+1] = k*a*s, k*b*s
+		}
+	}
+
+	seg := 0
+	for i := range lut {
+		x := float64(i)
+		for seg < n-2 && x > pts[seg+1][0] {
+			seg++
+		}
+		x0, x1 := pts[seg][0], pts[seg+1][0]
+		h := x1 - x0
+		t := (x - x0) / h
+		t2, t3 := t*t, t*t*t
+		y := (2*t3-3*t2+1)*pts[seg][1] + (t3-2*t2+t)*h*tangents[seg] +
+			(-2*t3+3*t2)*pts[seg+1][1] + (t3-t2)*h*tangents[seg+1]
+		lut[i] = clamp8(y)
+	}
+	return lut
+}
@@ -0,0 +1,102 @@
+package adjust
+
+import (
+	"image"
+	"math"
+)
+
+// Vignette darkens (positive Amount) or lightens (negative Amount) the
+// image towards its edges. Amount is -100..100. The shape parameters are
+// optional and default to Lightroom-like values:
+//
+//   - Midpoint 0..100 (default 50): distance from the centre, as a share of
+//     the distance to the corners, where the falloff is centred.
+//   - Feather 0..100 (default 50): width of the falloff; 0 is a hard edge.
+//   - Roundness -100..100 (default 0): 0 follows the image's aspect ratio,
+//     100 is a circle and -100 approaches a rounded rectangle.
+type Vignette struct {
+	Amount    float64
+	Midpoint  *float64
+	Feather   *float64
+	Roundness *float64
+}
+
+func optional(v *float64, def float64) float64 {
+	if v == nil {
+		return def
+	}
+	return *v
+}
+
+// ApplyVignette applies v centred on img.Rect, in linear light so the
+// falloff looks like a lens rather than a grey overlay.
+func ApplyVignette(img *image.NRGBA, v Vignette) {
+	amount := clampSlider(v.Amount)
+	if amount == 0 {
+		return
+	}
+	midpoint := math.Max(0, math.Min(100, optional(v.Midpoint, 50))) / 100
+	feather := math.Max(0, math.Min(100, optional(v.Feather, 50))) / 100
+	roundness := clampSlider(optional(v.Roundness, 0))
+
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if width == 0 || height == 0 {
+		return
+	}
+
+	// Half axes of the vignette ellipse; roundness moves them towards a
+	// circle of the same area or, when negative, squares off the shape
+	ax, ay := float64(width)/2, float64(height)/2
+	exponent := 2.0
+	if roundness > 0 {
+		r := math.Sqrt(ax * ay)
+		ax += (r - ax) * roundness
+		ay += (r - ay) * roundness
+	} else {
+		exponent += -6 * roundness
+	}
+
+	// Column terms are the same for every row
+	xs := make([]float64, width)
+	for x := range xs {
+		xs[x] = math.Pow(math.Abs((float64(x)+0.5)/ax-float64(width)/2/ax), exponent)
+	}
+
+	// The falloff is tabulated against the distance raised to the exponent,
+	// which avoids a root per pixel; the corners map to the last entry
+	cornerP := math.Pow(float64(width)/2/ax, exponent) + math.Pow(float64(height)/2/ay, exponent)
+	inner := midpoint * (1 - feather)
+	outer := midpoint + (1-midpoint)*feather
+	var falloff [4096]float64
+	for i := range falloff {
+		d := math.Pow(float64(i)/float64(len(falloff)-1), 1/exponent)
+		switch {
+		case d <= inner:
+		case d >= outer:
+			falloff[i] = 1
+		default:
+			t := (d - inner) / (outer - inner)
+			falloff[i] = t * t * (3 - 2*t)
+		}
+	}
+	scale := float64(len(falloff)-1) / cornerP
+
+	ForEachRow(img, func(y int, row []uint8) {
+		yt := math.Pow(math.Abs((float64(y)+0.5)/ay-float64(height)/2/ay), exponent)
+		for x, i := 0, 0; i < len(row); x, i = x+1, i+4 {
+			t := falloff[min(int((xs[x]+yt)*scale+0.5), len(falloff)-1)]
+			if t == 0 {
+				continue
+			}
+			for c := i; c < i+3; c++ {
+				l := toLinear[row[c]]
+				if amount > 0 {
+					l *= 1 - amount*t
+				} else {
+					l += (1 - l) * -amount * t
+				}
+				row[c] = linearToSRGB(l)
+			}
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package adjust
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// uniform returns an image of the given bounds filled with one grey.
+func uniform(r image.Rectangle, grey uint8) *image.NRGBA {
+	img := image.NewNRGBA(r)
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = grey, grey, grey, 255
+	}
+	return img
+}
+
+func TestVignetteCentredOnCrop(t *testing.T) {
+	// A crop of a larger image keeps its parent's coordinates, so Rect.Min
+	// is not the origin and Pix starts inside the parent's buffer
+	parent := uniform(image.Rect(0, 0, 400, 300), 180)
+	crop := parent.SubImage(image.Rect(120, 70, 320, 220)).(*image.NRGBA)
+	ApplyVignette(crop, Vignette{Amount: 80})
+
+	w, h := crop.Rect.Dx(), crop.Rect.Dy()
+	at := func(x, y int) color.NRGBA {
+		return crop.NRGBAAt(crop.Rect.Min.X+x, crop.Rect.Min.Y+y)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := at(x, y)
+			if q := at(w-1-x, y); p != q {
+				t.Fatalf("not symmetric left to right: (%d, %d) is %v, (%d, %d) is %v", x, y, p, w-1-x, y, q)
+			}
+			if q := at(x, h-1-y); p != q {
+				t.Fatalf("not symmetric top to bottom: (%d, %d) is %v, (%d, %d) is %v", x, y, p, x, h-1-y, q)
+			}
+		}
+	}
+	if centre, corner := at(w/2, h/2), at(0, 0); centre.R != 180 || corner.R >= centre.R {
+		t.Errorf("centre is %v and corner %v, want the centre untouched and the corner darker", centre, corner)
+	}
+
+	// The same pixels as a standalone image get the same vignette
+	standalone := uniform(image.Rect(0, 0, w, h), 180)
+	ApplyVignette(standalone, Vignette{Amount: 80})
+	for y := 0; y < h; y++ {
+		row := crop.Pix[y*crop.Stride : y*crop.Stride+w*4]
+		if !bytes.Equal(row, standalone.Pix[y*standalone.Stride:(y+1)*standalone.Stride]) {
+			t.Fatalf("row %d of the crop differs from the standalone image", y)
+		}
+	}
+
+	// and the rest of the parent is left alone
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 400; x++ {
+			if (image.Point{x, y}).In(crop.Rect) {
+				continue
+			}
+			if p := parent.NRGBAAt(x, y); p.R != 180 {
+				t.Fatalf("pixel (%d, %d) outside the crop changed to %v", x, y, p)
+			}
+		}
+	}
+}
+
+// benchmarkImage is the size of a 24-megapixel photo.
+var benchmarkImage = image.Rect(0, 0, 6000, 4000)
+
+func BenchmarkApplyVignette(b *testing.B) {
+	img := uniform(benchmarkImage, 180)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ApplyVignette(img, Vignette{Amount: 50})
+	}
+}
+
+// BenchmarkVignetteAtSet runs the vignette the editor used before
+// ApplyVignette, converting every pixel through At and Set.
+func BenchmarkVignetteAtSet(b *testing.B) {
+	img := uniform(benchmarkImage, 180)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vignetteAtSet(img, 50)
+	}
+}
+
+func vignetteAtSet(src image.Image, vignette float64) image.Image {
+	bounds := src.Bounds()
+	vignetteImg := image.NewRGBA(bounds)
+	centerX := bounds.Dx() / 2
+	centerY := bounds.Dy() / 2
+	maxDist := float64(centerX)
+	if centerY > centerX {
+		maxDist = float64(centerY)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			dx := float64(x - centerX)
+			dy := float64(y - centerY)
+			dist := math.Sqrt(dx*dx + dy*dy)
+			factor := 1.0 - (dist/maxDist)*(vignette*0.01)
+			if factor < 0 {
+				factor = 0
+			}
+
+			c := color.RGBAModel.Convert(src.At(x, y)).(color.RGBA)
+			c.R = uint8(float64(c.R) * factor)
+			c.G = uint8(float64(c.G) * factor)
+			c.B = uint8(float64(c.B) * factor)
+			vignetteImg.Set(x, y, c)
+		}
+	}
+	return vignetteImg
+}
@@ -0,0 +1,123 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// FakeProvider describes images from simple pixel statistics. It needs no
+// network access and always gives the same answer for the same image, which
+// makes it suitable for local runs and tests.
+type FakeProvider struct{}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{}
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) Configured() bool {
+	return true
+}
+
+func (p *FakeProvider) Describe(ctx context.Context, data []byte, mimeType string) (*Description, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	orientation := "square"
+	switch {
+	case bounds.Dx() > bounds.Dy():
+		orientation = "landscape"
+	case bounds.Dy() > bounds.Dx():
+		orientation = "portrait"
+	}
+
+	// Average colour of a small copy is enough for tone and hue
+	small := imaging.Resize(img, 32, 32, imaging.Box)
+	var r, g, b float64
+	for i := 0; i < len(small.Pix); i += 4 {
+		r += float64(small.Pix[i])
+		g += float64(small.Pix[i+1])
+		b += float64(small.Pix[i+2])
+	}
+	n := float64(len(small.Pix) / 4)
+	r, g, b = r/n, g/n, b/n
+
+	tone := "balanced"
+	switch luma := 0.299*r + 0.587*g + 0.114*b; {
+	case luma < 70:
+		tone = "dark"
+	case luma > 185:
+		tone = "bright"
+	}
+	colour := colourName(r, g, b)
+
+	return &Description{
+		Caption: fmt.Sprintf("A %s %s photo with mostly %s tones.", tone, orientation, colour),
+		Tags:    []string{orientation, tone, colour},
+		AltText: fmt.Sprintf("%s %s image, predominantly %s", capitalize(tone), orientation, colour),
+	}, nil
+}
+
+func colourName(r, g, b float64) string {
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	if max-min < 20 {
+		switch {
+		case max < 60:
+			return "black"
+		case max > 200:
+			return "white"
+		default:
+			return "gray"
+		}
+	}
+
+	var hue float64
+	switch max {
+	case r:
+		hue = math.Mod((g-b)/(max-min), 6)
+	case g:
+		hue = (b-r)/(max-min) + 2
+	default:
+		hue = (r-g)/(max-min) + 4
+	}
+	hue *= 60
+	if hue < 0 {
+		hue += 360
+	}
+
+	switch {
+	case hue < 20 || hue >= 330:
+		return "red"
+	case hue < 45:
+		return "orange"
+	case hue < 70:
+		return "yellow"
+	case hue < 165:
+		return "green"
+	case hue < 200:
+		return "cyan"
+	case hue < 260:
+		return "blue"
+	default:
+		return "purple"
+	}
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return string(s[0]-'a'+'A') + s[1:]
+}
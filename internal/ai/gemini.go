@@ -0,0 +1,179 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultGeminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+	defaultGeminiModel   = "gemini-1.5-flash"
+	// Segmentation masks need a model trained to return them
+	defaultGeminiSegmentModel = "gemini-2.5-flash"
+)
+
+const describePrompt = `Describe this photo for a photo gallery. Respond with JSON only, in the form
+{"caption": "<one sentence caption>", "tags": ["<up to 10 lowercase single-word or short tags>"], "alt_text": "<concise alt text for screen readers, under 125 characters>"}`
+
+// GeminiProvider talks to the Gemini / AI Studio generateContent API.
+type GeminiProvider struct {
+	BaseURL string
+	Model   string
+	Client  *http.Client
+	apiKey  func() string
+}
+
+func NewGeminiProvider(apiKey func() string, model string) *GeminiProvider {
+	if model == "" {
+		model = defaultGeminiModel
+	}
+	return &GeminiProvider{
+		BaseURL: defaultGeminiBaseURL,
+		Model:   model,
+		Client:  &http.Client{Timeout: 60 * time.Second},
+		apiKey:  apiKey,
+	}
+}
+
+func (p *GeminiProvider) Name() string {
+	return "gemini"
+}
+
+func (p *GeminiProvider) Configured() bool {
+	return p.apiKey() != ""
+}
+
+type geminiPart struct {
+	Text       string            `json:"text,omitempty"`
+	InlineData *geminiInlineData `json:"inline_data,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mime_type"`
+	Data     string `json:"data"`
+}
+
+type geminiContent struct {
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiRequest struct {
+	Contents         []geminiContent `json:"contents"`
+	GenerationConfig struct {
+		ResponseMimeType string `json:"response_mime_type"`
+	} `json:"generationConfig"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content geminiContent `json:"content"`
+	} `json:"candidates"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *GeminiProvider) Describe(ctx context.Context, data []byte, mimeType string) (*Description, error) {
+	text, err := p.generate(ctx, []geminiPart{
+		{Text: describePrompt},
+		{InlineData: &geminiInlineData{MimeType: mimeType, Data: base64.StdEncoding.EncodeToString(data)}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseDescription(text)
+}
+
+// generate sends parts to the model and returns the text of its answer,
+// which is requested as JSON.
+func (p *GeminiProvider) generate(ctx context.Context, parts []geminiPart) (string, error) {
+	key := p.apiKey()
+	if key == "" {
+		return "", ErrNotConfigured
+	}
+
+	var req geminiRequest
+	req.Contents = []geminiContent{{Parts: parts}}
+	req.GenerationConfig.ResponseMimeType = "application/json"
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	url := fmt.Sprintf("%s/models/%s:generateContent", strings.TrimRight(p.BaseURL, "/"), p.Model)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-goog-api-key", key)
+
+	resp, err := p.Client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("gemini request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Segmentation answers carry base64 PNG masks and are much larger than
+	// descriptions
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return "", err
+	}
+
+	var parsed geminiResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return "", fmt.Errorf("gemini returned status %d", resp.StatusCode)
+	}
+	if parsed.Error != nil {
+		return "", fmt.Errorf("gemini error %d: %s", parsed.Error.Code, parsed.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gemini returned status %d", resp.StatusCode)
+	}
+	if len(parsed.Candidates) == 0 || len(parsed.Candidates[0].Content.Parts) == 0 {
+		return "", fmt.Errorf("gemini returned no candidates")
+	}
+	return parsed.Candidates[0].Content.Parts[0].Text, nil
+}
+
+// parseDescription decodes the model's JSON answer, tolerating a surrounding
+// markdown code fence.
+func parseDescription(text string) (*Description, error) {
+	var desc Description
+	if err := json.Unmarshal([]byte(stripCodeFence(text)), &desc); err != nil {
+		return nil, fmt.Errorf("invalid description from model: %w", err)
+	}
+
+	tags := make([]string, 0, len(desc.Tags))
+	seen := make(map[string]bool)
+	for _, tag := range desc.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	desc.Tags = tags
+	desc.Caption = strings.TrimSpace(desc.Caption)
+	desc.AltText = strings.TrimSpace(desc.AltText)
+	return &desc, nil
+}
+
+// stripCodeFence removes a markdown code fence around a JSON answer.
+func stripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,95 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// segmentJPEGQuality is the quality of the image sent for segmentation;
+// compression artefacts along edges show up in the returned masks.
+const segmentJPEGQuality = 90
+
+const segmentPrompt = `Give the segmentation masks for %s. Output a JSON list of segmentation masks where each entry contains the 2D bounding box in the key "box_2d", the segmentation mask in key "mask", and the text label in the key "label".`
+
+// geminiMask is one entry of a segmentation answer. The box is [y0, x0,
+// y1, x1] scaled to 0..1000 and the mask a base64 PNG covering the box.
+type geminiMask struct {
+	Box   [4]float64 `json:"box_2d"`
+	Mask  string     `json:"mask"`
+	Label string     `json:"label"`
+}
+
+// Segment asks the model for masks of subject and merges them into one.
+func (p *GeminiProvider) Segment(ctx context.Context, img image.Image, subject string) (*image.Gray, error) {
+	if subject == "" {
+		subject = "the main subject of the photo"
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: segmentJPEGQuality}); err != nil {
+		return nil, err
+	}
+
+	text, err := p.generate(ctx, []geminiPart{
+		{Text: fmt.Sprintf(segmentPrompt, subject)},
+		{InlineData: &geminiInlineData{MimeType: "image/jpeg", Data: base64.StdEncoding.EncodeToString(buf.Bytes())}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var masks []geminiMask
+	if err := json.Unmarshal([]byte(stripCodeFence(text)), &masks); err != nil {
+		return nil, fmt.Errorf("invalid segmentation from model: %w", err)
+	}
+	return mergeMasks(img.Bounds(), masks)
+}
+
+// mergeMasks scales each mask to its box and combines them by maximum.
+func mergeMasks(bounds image.Rectangle, masks []geminiMask) (*image.Gray, error) {
+	w, h := bounds.Dx(), bounds.Dy()
+	out := image.NewGray(image.Rect(0, 0, w, h))
+	for _, m := range masks {
+		box := image.Rect(
+			int(m.Box[1]*float64(w)/1000), int(m.Box[0]*float64(h)/1000),
+			int(m.Box[3]*float64(w)/1000), int(m.Box[2]*float64(h)/1000),
+		)
+		if box.Empty() {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(m.Mask[strings.IndexByte(m.Mask, ',')+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mask from model: %w", err)
+		}
+		decoded, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid mask from model: %w", err)
+		}
+
+		scaled := imaging.Resize(decoded, box.Dx(), box.Dy(), imaging.Linear)
+		for y := 0; y < box.Dy(); y++ {
+			oy := box.Min.Y + y
+			if oy < 0 || oy >= h {
+				continue
+			}
+			for x := 0; x < box.Dx(); x++ {
+				ox := box.Min.X + x
+				if ox < 0 || ox >= w {
+					continue
+				}
+				v := scaled.Pix[y*scaled.Stride+x*4]
+				if i := oy*out.Stride + ox; v > out.Pix[i] {
+					out.Pix[i] = v
+				}
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,245 @@
+package ai
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// localSegmentSize is the long edge the local segmenter works at; the
+	// mask is scaled back up to the image size.
+	localSegmentSize = 512
+	// backgroundColours is the number of colours the border is reduced to.
+	backgroundColours = 4
+	// minColourDistance keeps flat images from being split on noise.
+	minColourDistance = 24
+	// minRegion is the smallest subject region kept, as a fraction of the
+	// image area.
+	minRegion = 0.005
+)
+
+// LocalSegmenter separates the subject from the background without a
+// network service: the colours along the image border are taken to be the
+// background, and the background is whatever is close to them and
+// connected to the border. It works well for product shots and portraits
+// against plain backdrops, needs no configuration and is deterministic,
+// which makes it suitable for local runs and tests. The subject
+// description is ignored.
+type LocalSegmenter struct{}
+
+func NewLocalSegmenter() *LocalSegmenter {
+	return &LocalSegmenter{}
+}
+
+func (s *LocalSegmenter) Name() string {
+	return "local"
+}
+
+func (s *LocalSegmenter) Configured() bool {
+	return true
+}
+
+func (s *LocalSegmenter) Segment(ctx context.Context, img image.Image, subject string) (*image.Gray, error) {
+	bounds := img.Bounds()
+	small := imaging.Clone(img)
+	if bounds.Dx() > localSegmentSize || bounds.Dy() > localSegmentSize {
+		small = imaging.Fit(img, localSegmentSize, localSegmentSize, imaging.Box)
+	}
+	w, h := small.Rect.Dx(), small.Rect.Dy()
+
+	// Distance of every pixel to the nearest background colour
+	centres := borderColours(small)
+	dist := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*small.Stride + x*4
+			c := [3]float64{float64(small.Pix[i]), float64(small.Pix[i+1]), float64(small.Pix[i+2])}
+			d := math.Inf(1)
+			for _, centre := range centres {
+				d = math.Min(d, colourDistance(c, centre))
+			}
+			dist[y*w+x] = d
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	threshold := math.Max(minColourDistance, otsu(dist))
+
+	// Background is flooded in from the border through similar colours
+	background := make([]bool, w*h)
+	var queue []int
+	visit := func(x, y int) {
+		if i := y*w + x; !background[i] && dist[i] < threshold {
+			background[i] = true
+			queue = append(queue, i)
+		}
+	}
+	for x := 0; x < w; x++ {
+		visit(x, 0)
+		visit(x, h-1)
+	}
+	for y := 0; y < h; y++ {
+		visit(0, y)
+		visit(w-1, y)
+	}
+	for len(queue) > 0 {
+		i := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		x, y := i%w, i/w
+		if x > 0 {
+			visit(x-1, y)
+		}
+		if x < w-1 {
+			visit(x+1, y)
+		}
+		if y > 0 {
+			visit(x, y-1)
+		}
+		if y < h-1 {
+			visit(x, y+1)
+		}
+	}
+	dropSpecks(background, w, h, int(minRegion*float64(w*h)))
+
+	// Soften the edge and scale back to the image size
+	mask := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i, bg := range background {
+		v := uint8(255)
+		if bg {
+			v = 0
+		}
+		mask.Pix[i*4], mask.Pix[i*4+1], mask.Pix[i*4+2], mask.Pix[i*4+3] = v, v, v, 255
+	}
+	soft := imaging.Blur(mask, 0.7)
+	if w != bounds.Dx() || h != bounds.Dy() {
+		soft = imaging.Resize(soft, bounds.Dx(), bounds.Dy(), imaging.Linear)
+	}
+	out := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for i := range out.Pix {
+		out.Pix[i] = soft.Pix[i*4]
+	}
+	return out, nil
+}
+
+// borderColours reduces the pixels along the border of img to a few
+// representative colours with k-means.
+func borderColours(img *image.NRGBA) [][3]float64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	band := max(1, min(w, h)/50)
+	var samples [][3]float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x >= band && x < w-band && y >= band && y < h-band {
+				continue
+			}
+			i := y*img.Stride + x*4
+			samples = append(samples, [3]float64{float64(img.Pix[i]), float64(img.Pix[i+1]), float64(img.Pix[i+2])})
+		}
+	}
+
+	k := min(backgroundColours, len(samples))
+	centres := make([][3]float64, k)
+	for i := range centres {
+		centres[i] = samples[i*len(samples)/k]
+	}
+	for iteration := 0; iteration < 8; iteration++ {
+		sums := make([][4]float64, k)
+		for _, s := range samples {
+			best, bestDist := 0, math.Inf(1)
+			for j, c := range centres {
+				if d := colourDistance(s, c); d < bestDist {
+					best, bestDist = j, d
+				}
+			}
+			sums[best][0] += s[0]
+			sums[best][1] += s[1]
+			sums[best][2] += s[2]
+			sums[best][3]++
+		}
+		for j, sum := range sums {
+			if sum[3] > 0 {
+				centres[j] = [3]float64{sum[0] / sum[3], sum[1] / sum[3], sum[2] / sum[3]}
+			}
+		}
+	}
+	return centres
+}
+
+func colourDistance(a, b [3]float64) float64 {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
+
+// otsu returns the threshold that best separates values into two classes.
+func otsu(values []float64) float64 {
+	const bins = 256
+	maxValue := 0.0
+	for _, v := range values {
+		maxValue = math.Max(maxValue, v)
+	}
+	if maxValue == 0 {
+		return 0
+	}
+	var hist [bins]float64
+	for _, v := range values {
+		hist[min(bins-1, int(v/maxValue*(bins-1)))]++
+	}
+
+	total, sum := float64(len(values)), 0.0
+	for i, n := range hist {
+		sum += float64(i) * n
+	}
+	best, bestVariance := 0, 0.0
+	weight, sumBelow := 0.0, 0.0
+	for i, n := range hist {
+		weight += n
+		if weight == 0 || weight == total {
+			continue
+		}
+		sumBelow += float64(i) * n
+		meanBelow := sumBelow / weight
+		meanAbove := (sum - sumBelow) / (total - weight)
+		if variance := weight * (total - weight) * (meanBelow - meanAbove) * (meanBelow - meanAbove); variance > bestVariance {
+			best, bestVariance = i, variance
+		}
+	}
+	return (float64(best) + 1) / (bins - 1) * maxValue
+}
+
+// dropSpecks turns subject regions smaller than minSize pixels into
+// background.
+func dropSpecks(background []bool, w, h, minSize int) {
+	seen := make([]bool, len(background))
+	var region, stack []int
+	for start := range background {
+		if background[start] || seen[start] {
+			continue
+		}
+		region, stack = region[:0], append(stack[:0], start)
+		seen[start] = true
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			region = append(region, i)
+			x, y := i%w, i/w
+			for _, n := range [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+				if n[0] < 0 || n[0] >= w || n[1] < 0 || n[1] >= h {
+					continue
+				}
+				if j := n[1]*w + n[0]; !background[j] && !seen[j] {
+					seen[j] = true
+					stack = append(stack, j)
+				}
+			}
+		}
+		if len(region) < minSize {
+			for _, i := range region {
+				background[i] = true
+			}
+		}
+	}
+}
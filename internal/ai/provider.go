@@ -0,0 +1,39 @@
+// Package ai generates captions, tags and alt text for images through a
+// pluggable provider.
+package ai
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotConfigured is returned when a provider is missing credentials.
+var ErrNotConfigured = errors.New("AI provider is not configured")
+
+type Description struct {
+	Caption string   `json:"caption"`
+	Tags    []string `json:"tags"`
+	AltText string   `json:"alt_text"`
+}
+
+type Provider interface {
+	// Name identifies the provider in logs and API responses.
+	Name() string
+	// Configured reports whether Describe can be called, e.g. an API key is set.
+	Configured() bool
+	// Describe analyses an encoded image.
+	Describe(ctx context.Context, data []byte, mimeType string) (*Description, error)
+}
+
+// New returns the provider registered under name. apiKey is called on every
+// request so that key changes made through the config API apply immediately.
+func New(name, model string, apiKey func() string) (Provider, error) {
+	switch name {
+	case "", "gemini":
+		return NewGeminiProvider(apiKey, model), nil
+	case "fake":
+		return NewFakeProvider(), nil
+	default:
+		return nil, errors.New("unknown AI provider: " + name)
+	}
+}
@@ -0,0 +1,61 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"image"
+)
+
+// Segmenter separates a subject from the background.
+type Segmenter interface {
+	// Name identifies the segmenter in logs and API responses.
+	Name() string
+	// Configured reports whether Segment can be called.
+	Configured() bool
+	// Segment returns a mask with the bounds of img that is 255 where the
+	// subject is and 0 for the background, with soft values along edges.
+	// subject describes what to select; empty means the main subject.
+	Segment(ctx context.Context, img image.Image, subject string) (*image.Gray, error)
+}
+
+// NewSegmenter returns the segmenter registered under name. The remote
+// segmenter falls back to the local one while no API key is configured.
+func NewSegmenter(name, model string, apiKey func() string) (Segmenter, error) {
+	switch name {
+	case "", "gemini":
+		gemini := NewGeminiProvider(apiKey, model)
+		if model == "" {
+			gemini.Model = defaultGeminiSegmentModel
+		}
+		return &fallbackSegmenter{primary: gemini, fallback: NewLocalSegmenter()}, nil
+	case "local":
+		return NewLocalSegmenter(), nil
+	default:
+		return nil, errors.New("unknown segmenter: " + name)
+	}
+}
+
+// fallbackSegmenter uses primary when it is configured and fallback
+// otherwise. Errors from primary are returned, not retried.
+type fallbackSegmenter struct {
+	primary, fallback Segmenter
+}
+
+func (s *fallbackSegmenter) current() Segmenter {
+	if s.primary.Configured() {
+		return s.primary
+	}
+	return s.fallback
+}
+
+func (s *fallbackSegmenter) Name() string {
+	return s.current().Name()
+}
+
+func (s *fallbackSegmenter) Configured() bool {
+	return s.current().Configured()
+}
+
+func (s *fallbackSegmenter) Segment(ctx context.Context, img image.Image, subject string) (*image.Gray, error) {
+	return s.current().Segment(ctx, img, subject)
+}
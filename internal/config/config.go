@@ -0,0 +1,268 @@
+// Package config loads the server configuration. Every setting has a
+// default and can be set in a YAML file, by an environment variable and by
+// a command-line flag, each overriding the ones before:
+//
+//	port: 9000           # goga.yaml
+//	PORT=9000            # environment
+//	goga -port 9000      # flag
+//
+// The file is read from -config or GOGA_CONFIG, or from ./goga.yaml if it
+// exists.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is the configuration file read when none is named.
+const DefaultPath = "goga.yaml"
+
+// Config is the configuration of the server. Fields are bound to their file
+// key, environment variable and flag with the yaml, env and flag tags.
+type Config struct {
+	Port         int    `yaml:"port" env:"PORT" flag:"port" usage:"HTTP port"`
+	DBPath       string `yaml:"db_path" env:"DB_PATH" flag:"db" usage:"SQLite database file"`
+	UploadDir    string `yaml:"upload_dir" env:"UPLOAD_DIR" flag:"upload-dir" usage:"directory images and caches are stored in"`
+	SettingsPath string `yaml:"settings_path" env:"SETTINGS_PATH" flag:"settings" usage:"file the dashboard settings, such as the AI API key, are saved to"`
+
+	// URLSigningKey signs image URLs; a random key is used when it is
+	// empty, so signed URLs expire on restart
+	URLSigningKey string `yaml:"url_signing_key" env:"URL_SIGNING_KEY" flag:"url-signing-key" usage:"secret for HMAC-signed image URLs"`
+
+	AIProvider string `yaml:"ai_provider" env:"AI_PROVIDER" flag:"ai-provider" usage:"AI provider for captions and tags: gemini or fake"`
+	AIModel    string `yaml:"ai_model" env:"AI_MODEL" flag:"ai-model" usage:"model name passed to the AI provider"`
+	Segmenter  string `yaml:"segmenter" env:"SEGMENTER" flag:"segmenter" usage:"subject segmentation: gemini or local"`
+
+	OptimizeUploads bool   `yaml:"optimize_uploads" env:"OPTIMIZE_UPLOADS" flag:"optimize-uploads" usage:"re-encode uploads in the background as small as they get without visible loss"`
+	JobWorkers      int    `yaml:"job_workers" env:"JOB_WORKERS" flag:"job-workers" usage:"number of background jobs that run concurrently"`
+	GeoNamesPath    string `yaml:"geonames_path" env:"GEONAMES_PATH" flag:"geonames" usage:"GeoNames cities dump used for reverse geocoding"`
+
+	Images Images `yaml:"images"`
+}
+
+// Images holds the limits and encoding qualities of image handling.
+type Images struct {
+	MaxUploadMB  int `yaml:"max_upload_mb" env:"MAX_UPLOAD_MB" flag:"max-upload-mb" usage:"largest accepted upload in megabytes"`
+	MaxThumbSize int `yaml:"max_thumb_size" env:"MAX_THUMB_SIZE" flag:"max-thumb-size" usage:"largest ?thumb= size in pixels"`
+	JPEGQuality  int `yaml:"jpeg_quality" env:"JPEG_QUALITY" flag:"jpeg-quality" usage:"JPEG quality of previews and, by default, of converted images"`
+	EditQuality  int `yaml:"edit_quality" env:"EDIT_QUALITY" flag:"edit-quality" usage:"JPEG quality edited images are saved with"`
+
+	// RenderSizes are the widths and heights /render accepts besides the
+	// image's own, which bounds the renditions stored per image
+	RenderSizes []int `yaml:"render_sizes" env:"RENDER_SIZES" flag:"render-sizes" usage:"comma-separated widths and heights /render accepts"`
+}
+
+// MaxUploadSize returns the upload limit in bytes.
+func (i Images) MaxUploadSize() int64 {
+	return int64(i.MaxUploadMB) << 20
+}
+
+// Default returns the configuration used for settings that are not set.
+func Default() Config {
+	return Config{
+		Port:         8080,
+		DBPath:       "./goga.db",
+		UploadDir:    "./uploads",
+		SettingsPath: "./config.json",
+		AIProvider:   "gemini",
+		Segmenter:    "gemini",
+		JobWorkers:   2,
+		Images: Images{
+			MaxUploadMB:  50,
+			MaxThumbSize: 500,
+			JPEGQuality:  85,
+			EditQuality:  95,
+			RenderSizes:  []int{64, 128, 160, 240, 320, 480, 640, 800, 960, 1280, 1600, 1920, 2560, 3840},
+		},
+	}
+}
+
+// Load reads the configuration from the file, the environment and args,
+// the command-line arguments without the program name, and validates it.
+// It returns flag.ErrHelp after printing the usage for -h.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	settings := fields(reflect.ValueOf(&cfg).Elem())
+
+	// Flags are applied last but parsed first, as they may name the file
+	fs := flag.NewFlagSet("goga", flag.ContinueOnError)
+	path := fs.String("config", "", "configuration file (default "+DefaultPath+" if it exists)")
+	for _, s := range settings {
+		switch v := s.value.Interface().(type) {
+		case string:
+			fs.String(s.flag, v, s.usage)
+		case int:
+			fs.Int(s.flag, v, s.usage)
+		case bool:
+			fs.Bool(s.flag, v, s.usage)
+		case []int:
+			fs.String(s.flag, joinInts(v), s.usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	flags := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) { flags[f.Name] = f.Value.String() })
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if *path == "" {
+		*path = os.Getenv("GOGA_CONFIG")
+	}
+	if err := cfg.loadFile(*path); err != nil {
+		return nil, err
+	}
+	for _, s := range settings {
+		if v := os.Getenv(s.env); v != "" {
+			if err := s.set(v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flags[s.flag]; ok {
+			if err := s.set(v); err != nil {
+				return nil, fmt.Errorf("-%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// loadFile reads path over c. An empty path reads DefaultPath if it
+// exists; keys that are not settings are reported as errors.
+func (c *Config) loadFile(path string) error {
+	optional := path == ""
+	if optional {
+		path = DefaultPath
+	}
+	data, err := os.ReadFile(path)
+	if optional && errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once, by its file key.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Port >= 1 && c.Port <= 65535, "port must be between 1 and 65535")
+	check(c.DBPath != "", "db_path must not be empty")
+	check(c.UploadDir != "", "upload_dir must not be empty")
+	check(c.SettingsPath != "", "settings_path must not be empty")
+	check(c.AIProvider == "gemini" || c.AIProvider == "fake", "ai_provider must be gemini or fake")
+	check(c.Segmenter == "gemini" || c.Segmenter == "local", "segmenter must be gemini or local")
+	check(c.JobWorkers >= 1 && c.JobWorkers <= 64, "job_workers must be between 1 and 64")
+	if c.GeoNamesPath != "" {
+		_, err := os.Stat(c.GeoNamesPath)
+		check(err == nil, "geonames_path: %v", err)
+	}
+	check(c.Images.MaxUploadMB >= 1 && c.Images.MaxUploadMB <= 1024, "images.max_upload_mb must be between 1 and 1024")
+	check(c.Images.MaxThumbSize >= 16 && c.Images.MaxThumbSize <= 4096, "images.max_thumb_size must be between 16 and 4096")
+	check(c.Images.JPEGQuality >= 1 && c.Images.JPEGQuality <= 100, "images.jpeg_quality must be between 1 and 100")
+	check(c.Images.EditQuality >= 1 && c.Images.EditQuality <= 100, "images.edit_quality must be between 1 and 100")
+	check(len(c.Images.RenderSizes) > 0, "images.render_sizes must not be empty")
+	for _, size := range c.Images.RenderSizes {
+		check(size >= 1 && size <= 4096, "images.render_sizes must be between 1 and 4096, not %d", size)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// setting is a configuration field with its environment variable and flag.
+type setting struct {
+	value reflect.Value
+	env   string
+	flag  string
+	usage string
+}
+
+// fields lists the settings of a struct, including those of nested structs.
+func fields(v reflect.Value) []setting {
+	var settings []setting
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type.Kind() == reflect.Struct {
+			settings = append(settings, fields(v.Field(i))...)
+			continue
+		}
+		settings = append(settings, setting{
+			value: v.Field(i),
+			env:   f.Tag.Get("env"),
+			flag:  f.Tag.Get("flag"),
+			usage: f.Tag.Get("usage"),
+		})
+	}
+	return settings
+}
+
+// set parses v into the setting.
+func (s setting) set(v string) error {
+	switch s.value.Kind() {
+	case reflect.String:
+		s.value.SetString(v)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", v)
+		}
+		s.value.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", v)
+		}
+		s.value.SetBool(b)
+	case reflect.Slice:
+		var ints []int
+		for _, f := range strings.Split(v, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(f))
+			if err != nil {
+				return fmt.Errorf("%q is not a comma-separated list of whole numbers", v)
+			}
+			ints = append(ints, n)
+		}
+		s.value.Set(reflect.ValueOf(ints))
+	}
+	return nil
+}
+
+func joinInts(ints []int) string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// clearEnv unsets every variable Load reads for the rest of the test.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	cfg := Default()
+	for _, s := range fields(reflect.ValueOf(&cfg).Elem()) {
+		t.Setenv(s.env, "")
+	}
+	t.Setenv("GOGA_CONFIG", "")
+}
+
+func writeConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "goga.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfig(t, `
+port: 9000
+segmenter: local
+optimize_uploads: true
+images:
+  jpeg_quality: 70
+  render_sizes: [100, 200]
+`)
+	def := Default()
+
+	for _, tc := range []struct {
+		name     string
+		file     bool // name the file with GOGA_CONFIG
+		env      map[string]string
+		args     []string
+		port     int
+		quality  int
+		sizes    []int
+		optimize bool
+	}{
+		{"defaults", false, nil, nil, def.Port, def.Images.JPEGQuality, def.Images.RenderSizes, false},
+		{"file", true, nil, nil, 9000, 70, []int{100, 200}, true},
+		{"environment", false, map[string]string{"PORT": "9100", "RENDER_SIZES": "300, 400"}, nil,
+			9100, def.Images.JPEGQuality, []int{300, 400}, false},
+		{"flags", false, nil, []string{"-port", "9200", "-jpeg-quality", "60", "-optimize-uploads"},
+			9200, 60, def.Images.RenderSizes, true},
+		{"environment over file", true, map[string]string{"PORT": "9100", "JPEG_QUALITY": "65", "OPTIMIZE_UPLOADS": "false"}, nil,
+			9100, 65, []int{100, 200}, false},
+		{"flag over environment", false, map[string]string{"PORT": "9100", "JPEG_QUALITY": "65"}, []string{"-port", "9200"},
+			9200, 65, def.Images.RenderSizes, false},
+		{"flag over environment over file", true, map[string]string{"PORT": "9100", "JPEG_QUALITY": "65"},
+			[]string{"-port", "9200", "-render-sizes", "500"}, 9200, 65, []int{500}, true},
+		{"flag naming the file", false, map[string]string{"JPEG_QUALITY": "65"}, []string{"-config", file},
+			9000, 65, []int{100, 200}, true},
+		{"empty variable ignored", true, map[string]string{"PORT": ""}, nil, 9000, 70, []int{100, 200}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clearEnv(t)
+			if tc.file {
+				t.Setenv("GOGA_CONFIG", file)
+			}
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			cfg, err := Load(tc.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Port != tc.port {
+				t.Errorf("port is %d, want %d", cfg.Port, tc.port)
+			}
+			if cfg.Images.JPEGQuality != tc.quality {
+				t.Errorf("images.jpeg_quality is %d, want %d", cfg.Images.JPEGQuality, tc.quality)
+			}
+			if !slices.Equal(cfg.Images.RenderSizes, tc.sizes) {
+				t.Errorf("images.render_sizes is %v, want %v", cfg.Images.RenderSizes, tc.sizes)
+			}
+			if cfg.OptimizeUploads != tc.optimize {
+				t.Errorf("optimize_uploads is %v, want %v", cfg.OptimizeUploads, tc.optimize)
+			}
+			// Settings no layer touches keep their default
+			if cfg.DBPath != def.DBPath || cfg.Images.EditQuality != def.Images.EditQuality {
+				t.Errorf("db_path %q and images.edit_quality %d changed", cfg.DBPath, cfg.Images.EditQuality)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		yaml    string // written and named with -config when set
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{"unknown key", "prot: 9000\n", nil, nil, "field prot not found"},
+		{"wrong type in file", "port: ninety\n", nil, nil, "config file"},
+		{"missing file", "", nil, []string{"-config", "/nonexistent/goga.yaml"}, "config file"},
+		{"missing file from environment", "", map[string]string{"GOGA_CONFIG": "/nonexistent/goga.yaml"}, nil, "config file"},
+		{"bad number in environment", "", map[string]string{"JOB_WORKERS": "two"}, nil, `JOB_WORKERS: "two" is not a whole number`},
+		{"bad bool in environment", "", map[string]string{"OPTIMIZE_UPLOADS": "sometimes"}, nil, "OPTIMIZE_UPLOADS:"},
+		{"bad list flag", "", nil, []string{"-render-sizes", "64,big"}, "-render-sizes:"},
+		{"unknown flag", "", nil, []string{"-colour", "red"}, "flag provided but not defined"},
+		{"argument", "", nil, []string{"serve"}, `unexpected argument "serve"`},
+		{"invalid in file", "port: 0\n", nil, nil, "port must be between 1 and 65535"},
+		{"invalid from flag", "", nil, []string{"-segmenter", "magic"}, "segmenter must be gemini or local"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clearEnv(t)
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			args := tc.args
+			if tc.yaml != "" {
+				args = append([]string{"-config", writeConfig(t, tc.yaml)}, args...)
+			}
+			_, err := Load(args)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error is %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	geonames := filepath.Join(t.TempDir(), "cities500.txt")
+	if err := os.WriteFile(geonames, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		change  func(*Config)
+		wantErr []string // empty when valid
+	}{
+		{"defaults", func(c *Config) {}, nil},
+		{"limits", func(c *Config) {
+			c.Port, c.JobWorkers = 65535, 64
+			c.AIProvider, c.Segmenter = "fake", "local"
+			c.Images.MaxUploadMB, c.Images.MaxThumbSize = 1024, 16
+			c.Images.JPEGQuality, c.Images.EditQuality = 1, 100
+			c.Images.RenderSizes = []int{1, 4096}
+		}, nil},
+		{"geonames file", func(c *Config) { c.GeoNamesPath = geonames }, nil},
+		{"port", func(c *Config) { c.Port = 70000 }, []string{"port must be between 1 and 65535"}},
+		{"empty paths", func(c *Config) { c.DBPath, c.UploadDir, c.SettingsPath = "", "", "" },
+			[]string{"db_path must not be empty", "upload_dir must not be empty", "settings_path must not be empty"}},
+		{"ai provider", func(c *Config) { c.AIProvider = "openai" }, []string{"ai_provider must be gemini or fake"}},
+		{"segmenter", func(c *Config) { c.Segmenter = "" }, []string{"segmenter must be gemini or local"}},
+		{"job workers", func(c *Config) { c.JobWorkers = 0 }, []string{"job_workers must be between 1 and 64"}},
+		{"missing geonames", func(c *Config) { c.GeoNamesPath = geonames + ".missing" }, []string{"geonames_path:"}},
+		{"upload size", func(c *Config) { c.Images.MaxUploadMB = 2048 }, []string{"images.max_upload_mb must be between 1 and 1024"}},
+		{"thumbnail size", func(c *Config) { c.Images.MaxThumbSize = 8 }, []string{"images.max_thumb_size must be between 16 and 4096"}},
+		{"qualities", func(c *Config) { c.Images.JPEGQuality, c.Images.EditQuality = 0, 101 },
+			[]string{"images.jpeg_quality must be between 1 and 100", "images.edit_quality must be between 1 and 100"}},
+		{"no render sizes", func(c *Config) { c.Images.RenderSizes = nil }, []string{"images.render_sizes must not be empty"}},
+		{"render size", func(c *Config) { c.Images.RenderSizes = []int{640, 0, 5000} },
+			[]string{"images.render_sizes must be between 1 and 4096, not 0", "not 5000"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Default()
+			tc.change(&cfg)
+			err := cfg.Validate()
+			if len(tc.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("no error, want %q", tc.wantErr)
+			}
+			// Every problem is reported, and only those
+			lines := strings.Split(err.Error(), "\n")[1:]
+			if len(lines) != len(tc.wantErr) {
+				t.Errorf("error lists %d problems, want %d: %v", len(lines), len(tc.wantErr), err)
+			}
+			for _, want := range tc.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error is %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package faces
+
+import "math"
+
+// DefaultThreshold is the minimum cosine similarity for two faces to be
+// considered the same person.
+const DefaultThreshold = 0.92
+
+// Cluster groups embeddings by average-linkage agglomerative clustering and
+// returns a cluster index for every input.
+func Cluster(embeddings [][]float32, threshold float64) []int {
+	type cluster struct {
+		members  []int
+		centroid []float64
+	}
+
+	clusters := make([]*cluster, len(embeddings))
+	for i, e := range embeddings {
+		centroid := make([]float64, len(e))
+		for k, v := range e {
+			centroid[k] = float64(v)
+		}
+		clusters[i] = &cluster{members: []int{i}, centroid: centroid}
+	}
+
+	for {
+		best, bi, bj := threshold, -1, -1
+		for i := 0; i < len(clusters); i++ {
+			for j := i + 1; j < len(clusters); j++ {
+				if s := similarity64(clusters[i].centroid, clusters[j].centroid); s >= best {
+					best, bi, bj = s, i, j
+				}
+			}
+		}
+		if bi < 0 {
+			break
+		}
+
+		a, b := clusters[bi], clusters[bj]
+		na, nb := float64(len(a.members)), float64(len(b.members))
+		for k := range a.centroid {
+			a.centroid[k] = (a.centroid[k]*na + b.centroid[k]*nb) / (na + nb)
+		}
+		a.members = append(a.members, b.members...)
+		clusters = append(clusters[:bj], clusters[bj+1:]...)
+	}
+
+	labels := make([]int, len(embeddings))
+	for idx, c := range clusters {
+		for _, m := range c.members {
+			labels[m] = idx
+		}
+	}
+	return labels
+}
+
+// Centroid returns the mean of the given embeddings.
+func Centroid(embeddings [][]float32) []float32 {
+	if len(embeddings) == 0 {
+		return nil
+	}
+	sum := make([]float64, len(embeddings[0]))
+	for _, e := range embeddings {
+		for k, v := range e {
+			sum[k] += float64(v)
+		}
+	}
+	centroid := make([]float32, len(sum))
+	for k, v := range sum {
+		centroid[k] = float32(v / float64(len(embeddings)))
+	}
+	return centroid
+}
+
+func similarity64(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
@@ -0,0 +1,85 @@
+package faces
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	lbpFaceSize = 64
+	lbpGrid     = 4
+	lbpBins     = 59 // 58 uniform patterns plus one bin for the rest
+)
+
+// lbpUniform maps each 8-bit LBP code to its histogram bin.
+var lbpUniform = func() [256]uint8 {
+	var table [256]uint8
+	next := uint8(0)
+	for code := 0; code < 256; code++ {
+		transitions := 0
+		for bit := 0; bit < 8; bit++ {
+			if (code>>bit)&1 != (code>>((bit+1)%8))&1 {
+				transitions++
+			}
+		}
+		if transitions <= 2 {
+			table[code] = next
+			next++
+		} else {
+			table[code] = lbpBins - 1
+		}
+	}
+	return table
+}()
+
+// LBPEmbedder describes a face with a grid of uniform local binary pattern
+// histograms. It is lighting tolerant and needs no model files, but it is far
+// less discriminative than a neural embedding; a better Embedder can be
+// plugged in without touching storage or clustering.
+type LBPEmbedder struct{}
+
+func NewLBPEmbedder() *LBPEmbedder {
+	return &LBPEmbedder{}
+}
+
+func (e *LBPEmbedder) Embed(img image.Image, box image.Rectangle) ([]float32, error) {
+	face := imaging.Crop(img, box)
+	face = imaging.Resize(face, lbpFaceSize, lbpFaceSize, imaging.Linear)
+	gray := imaging.Grayscale(face)
+
+	at := func(x, y int) uint8 {
+		return gray.Pix[y*gray.Stride+x*4]
+	}
+
+	cell := lbpFaceSize / lbpGrid
+	hist := make([]float32, lbpGrid*lbpGrid*lbpBins)
+	for y := 1; y < lbpFaceSize-1; y++ {
+		for x := 1; x < lbpFaceSize-1; x++ {
+			center := at(x, y)
+			neighbours := [8]uint8{
+				at(x-1, y-1), at(x, y-1), at(x+1, y-1), at(x+1, y),
+				at(x+1, y+1), at(x, y+1), at(x-1, y+1), at(x-1, y),
+			}
+			code := 0
+			for i, n := range neighbours {
+				if n >= center {
+					code |= 1 << i
+				}
+			}
+			c := (y/cell)*lbpGrid + x/cell
+			hist[c*lbpBins+int(lbpUniform[code])]++
+		}
+	}
+
+	// Hellinger normalisation: square roots of the L1-normalised histogram
+	var sum float64
+	for _, v := range hist {
+		sum += float64(v)
+	}
+	for i, v := range hist {
+		hist[i] = float32(math.Sqrt(float64(v) / sum))
+	}
+	return hist, nil
+}
@@ -0,0 +1,52 @@
+// Package faces finds faces in images and groups them into people.
+package faces
+
+import (
+	"image"
+	"math"
+)
+
+// Detection is a face found in an image, in pixel coordinates of the
+// auto-oriented image.
+type Detection struct {
+	Box   image.Rectangle
+	Score float64
+}
+
+type Detector interface {
+	Detect(img image.Image) ([]Detection, error)
+}
+
+// Embedder turns a face into a vector. Faces of the same person should end
+// up close to each other under cosine similarity.
+type Embedder interface {
+	Embed(img image.Image, box image.Rectangle) ([]float32, error)
+}
+
+// Similarity returns the cosine similarity of two embeddings.
+func Similarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// Overlap returns the intersection over union of two boxes, from 0 for
+// disjoint boxes to 1 for identical ones.
+func Overlap(a, b image.Rectangle) float64 {
+	in := a.Intersect(b)
+	if in.Empty() {
+		return 0
+	}
+	i := float64(in.Dx() * in.Dy())
+	return i / (float64(a.Dx()*a.Dy()+b.Dx()*b.Dy()) - i)
+}
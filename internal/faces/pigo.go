@@ -0,0 +1,83 @@
+package faces
+
+import (
+	_ "embed"
+	"image"
+
+	"github.com/disintegration/imaging"
+	pigo "github.com/esimov/pigo/core"
+)
+
+//go:embed cascade/facefinder
+var facefinderCascade []byte
+
+const (
+	// pigoMaxDimension is the size images are scaled down to before
+	// detection; the cascade does not need more and runs much faster.
+	pigoMaxDimension = 1024
+	pigoMinScore     = 5.0
+	pigoIoU          = 0.2
+)
+
+// PigoDetector is a pure-Go detector based on pixel intensity comparisons.
+type PigoDetector struct {
+	classifier *pigo.Pigo
+}
+
+func NewPigoDetector() (*PigoDetector, error) {
+	classifier, err := pigo.NewPigo().Unpack(facefinderCascade)
+	if err != nil {
+		return nil, err
+	}
+	return &PigoDetector{classifier: classifier}, nil
+}
+
+func (d *PigoDetector) Detect(img image.Image) ([]Detection, error) {
+	bounds := img.Bounds()
+	scale := 1.0
+	if bounds.Dx() > pigoMaxDimension || bounds.Dy() > pigoMaxDimension {
+		img = imaging.Fit(img, pigoMaxDimension, pigoMaxDimension, imaging.Linear)
+		scale = float64(bounds.Dx()) / float64(img.Bounds().Dx())
+	}
+	small := imaging.Clone(img)
+	cols, rows := small.Bounds().Dx(), small.Bounds().Dy()
+
+	minDim := cols
+	if rows < minDim {
+		minDim = rows
+	}
+	params := pigo.CascadeParams{
+		MinSize:     20,
+		MaxSize:     minDim,
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{
+			Pixels: pigo.RgbToGrayscale(small),
+			Rows:   rows,
+			Cols:   cols,
+			Dim:    cols,
+		},
+	}
+
+	dets := d.classifier.RunCascade(params, 0)
+	dets = d.classifier.ClusterDetections(dets, pigoIoU)
+
+	var result []Detection
+	for _, det := range dets {
+		if float64(det.Q) < pigoMinScore {
+			continue
+		}
+		half := float64(det.Scale) / 2
+		box := image.Rect(
+			int((float64(det.Col)-half)*scale),
+			int((float64(det.Row)-half)*scale),
+			int((float64(det.Col)+half)*scale),
+			int((float64(det.Row)+half)*scale),
+		).Add(bounds.Min).Intersect(bounds)
+		if box.Empty() {
+			continue
+		}
+		result = append(result, Detection{Box: box, Score: float64(det.Q)})
+	}
+	return result, nil
+}
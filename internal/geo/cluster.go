@@ -0,0 +1,76 @@
+package geo
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// MaxZoom is the zoom level at which points are no longer clustered.
+	MaxZoom = 18
+	// clusterPixels is the size of a clustering cell in 256px tile pixels.
+	clusterPixels = 64
+)
+
+type Point struct {
+	ID        string
+	Latitude  float64
+	Longitude float64
+}
+
+type Cluster struct {
+	Latitude  float64
+	Longitude float64
+	IDs       []string
+}
+
+// ClusterPoints groups points that fall into the same screen cell at the
+// given Web Mercator zoom level. Each cluster is placed at the mean position
+// of its members. At MaxZoom and beyond every point is its own cluster.
+func ClusterPoints(points []Point, zoom int) []Cluster {
+	if zoom < 0 {
+		zoom = 0
+	}
+
+	cells := make(map[[2]int]*Cluster)
+	var keys [][2]int
+	cellSize := clusterPixels / (256 * math.Exp2(float64(zoom)))
+	for i, p := range points {
+		key := [2]int{i, 0}
+		if zoom < MaxZoom {
+			x, y := mercator(p.Latitude, p.Longitude)
+			key = [2]int{int(x / cellSize), int(y / cellSize)}
+		}
+		c, ok := cells[key]
+		if !ok {
+			c = &Cluster{}
+			cells[key] = c
+			keys = append(keys, key)
+		}
+		c.Latitude += p.Latitude
+		c.Longitude += p.Longitude
+		c.IDs = append(c.IDs, p.ID)
+	}
+
+	clusters := make([]Cluster, 0, len(keys))
+	for _, key := range keys {
+		c := cells[key]
+		n := float64(len(c.IDs))
+		c.Latitude /= n
+		c.Longitude /= n
+		clusters = append(clusters, *c)
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return len(clusters[i].IDs) > len(clusters[j].IDs)
+	})
+	return clusters
+}
+
+// mercator projects a position onto the unit Web Mercator square.
+func mercator(lat, lon float64) (float64, float64) {
+	lat = math.Max(-85.05112878, math.Min(85.05112878, lat))
+	sin := math.Sin(lat * math.Pi / 180)
+	x := (lon + 180) / 360
+	y := 0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)
+	return x, y
+}
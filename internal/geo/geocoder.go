@@ -0,0 +1,163 @@
+// Package geo provides offline reverse geocoding and map clustering for
+// geotagged images.
+package geo
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cities1000 is the GeoNames cities1000 dump (places with at least 1,000
+// inhabitants, CC BY 4.0, geonames.org) trimmed to the columns Bundled
+// uses: name, latitude, longitude and country code.
+//
+//go:embed cities1000.tsv.gz
+var cities1000 []byte
+
+// MaxDistance is how far (km) a position may be from the nearest known city
+// and still be attributed to it.
+const MaxDistance = 150.0
+
+const earthRadius = 6371.0 // km
+
+type Place struct {
+	City      string  `json:"city"`
+	Country   string  `json:"country"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Geocoder finds the nearest city to a position. Places are bucketed into
+// one-degree cells so lookups only look at the neighbourhood.
+type Geocoder struct {
+	places []Place
+	cells  map[[2]int][]int
+}
+
+func NewGeocoder(places []Place) *Geocoder {
+	g := &Geocoder{places: places, cells: make(map[[2]int][]int)}
+	for i, p := range places {
+		key := cellOf(p.Latitude, p.Longitude)
+		g.cells[key] = append(g.cells[key], i)
+	}
+	return g
+}
+
+// Bundled returns a geocoder over the GeoNames extract compiled into the
+// binary. LoadGeoNames reads a full dump instead, e.g. a newer one.
+func Bundled() *Geocoder {
+	r, err := gzip.NewReader(bytes.NewReader(cities1000))
+	if err != nil {
+		panic(err)
+	}
+	g, err := parseGeoNames(r, "cities1000.tsv.gz")
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// LoadGeoNames reads a GeoNames cities dump (e.g. cities500.txt from
+// download.geonames.org) to replace the bundled extract.
+func LoadGeoNames(path string) (*Geocoder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseGeoNames(f, path)
+}
+
+// parseGeoNames reads GeoNames tab-separated rows; name is used in errors.
+func parseGeoNames(r io.Reader, name string) (*Geocoder, error) {
+	var places []Place
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 9 {
+			return nil, fmt.Errorf("%s:%d: expected GeoNames tab-separated columns", name, line)
+		}
+		lat, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid latitude", name, line)
+		}
+		lon, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid longitude", name, line)
+		}
+		country := fields[8]
+		if full, ok := countryNames[country]; ok {
+			country = full
+		}
+		places = append(places, Place{City: fields[1], Country: country, Latitude: lat, Longitude: lon})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(places) == 0 {
+		return nil, fmt.Errorf("%s: no places found", name)
+	}
+	return NewGeocoder(places), nil
+}
+
+// Reverse returns the nearest city within MaxDistance of the position.
+func (g *Geocoder) Reverse(lat, lon float64) (Place, bool) {
+	span := int(math.Ceil(MaxDistance / 111))
+	lonSpan := span
+	if c := math.Cos(lat * math.Pi / 180); c > 0.01 {
+		lonSpan = int(math.Ceil(float64(span) / c))
+	}
+	if lonSpan > 180 {
+		lonSpan = 180
+	}
+
+	center := cellOf(lat, lon)
+	best, bestDist := -1, MaxDistance
+	for dy := -span; dy <= span; dy++ {
+		for dx := -lonSpan; dx <= lonSpan; dx++ {
+			key := [2]int{center[0] + dy, wrapCell(center[1] + dx)}
+			for _, i := range g.cells[key] {
+				p := g.places[i]
+				if d := Distance(lat, lon, p.Latitude, p.Longitude); d <= bestDist {
+					best, bestDist = i, d
+				}
+			}
+		}
+	}
+	if best < 0 {
+		return Place{}, false
+	}
+	return g.places[best], true
+}
+
+// Distance returns the great-circle distance between two positions in km.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	const rad = math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// ValidPosition reports whether lat/lon are within WGS84 range.
+func ValidPosition(lat, lon float64) bool {
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+func cellOf(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat)), wrapCell(int(math.Floor(lon)))}
+}
+
+func wrapCell(x int) int {
+	return ((x+180)%360+360)%360 - 180
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"goga/internal/ai"
+	"goga/internal/jobs"
+	"goga/internal/models"
+	"goga/internal/repository"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+// aiMaxDimension bounds the image sent to the provider; captions do not need
+// full resolution and smaller uploads are faster and cheaper.
+const aiMaxDimension = 1024
+
+// aiJPEGQuality is the quality of that upload, enough for the model to see
+// fine detail.
+const aiJPEGQuality = 85
+
+type AIHandler struct {
+	repo     *repository.ImageRepository
+	provider ai.Provider
+	jobs     *jobs.Manager
+}
+
+func NewAIHandler(repo *repository.ImageRepository, provider ai.Provider, jobManager *jobs.Manager) *AIHandler {
+	return &AIHandler{
+		repo:     repo,
+		provider: provider,
+		jobs:     jobManager,
+	}
+}
+
+// Describe queues an AI description job for an image.
+func (h *AIHandler) Describe(c *gin.Context) {
+	id := c.Param("id")
+
+	if _, err := h.repo.GetByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	if !h.provider.Configured() {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": ai.ErrNotConfigured.Error()})
+		return
+	}
+
+	job := h.submit(id)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// DescribeOnUpload is registered as an upload hook. It does nothing until a
+// provider is configured, so uploads never fail because of missing keys.
+func (h *AIHandler) DescribeOnUpload(image *models.Image) {
+	if h.provider.Configured() {
+		h.submit(image.ID)
+	}
+}
+
+func (h *AIHandler) submit(id string) *jobs.Job {
+	return h.jobs.Submit("ai.describe", func(ctx context.Context, job *jobs.Job) error {
+		desc, err := h.describe(ctx, id)
+		if err != nil {
+			log.Printf("AI describe failed for %s: %v", id, err)
+			return err
+		}
+		job.SetResult(gin.H{"image_id": id, "provider": h.provider.Name(), "description": desc})
+		return nil
+	})
+}
+
+func (h *AIHandler) describe(ctx context.Context, id string) (*ai.Description, error) {
+	imageRecord, err := h.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := imaging.Open(imageRecord.Path, imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, err
+	}
+	if src.Bounds().Dx() > aiMaxDimension || src.Bounds().Dy() > aiMaxDimension {
+		src = imaging.Fit(src, aiMaxDimension, aiMaxDimension, imaging.Lanczos)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: aiJPEGQuality}); err != nil {
+		return nil, err
+	}
+
+	desc, err := h.provider.Describe(ctx, buf.Bytes(), "image/jpeg")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.repo.SetDescription(id, desc.Caption, desc.Tags, desc.AltText, time.Now()); err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
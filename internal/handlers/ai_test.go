@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"goga/internal/ai"
+	"goga/internal/jobs"
+	"goga/internal/repository"
+	"image/color"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestDescribeWithFakeProvider(t *testing.T) {
+	repo := repository.NewImageRepository(openTestDB(t))
+	if err := repo.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	manager := jobs.NewManager(1)
+	defer manager.Close()
+	h := NewAIHandler(repo, ai.NewFakeProvider(), manager)
+
+	// A bright yellow landscape
+	imageRecord := createTestImage(t, repo, t.TempDir(),
+		imaging.New(300, 200, color.NRGBA{250, 220, 40, 255}))
+
+	w := serve(h.Describe, http.MethodPost, "/images/:id/ai/describe", "/images/"+imageRecord.ID+"/ai/describe", "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("describe returned %d: %s", w.Code, w.Body)
+	}
+	var queued struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &queued); err != nil {
+		t.Fatal(err)
+	}
+	job, ok := manager.Get(queued.ID)
+	if !ok {
+		t.Fatalf("job %s not found", queued.ID)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for job.Status() == jobs.StatusPending || job.Status() == jobs.StatusRunning {
+		if time.Now().After(deadline) {
+			t.Fatal("describe job did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job.Status() != jobs.StatusSucceeded {
+		t.Fatalf("describe job %s", job.Status())
+	}
+
+	described, err := repo.GetByID(imageRecord.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "A bright landscape photo with mostly yellow tones."; described.Caption != want {
+		t.Errorf("caption is %q, want %q", described.Caption, want)
+	}
+	if want := []string{"landscape", "bright", "yellow"}; !slices.Equal(described.Tags, want) {
+		t.Errorf("tags are %v, want %v", described.Tags, want)
+	}
+	if want := "Bright landscape image, predominantly yellow"; described.AltText != want {
+		t.Errorf("alt text is %q, want %q", described.AltText, want)
+	}
+	if described.DescribedAt == nil {
+		t.Error("described_at is not set")
+	}
+}
+
+func TestDescribeUnknownImage(t *testing.T) {
+	repo := repository.NewImageRepository(openTestDB(t))
+	if err := repo.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	manager := jobs.NewManager(1)
+	defer manager.Close()
+	h := NewAIHandler(repo, ai.NewFakeProvider(), manager)
+
+	w := serve(h.Describe, http.MethodPost, "/images/:id/ai/describe", "/images/missing/ai/describe", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("describe of a missing image returned %d, want 404", w.Code)
+	}
+}
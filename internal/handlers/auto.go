@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"goga/internal/adjust"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AutoEdit analyses the image and suggests an EditRequest with white
+// balance, stretched levels and exposure correction as a starting point.
+// ?whiteBalance= picks the estimator (gray_world, the default, white_patch
+// or none), ?levels=false and ?exposure=false skip those corrections, and
+// ?apply=true also applies the suggestion to the image.
+func (h *EditHandler) AutoEdit(c *gin.Context) {
+	opts := adjust.AutoOptions{
+		WhiteBalance: c.DefaultQuery("whiteBalance", adjust.GrayWorld),
+		Levels:       c.Query("levels") != "false",
+		Exposure:     c.Query("exposure") != "false",
+	}
+	if opts.WhiteBalance == "none" {
+		opts.WhiteBalance = ""
+	}
+	if err := opts.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	imageRecord, err := h.repo.GetByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	// The preview proxy has plenty of pixels for the statistics
+	proxy, err := h.previews.proxy(c.Request.Context(), imageRecord, previewDefaultSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open image"})
+		return
+	}
+	settings := adjust.Auto(proxy.img, opts)
+
+	req := EditRequest{Temperature: settings.Temperature, Tint: settings.Tint}
+	if settings.Levels != (adjust.Levels{}) {
+		req.Levels = &settings.Levels
+	}
+
+	if c.Query("apply") != "true" {
+		c.JSON(http.StatusOK, gin.H{"edit": req, "applied": false})
+		return
+	}
+	if err := h.applyEdit(imageRecord, req); err != nil {
+		c.JSON(editErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"edit": req, "applied": true})
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goga/internal/jobs"
+	"goga/internal/models"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchSize bounds how many images one batch job may touch.
+const maxBatchSize = 1000
+
+// BatchEditRequest selects images either by ID or with a listing filter in
+// query-string form, e.g. "country=France&near=48.85,2.35". The edit is
+// given inline or as a preset ID or name.
+type BatchEditRequest struct {
+	IDs    []string        `json:"ids"`
+	Filter string          `json:"filter"`
+	Edit   json.RawMessage `json:"edit"`
+	Preset string          `json:"preset"`
+	Owner  string          `json:"owner"` // preset owner when resolving by name
+}
+
+type batchItem struct {
+	ID     string `json:"id"`
+	Status string `json:"status"` // pending, succeeded, failed or cancelled
+	Error  string `json:"error,omitempty"`
+	Result any    `json:"result,omitempty"`
+}
+
+type batchResult struct {
+	Total     int         `json:"total"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+	Items     []batchItem `json:"items"`
+}
+
+// BatchEdit applies one EditRequest to many images in a background job.
+// Each image is backed up before its first edit, exactly like ApplyEdit.
+func (h *EditHandler) BatchEdit(c *gin.Context) {
+	var req BatchEditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var edit EditRequest
+	if req.Preset != "" {
+		preset, err := findPreset(h.presets, req.Preset, req.Owner)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Preset not found"})
+			return
+		}
+		if err := json.Unmarshal(preset.Edit, &edit); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid preset"})
+			return
+		}
+	}
+	if len(req.Edit) > 0 {
+		if err := json.Unmarshal(req.Edit, &edit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid edit: " + err.Error()})
+			return
+		}
+	}
+	if err := edit.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid edit: " + err.Error()})
+		return
+	}
+
+	ids, ok := h.batchIDs(c, req.IDs, req.Filter)
+	if !ok {
+		return
+	}
+
+	job := h.jobs.Submit("images.batch_edit", func(ctx context.Context, job *jobs.Job) error {
+		return h.runBatch(ctx, job, ids, func(imageRecord *models.Image) (any, error) {
+			return nil, h.applyEdit(imageRecord, edit)
+		})
+	})
+	c.JSON(http.StatusAccepted, job)
+}
+
+// batchIDs resolves the images of a batch request, given either by ID or
+// as a listing filter in query-string form, responding with an error if
+// the selection is invalid or empty.
+func (h *EditHandler) batchIDs(c *gin.Context, ids []string, filterQuery string) ([]string, bool) {
+	if (len(ids) == 0) == (filterQuery == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Exactly one of ids or filter is required"})
+		return nil, false
+	}
+
+	if filterQuery != "" {
+		values, err := url.ParseQuery(filterQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter: " + err.Error()})
+			return nil, false
+		}
+		filter, err := parseImageFilterValues(values)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter: " + err.Error()})
+			return nil, false
+		}
+		images, err := h.repo.List(filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		ids = make([]string, len(images))
+		for i := range images {
+			ids[i] = images[i].ID
+		}
+	}
+
+	ids = uniqueIDs(ids)
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No images match"})
+		return nil, false
+	}
+	if len(ids) > maxBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d images can be processed at once", maxBatchSize)})
+		return nil, false
+	}
+	return ids, true
+}
+
+// runBatch calls apply for each image in turn, publishing the progress and
+// what apply returned for each image as the job result.
+func (h *EditHandler) runBatch(ctx context.Context, job *jobs.Job, ids []string,
+	apply func(*models.Image) (any, error)) error {
+	result := batchResult{Total: len(ids), Items: make([]batchItem, len(ids))}
+	for i, id := range ids {
+		result.Items[i] = batchItem{ID: id, Status: "pending"}
+	}
+	publish := func() {
+		snapshot := result
+		snapshot.Items = append([]batchItem(nil), result.Items...)
+		job.SetResult(snapshot)
+	}
+	publish()
+
+	for i, id := range ids {
+		if ctx.Err() != nil {
+			for k := i; k < len(ids); k++ {
+				result.Items[k].Status = "cancelled"
+			}
+			publish()
+			return ctx.Err()
+		}
+
+		item := &result.Items[i]
+		imageRecord, err := h.repo.GetByID(id)
+		if err == nil {
+			item.Result, err = apply(imageRecord)
+		} else {
+			err = fmt.Errorf("image not found")
+		}
+		if err != nil {
+			item.Status, item.Error = "failed", err.Error()
+			result.Failed++
+		} else {
+			item.Status = "succeeded"
+			result.Succeeded++
+		}
+		publish()
+	}
+
+	if result.Failed == len(ids) {
+		return fmt.Errorf("all %d images failed", len(ids))
+	}
+	return nil
+}
+
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	var unique []string
+	for _, id := range ids {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"goga/pkg/utils"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	compareDefaultSize = 1024
+	compareMaxSize     = 4096
+	compareDefaultGain = 4
+	compareGap         = 8
+)
+
+// Compare measures how much two images differ, e.g. an edit and its
+// original or two near-duplicates. ?a= and ?b= are image ids; an id
+// followed by ":original" refers to the image before it was edited, and
+// ?a= alone compares the original with the current version. The images are
+// aligned at ?size= (default 1024) on the long edge, b being scaled and
+// centre-cropped to a.
+//
+// ?mode=side_by_side (default) responds with both images as a JPEG and
+// ?mode=difference with their amplified difference (?gain=, default 4) as
+// a PNG, the metrics being sent in X-Compare-* headers; ?mode=metrics
+// responds with the metrics alone as JSON.
+func (h *EditHandler) Compare(c *gin.Context) {
+	refA, refB := c.Query("a"), c.Query("b")
+	if refA == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a is required"})
+		return
+	}
+	if refB == "" {
+		refA, refB = strings.TrimSuffix(refA, ":original")+":original", strings.TrimSuffix(refA, ":original")
+	}
+	mode := c.DefaultQuery("mode", "side_by_side")
+	if mode != "side_by_side" && mode != "difference" && mode != "metrics" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be side_by_side, difference or metrics"})
+		return
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("size", strconv.Itoa(compareDefaultSize)))
+	if err != nil || size < previewMinSize || size > compareMaxSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("size must be between %d and %d", previewMinSize, compareMaxSize)})
+		return
+	}
+	gain, err := strconv.ParseFloat(c.DefaultQuery("gain", strconv.Itoa(compareDefaultGain)), 64)
+	if err != nil || gain < 1 || gain > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "gain must be between 1 and 50"})
+		return
+	}
+
+	imgA, status, err := h.compareSource(refA)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	imgB, status, err := h.compareSource(refB)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	a, b := utils.Align(imgA, imgB, size)
+	metrics := utils.Compare(a, b)
+	if mode == "metrics" {
+		c.JSON(http.StatusOK, gin.H{
+			"a":       refA,
+			"b":       refB,
+			"width":   a.Rect.Dx(),
+			"height":  a.Rect.Dy(),
+			"metrics": metrics,
+		})
+		return
+	}
+
+	var out image.Image
+	format := "jpeg"
+	if mode == "difference" {
+		out, format = utils.Difference(a, b, gain), "png"
+	} else {
+		out = utils.SideBySide(a, b, compareGap)
+	}
+	var buf bytes.Buffer
+	if err := utils.EncodeImage(&buf, out, format, h.settings.JPEGQuality); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode image"})
+		return
+	}
+
+	if metrics.PSNR != nil {
+		c.Header("X-Compare-PSNR", strconv.FormatFloat(*metrics.PSNR, 'f', 2, 64))
+	} else {
+		c.Header("X-Compare-PSNR", "inf")
+	}
+	c.Header("X-Compare-SSIM", strconv.FormatFloat(metrics.SSIM, 'f', 4, 64))
+	c.Header("X-Compare-MAE", strconv.FormatFloat(metrics.MAE, 'f', 2, 64))
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, utils.ContentType(format), buf.Bytes())
+}
+
+// compareSource opens the image an id refers to, as displayed. With the
+// ":original" suffix it opens the backup taken before the first edit, or
+// the current file for images that were never edited.
+func (h *EditHandler) compareSource(ref string) (image.Image, int, error) {
+	id, original := strings.CutSuffix(ref, ":original")
+	imageRecord, err := h.repo.GetByID(id)
+	if err != nil {
+		return nil, http.StatusNotFound, fmt.Errorf("image %s not found", id)
+	}
+
+	path := imageRecord.Path
+	if original {
+		backupPath := filepath.Join(h.uploadDir, "backups", imageRecord.Filename)
+		if _, err := os.Stat(backupPath); err == nil {
+			path = backupPath
+		}
+	}
+	img, err := imaging.Open(path, imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to open image %s", id)
+	}
+	return img, http.StatusOK, nil
+}
@@ -10,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 )
@@ -20,12 +21,14 @@ type Config struct {
 
 type ConfigHandler struct {
 	configPath string
+	mu         sync.RWMutex
 	config     *Config
 }
 
-func NewConfigHandler() *ConfigHandler {
+// NewConfigHandler keeps the dashboard settings in the JSON file at path.
+func NewConfigHandler(path string) *ConfigHandler {
 	return &ConfigHandler{
-		configPath: "./config.json",
+		configPath: path,
 		config:     &Config{},
 	}
 }
@@ -78,6 +81,9 @@ func (h *ConfigHandler) UpdateConfig(c *gin.Context) {
 		return
 	}
 
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
 	if newConfig.AIAPIKey != "" {
 		h.config.AIAPIKey = newConfig.AIAPIKey
 	}
@@ -94,6 +100,9 @@ func (h *ConfigHandler) UpdateConfig(c *gin.Context) {
 
 
 func (h *ConfigHandler) GetConfig(c *gin.Context) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
 	// Don't return actual API key, just indicate if it's set
 	response := map[string]interface{}{
 		"aiApiKey": "", // Never return the actual key
@@ -102,6 +111,13 @@ func (h *ConfigHandler) GetConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// APIKey returns the decrypted AI API key, or "" if none is set.
+func (h *ConfigHandler) APIKey() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.config.AIAPIKey
+}
+
 func (h *ConfigHandler) encrypt(text string) (string, error) {
 	key := h.getEncryptionKey()
 	block, err := aes.NewCipher(key)
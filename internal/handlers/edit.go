@@ -1,25 +1,44 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goga/internal/adjust"
+	"goga/internal/config"
+	"goga/internal/jobs"
+	"goga/internal/models"
+	"goga/internal/pipeline"
 	"goga/internal/repository"
+	"goga/internal/watermark"
 	"goga/pkg/utils"
 	"image"
-	"image/color"
-	"image/jpeg"
-	"image/png"
+	"io"
+	"log"
 	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
-	"github.com/disintegration/gift"
 	"github.com/disintegration/imaging"
 	"github.com/gin-gonic/gin"
 )
 
 type EditHandler struct {
-	repo      *repository.ImageRepository
-	uploadDir string
+	repo       *repository.ImageRepository
+	presets    *repository.PresetRepository
+	watermarks *repository.WatermarkRepository
+	masks      pipeline.MaskStore
+	logos      *watermark.Logos
+	uploadDir  string
+	settings   config.Images
+	renders    *utils.DiskCache
+	previews   *previewer
+	jobs       *jobs.Manager
+	locks      *imageLocks
 }
 
 type EditRequest struct {
@@ -48,20 +67,84 @@ type EditRequest struct {
 	CropY  float64 `json:"cropY"`
 	CropW  float64 `json:"cropW"`
 	CropH  float64 `json:"cropH"`
+
+	// Resize to fit within the given box, keeping the aspect ratio
+	ResizeW int `json:"resizeW"`
+	ResizeH int `json:"resizeH"`
+
+	// Vignette shape; nil uses the defaults described on adjust.Vignette
+	VignetteMidpoint  *float64 `json:"vignetteMidpoint"`
+	VignetteFeather   *float64 `json:"vignetteFeather"`
+	VignetteRoundness *float64 `json:"vignetteRoundness"`
+
+	// Tone and colour tools
+	Curves *adjust.Curves `json:"curves"`
+	Levels *adjust.Levels `json:"levels"`
+	HSL    adjust.HSL     `json:"hsl"`
+	Mixer  *adjust.Mixer  `json:"mixer"`
+
+	// Operations run after the fields above, in the order given
+	Operations pipeline.Pipeline `json:"operations"`
 }
 
-func NewEditHandler(repo *repository.ImageRepository, uploadDir string) *EditHandler {
-	return &EditHandler{
-		repo:      repo,
-		uploadDir: uploadDir,
+// validate checks the structured tools; out-of-range sliders are clamped.
+func (r *EditRequest) validate() error {
+	if r.Curves != nil {
+		if err := r.Curves.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Levels != nil {
+		if err := r.Levels.Validate(); err != nil {
+			return err
+		}
 	}
+	if err := r.HSL.Validate(); err != nil {
+		return err
+	}
+	if r.Mixer != nil {
+		if err := r.Mixer.Validate(); err != nil {
+			return err
+		}
+	}
+	return r.Operations.Validate()
 }
 
+func NewEditHandler(repo *repository.ImageRepository, presets *repository.PresetRepository,
+	watermarks *repository.WatermarkRepository, masks pipeline.MaskStore, logos *watermark.Logos,
+	uploadDir string, jobManager *jobs.Manager, settings config.Images) (*EditHandler, error) {
+	renders, err := utils.NewDiskCache(filepath.Join(uploadDir, "renders"), renderCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	return &EditHandler{
+		repo:       repo,
+		presets:    presets,
+		watermarks: watermarks,
+		masks:      masks,
+		logos:      logos,
+		uploadDir:  uploadDir,
+		settings:   settings,
+		renders:    renders,
+		previews:   newPreviewer(),
+		jobs:       jobManager,
+		locks:      newImageLocks(),
+	}, nil
+}
+
+// PreviewEdit renders req on a downscaled proxy of the image whose long
+// edge is at most ?maxSize= (default 1600). Previews for the same image and
+// ?session= (default: client IP) are coalesced: an identical request joins
+// the running render and a changed one supersedes it with 409.
 func (h *EditHandler) PreviewEdit(c *gin.Context) {
 	id := c.Param("id")
 	
-	var req EditRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	req, ok := h.bindEdit(c)
+	if !ok {
+		return
+	}
+	maxSize, err := parsePreviewSize(c.Query("maxSize"))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -72,29 +155,40 @@ func (h *EditHandler) PreviewEdit(c *gin.Context) {
 		return
 	}
 
-	// Open original image
-	src, err := imaging.Open(imageRecord.Path, imaging.AutoOrientation(true))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open image"})
+	steps := req.pipeline()
+	if err := steps.Resolve(h.env(imageRecord)); err != nil {
+		c.JSON(editErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	finalImg := h.processImage(src, req)
+	settings, _ := json.Marshal(req)
+	key := fmt.Sprintf("%s_%d_%s", imageRecord.Version, maxSize, settings)
+	slot := id + "_" + c.DefaultQuery("session", c.ClientIP())
+	data, err := h.previews.do(c.Request.Context(), slot, key, func(ctx context.Context) ([]byte, error) {
+		return h.renderPreview(ctx, imageRecord, steps, maxSize)
+	})
+	switch {
+	case err == nil:
+	case c.Request.Context().Err() != nil:
+		// The client has gone away, there is nobody to answer
+		return
+	case errors.Is(err, context.Canceled):
+		c.JSON(http.StatusConflict, gin.H{"error": "Superseded by a newer preview"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render preview"})
+		return
+	}
 
-	// Set response headers
-	c.Header("Content-Type", "image/jpeg")
 	c.Header("Cache-Control", "no-cache")
-
-	// Encode and send
-	jpeg.Encode(c.Writer, finalImg, &jpeg.Options{Quality: 85})
+	c.Data(http.StatusOK, "image/jpeg", data)
 }
 
 func (h *EditHandler) ApplyEdit(c *gin.Context) {
 	id := c.Param("id")
 	
-	var req EditRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	req, ok := h.bindEdit(c)
+	if !ok {
 		return
 	}
 
@@ -104,165 +198,303 @@ func (h *EditHandler) ApplyEdit(c *gin.Context) {
 		return
 	}
 
-	// Create backup of original
-	backupPath := filepath.Join(h.uploadDir, "backups", imageRecord.Filename)
-	os.MkdirAll(filepath.Dir(backupPath), 0755)
-	
-	// Copy original to backup
-	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
-		src, _ := os.Open(imageRecord.Path)
-		dst, _ := os.Create(backupPath)
-		defer src.Close()
-		defer dst.Close()
-		src.WriteTo(dst)
+	if err := h.applyEdit(imageRecord, req); err != nil {
+		c.JSON(editErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Image edited successfully"})
+}
+
+// bindEdit reads the EditRequest body. With ?preset= the preset's settings
+// are loaded first and fields present in the (optional) body override them.
+func (h *EditHandler) bindEdit(c *gin.Context) (EditRequest, bool) {
+	var req EditRequest
+	ref := c.Query("preset")
+	if ref != "" {
+		preset, err := findPreset(h.presets, ref, c.Query("owner"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Preset not found"})
+			return req, false
+		}
+		if err := json.Unmarshal(preset.Edit, &req); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid preset"})
+			return req, false
+		}
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil && !(ref != "" && errors.Is(err, io.EOF)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+	return req, true
+}
+
+// applyEdit backs up the original on first edit, then replaces the file
+// with the edited version and refreshes the record. Changes to one image
+// are applied one at a time.
+func (h *EditHandler) applyEdit(imageRecord *models.Image, req EditRequest) error {
+	defer h.locks.lock(imageRecord.ID)()
+	if err := h.reload(imageRecord); err != nil {
+		return err
+	}
+	steps := req.pipeline()
+	if err := steps.Resolve(h.env(imageRecord)); err != nil {
+		return err
+	}
+	if err := h.backup(imageRecord); err != nil {
+		return err
+	}
+
+	// Quarter turns and flips of a JPEG only rewrite the EXIF orientation,
+	// which avoids a lossy re-encode
+	if transform, ok := steps.Orientation(); ok && imageRecord.Format == "jpeg" {
+		if _, err := utils.ReorientJPEG(imageRecord.Path, transform); err != nil {
+			return errors.New("failed to rotate image")
+		}
+		utils.ClearThumbnailCache(h.uploadDir, imageRecord.ID)
+		if err := h.refreshRecord(imageRecord); err != nil {
+			return errors.New("failed to update image record")
+		}
+		return nil
 	}
 
 	// Apply edits and save
 	src, err := imaging.Open(imageRecord.Path, imaging.AutoOrientation(true))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open image"})
-		return
+		return errors.New("failed to open image")
 	}
 
-	finalImg := h.processImage(src, req)
-
-	// Save edited image
-	file, err := os.Create(imageRecord.Path)
+	finalImg, err := steps.Apply(context.Background(), src)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
-		return
+		return errors.New("failed to process image")
 	}
-	defer file.Close()
 
-	// Determine format and encode
-	ext := filepath.Ext(imageRecord.Path)
-	switch ext {
-	case ".png":
-		png.Encode(file, finalImg)
-	default:
-		jpeg.Encode(file, finalImg, &jpeg.Options{Quality: 95})
+	// Save edited image in its own format
+	err = replaceFile(imageRecord.Path, func(w io.Writer) error {
+		return utils.EncodeImage(w, finalImg, imageRecord.Format, h.settings.EditQuality)
+	})
+	if err != nil {
+		return errors.New("failed to save image")
 	}
 
 	// Clear thumbnails cache
-	utils.ClearThumbnailCache(h.uploadDir, id)
+	utils.ClearThumbnailCache(h.uploadDir, imageRecord.ID)
 
-	c.JSON(http.StatusOK, gin.H{"message": "Image edited successfully"})
+	if err := h.refreshRecord(imageRecord); err != nil {
+		return errors.New("failed to update image record")
+	}
+	return nil
+}
+
+// backup copies the original of an image before its first change, for
+// ResetImage.
+func (h *EditHandler) backup(imageRecord *models.Image) error {
+	backupPath := filepath.Join(h.uploadDir, "backups", imageRecord.Filename)
+	if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+		return errors.New("failed to create backup directory")
+	}
+
+	// Copy original to backup
+	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+		if err := copyFile(imageRecord.Path, backupPath); err != nil {
+			return errors.New("failed to back up original")
+		}
+	}
+	return nil
+}
+
+// replaceFile writes a new version of path next to it and renames it over
+// the old one, so a failed write never leaves a truncated image behind.
+func replaceFile(path string, write func(io.Writer) error) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// imageLocks serialises changes to the file of each image, so concurrent
+// edits of one image run one after the other instead of interleaving.
+type imageLocks struct {
+	mu    sync.Mutex
+	locks map[string]*imageLock
+}
+
+type imageLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newImageLocks() *imageLocks {
+	return &imageLocks{locks: make(map[string]*imageLock)}
+}
+
+// lock blocks until id is free and returns the function that frees it.
+func (l *imageLocks) lock(id string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &imageLock{}
+		l.locks[id] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		l.mu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(l.locks, id)
+		}
+		l.mu.Unlock()
+	}
+}
+
+func copyFile(srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dstPath)
+		return err
+	}
+	return dst.Close()
 }
 
 func (h *EditHandler) processImage(src image.Image, req EditRequest) image.Image {
-	// Apply filters using gift library
-	g := gift.New()
-	
-	if req.Brightness != 0 {
-		g.Add(gift.Brightness(float32(req.Brightness * 0.3)))
+	finalImg, _ := h.processImageContext(context.Background(), src, req)
+	return finalImg
+}
+
+// processImageContext is processImage that gives up between operations
+// once ctx is done, returning ctx.Err().
+func (h *EditHandler) processImageContext(ctx context.Context, src image.Image, req EditRequest) (image.Image, error) {
+	return req.pipeline().Apply(ctx, src)
+}
+
+// pipeline converts the request into operations. The legacy fields come
+// first in their historical order (colour, filters, rotate, crop, resize,
+// vignette), clamped to the operations' ranges, followed by Operations.
+func (r *EditRequest) pipeline() pipeline.Pipeline {
+	var p pipeline.Pipeline
+	add := func(op pipeline.Operation) {
+		p = append(p, pipeline.NewStep(op))
 	}
-	if req.Contrast != 0 {
-		g.Add(gift.Contrast(float32(req.Contrast * 0.5)))
+	slider := func(v float64) float64 {
+		return math.Max(-100, math.Min(100, v))
 	}
-	if req.Saturation != 0 {
-		value := float32(100 + req.Saturation*2)
-		if value < 0 { value = 0 }
-		g.Add(gift.Saturation(value))
+
+	if r.Temperature != 0 || r.Tint != 0 {
+		add(&pipeline.WhiteBalance{Temperature: slider(r.Temperature), Tint: slider(r.Tint)})
 	}
-	if req.Hue != 0 {
-		g.Add(gift.Hue(float32(req.Hue)))
+	if r.Shadows != 0 || r.Highlights != 0 {
+		add(&pipeline.ShadowsHighlights{Shadows: slider(r.Shadows), Highlights: slider(r.Highlights)})
 	}
-	if req.Gamma != 0 {
-		value := float32(1.0 + req.Gamma*0.02)
-		if value < 0.1 { value = 0.1 }
-		if value > 5.0 { value = 5.0 }
-		g.Add(gift.Gamma(value))
+	if r.Levels != nil {
+		add(&pipeline.Levels{Levels: *r.Levels})
 	}
-	if req.Blur > 0 {
-		g.Add(gift.GaussianBlur(float32(req.Blur)))
+	if r.Curves != nil {
+		add(&pipeline.Curves{Curves: *r.Curves})
 	}
-	if req.Sharpen > 0 {
-		g.Add(gift.UnsharpMask(float32(req.Sharpen), 1.0, 0.05))
+	if r.Vibrance != 0 {
+		add(&pipeline.Vibrance{Value: slider(r.Vibrance)})
 	}
-	
-	// Advanced filters
-	if req.Shadows != 0 {
-		value := float32(1.0 - req.Shadows*0.01)
-		if value < 0.1 { value = 0.1 }
-		if value > 3.0 { value = 3.0 }
-		g.Add(gift.Gamma(value))
+	if len(r.HSL) > 0 {
+		add(&pipeline.HSL{Ranges: r.HSL})
 	}
-	if req.Highlights != 0 {
-		g.Add(gift.Contrast(float32(-req.Highlights * 0.3)))
+	if r.Mixer != nil {
+		add(&pipeline.Mixer{Mixer: *r.Mixer})
 	}
-	if req.Temperature != 0 {
-		g.Add(gift.Hue(float32(req.Temperature * 0.5)))
+
+	if r.Brightness != 0 {
+		add(&pipeline.Brightness{Value: slider(r.Brightness)})
+	}
+	if r.Contrast != 0 {
+		add(&pipeline.Contrast{Value: slider(r.Contrast)})
+	}
+	if r.Saturation != 0 {
+		add(&pipeline.Saturation{Value: slider(r.Saturation)})
+	}
+	if r.Hue != 0 {
+		add(&pipeline.Hue{Value: math.Max(-180, math.Min(180, r.Hue))})
+	}
+	if r.Gamma != 0 {
+		add(&pipeline.Gamma{Value: slider(r.Gamma)})
 	}
-	if req.Tint != 0 {
-		g.Add(gift.Saturation(float32(100 + req.Tint)))
+	if r.Blur > 0 {
+		add(&pipeline.Blur{Sigma: math.Min(100, r.Blur)})
 	}
-	if req.Vibrance != 0 {
-		value := float32(100 + req.Vibrance*1.5)
-		if value < 0 { value = 0 }
-		g.Add(gift.Saturation(value))
+	if r.Sharpen > 0 {
+		add(&pipeline.Sharpen{Sigma: math.Min(50, r.Sharpen)})
 	}
-	if req.Clarity != 0 {
-		g.Add(gift.UnsharpMask(float32(req.Clarity*0.1), 2.0, 0.1))
+	if r.Clarity != 0 {
+		add(&pipeline.Clarity{Value: slider(r.Clarity)})
 	}
-	if req.Noise > 0 {
-		g.Add(gift.GaussianBlur(float32(req.Noise * 0.1)))
+	if r.Noise > 0 {
+		add(&pipeline.NoiseReduction{Value: math.Min(100, r.Noise)})
 	}
 
-	// Apply gift filters
-	dst := image.NewRGBA(g.Bounds(src.Bounds()))
-	g.Draw(dst, src)
-	
-	// Apply rotation if needed
-	var finalImg image.Image = dst
-	if req.Rotate != 0 {
-		finalImg = imaging.Rotate(finalImg, req.Rotate, color.Transparent)
+	if angle := math.Mod(r.Rotate, 360); angle != 0 && math.Mod(angle, 90) == 0 {
+		add(&pipeline.Rotate90{Angle: int(angle)})
+	} else if angle != 0 {
+		add(&pipeline.Rotate{Angle: angle})
 	}
-	
-	// Apply crop if specified
-	if req.CropW > 0 && req.CropH > 0 {
-		bounds := finalImg.Bounds()
-		x := int(req.CropX * float64(bounds.Dx()))
-		y := int(req.CropY * float64(bounds.Dy()))
-		w := int(req.CropW * float64(bounds.Dx()))
-		h := int(req.CropH * float64(bounds.Dy()))
-		finalImg = imaging.Crop(finalImg, image.Rect(x, y, x+w, y+h))
+	if r.CropW > 0 && r.CropH > 0 {
+		add(&pipeline.Crop{X: r.CropX, Y: r.CropY, Width: r.CropW, Height: r.CropH})
 	}
-	
-	// Apply vignette effect
-	if req.Vignette != 0 {
-		bounds := finalImg.Bounds()
-		vignetteImg := image.NewRGBA(bounds)
-		centerX := bounds.Dx() / 2
-		centerY := bounds.Dy() / 2
-		maxDist := float64(centerX)
-		if centerY > centerX {
-			maxDist = float64(centerY)
-		}
-		
-		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			for x := bounds.Min.X; x < bounds.Max.X; x++ {
-				dx := float64(x - centerX)
-				dy := float64(y - centerY)
-				dist := math.Sqrt(dx*dx + dy*dy)
-				factor := 1.0 - (dist/maxDist)*(req.Vignette*0.01)
-				if factor < 0 { factor = 0 }
-				
-				c := color.RGBAModel.Convert(finalImg.At(x, y)).(color.RGBA)
-				c.R = uint8(float64(c.R) * factor)
-				c.G = uint8(float64(c.G) * factor)
-				c.B = uint8(float64(c.B) * factor)
-				vignetteImg.Set(x, y, c)
-			}
-		}
-		finalImg = vignetteImg
+	if r.ResizeW > 0 || r.ResizeH > 0 {
+		add(&pipeline.Resize{Width: max(0, r.ResizeW), Height: max(0, r.ResizeH)})
+	}
+	if r.Vignette != 0 {
+		add(&pipeline.Vignette{
+			Amount:    slider(r.Vignette),
+			Midpoint:  r.VignetteMidpoint,
+			Feather:   r.VignetteFeather,
+			Roundness: r.VignetteRoundness,
+		})
 	}
 
-	return finalImg
+	return append(p, r.Operations...)
+}
+
+// GetOperations describes the operations accepted in EditRequest.Operations
+// as JSON Schema, keyed by operation name.
+func (h *EditHandler) GetOperations(c *gin.Context) {
+	c.JSON(http.StatusOK, pipeline.Schemas())
 }
 
 func (h *EditHandler) ResetImage(c *gin.Context) {
 	id := c.Param("id")
 	
+	defer h.locks.lock(id)()
 	imageRecord, err := h.repo.GetByID(id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
@@ -284,17 +516,72 @@ func (h *EditHandler) ResetImage(c *gin.Context) {
 	}
 	defer src.Close()
 
-	dst, err := os.Create(imageRecord.Path)
+	err = replaceFile(imageRecord.Path, func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore image"})
 		return
 	}
-	defer dst.Close()
-
-	src.WriteTo(dst)
 
 	// Clear thumbnails cache
 	utils.ClearThumbnailCache(h.uploadDir, id)
 
+	if err := h.refreshRecord(imageRecord); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image record"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Image reset to original"})
-}
\ No newline at end of file
+}
+
+// env is what the pipeline of an edit of imageRecord may refer to.
+func (h *EditHandler) env(imageRecord *models.Image) pipeline.Env {
+	return pipeline.Env{ImageID: imageRecord.ID, Version: imageRecord.Version, Masks: h.masks, Logos: h.logos}
+}
+
+// editErrorStatus is the status for a failed edit: 409 for a stale mask,
+// 400 for a mask or logo that does not exist and 500 otherwise.
+func editErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, pipeline.ErrMaskStale):
+		return http.StatusConflict
+	case errors.Is(err, pipeline.ErrMaskNotFound), errors.Is(err, watermark.ErrLogoNotFound):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// reload refreshes imageRecord from the database, for a change that had to
+// wait for another one to the same image.
+func (h *EditHandler) reload(imageRecord *models.Image) error {
+	current, err := h.repo.GetByID(imageRecord.ID)
+	if err != nil {
+		return errors.New("image not found")
+	}
+	*imageRecord = *current
+	return nil
+}
+
+// refreshRecord re-reads size, dimensions and placeholders after the file was
+// rewritten and bumps updated_at, which also changes the image's cache version.
+func (h *EditHandler) refreshRecord(imageRecord *models.Image) error {
+	info, err := os.Stat(imageRecord.Path)
+	if err != nil {
+		return err
+	}
+	width, height, err := utils.GetImageDimensions(imageRecord.Path)
+	if err != nil {
+		return err
+	}
+	imageRecord.Size = info.Size()
+	imageRecord.Width = width
+	imageRecord.Height = height
+	if err := setPlaceholders(imageRecord, nil); err != nil {
+		log.Printf("Failed to compute placeholders of %s: %v", imageRecord.ID, err)
+	}
+	imageRecord.UpdatedAt = time.Now()
+	return h.repo.Update(imageRecord)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"goga/internal/config"
+	"goga/internal/models"
+	"goga/internal/repository"
+	"image/color"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chai2010/webp"
+	"github.com/disintegration/imaging"
+	"github.com/google/uuid"
+)
+
+func newTestEditHandler(t *testing.T) (*EditHandler, *repository.ImageRepository, string) {
+	t.Helper()
+	db := openTestDB(t)
+	repo := repository.NewImageRepository(db)
+	if err := repo.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	presets := repository.NewPresetRepository(db)
+	if err := presets.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	watermarks := repository.NewWatermarkRepository(db)
+	if err := watermarks.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	uploadDir := t.TempDir()
+	h, err := NewEditHandler(repo, presets, watermarks, nil, nil, uploadDir, nil, config.Default().Images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, repo, uploadDir
+}
+
+func TestApplyEditKeepsWebP(t *testing.T) {
+	h, repo, uploadDir := newTestEditHandler(t)
+
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, imaging.New(120, 80, color.NRGBA{90, 140, 200, 255}), &webp.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.New().String()
+	path := filepath.Join(uploadDir, id+".webp")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	imageRecord := &models.Image{
+		ID:           id,
+		Filename:     id + ".webp",
+		OriginalName: "test.webp",
+		Path:         path,
+		Size:         int64(buf.Len()),
+		Width:        120,
+		Height:       80,
+		Format:       "webp",
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := repo.Create(imageRecord); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent edits of one image run one after the other, and each
+	// leaves a complete WebP file
+	var wg sync.WaitGroup
+	for _, body := range []string{`{"brightness": 20}`, `{"contrast": 30}`, `{"saturation": -40}`, `{"resizeW": 60}`} {
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+			w := serve(h.ApplyEdit, http.MethodPost, "/images/:id/edit", "/images/"+id+"/edit", body)
+			if w.Code != http.StatusOK {
+				t.Errorf("edit %s returned %d: %s", body, w.Code, w.Body)
+			}
+		}(body)
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := webp.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("edited file is not a WebP image: %v", err)
+	}
+	edited, err := repo.GetByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Size != int64(len(data)) || edited.Width != cfg.Width || edited.Height != cfg.Height {
+		t.Errorf("record says %d bytes %dx%d, file is %d bytes %dx%d",
+			edited.Size, edited.Width, edited.Height, len(data), cfg.Width, cfg.Height)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
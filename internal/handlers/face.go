@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"context"
+	"goga/internal/faces"
+	"goga/internal/jobs"
+	"goga/internal/models"
+	"goga/internal/repository"
+	"image"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type FaceHandler struct {
+	repo      *repository.FaceRepository
+	imageRepo *repository.ImageRepository
+	detector  faces.Detector
+	embedder  faces.Embedder
+	jobs      *jobs.Manager
+
+	// mu serialises person assignment so concurrent detections do not
+	// create duplicate people for the same face.
+	mu sync.Mutex
+}
+
+func NewFaceHandler(repo *repository.FaceRepository, imageRepo *repository.ImageRepository,
+	detector faces.Detector, embedder faces.Embedder, jobManager *jobs.Manager) *FaceHandler {
+	return &FaceHandler{
+		repo:      repo,
+		imageRepo: imageRepo,
+		detector:  detector,
+		embedder:  embedder,
+		jobs:      jobManager,
+	}
+}
+
+func (h *FaceHandler) GetImageFaces(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := h.imageRepo.GetByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	list, err := h.repo.ListByImage(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if list == nil {
+		list = []models.Face{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// DetectFaces queues face detection for an image.
+func (h *FaceHandler) DetectFaces(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := h.imageRepo.GetByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	c.JSON(http.StatusAccepted, h.submit(id))
+}
+
+// DetectOnUpload is registered as an upload hook.
+func (h *FaceHandler) DetectOnUpload(image *models.Image) {
+	h.submit(image.ID)
+}
+
+// DeleteForImage is registered as a delete hook.
+func (h *FaceHandler) DeleteForImage(imageID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.repo.DeleteForImage(imageID); err != nil {
+		log.Printf("Failed to delete faces for %s: %v", imageID, err)
+		return
+	}
+	h.repo.DeleteEmptyPeople()
+}
+
+func (h *FaceHandler) GetPeople(c *gin.Context) {
+	people, err := h.repo.ListPeople()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if people == nil {
+		people = []models.Person{}
+	}
+	c.JSON(http.StatusOK, people)
+}
+
+func (h *FaceHandler) GetPerson(c *gin.Context) {
+	person, err := h.repo.GetPerson(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
+		return
+	}
+	c.JSON(http.StatusOK, person)
+}
+
+func (h *FaceHandler) UpdatePerson(c *gin.Context) {
+	var req models.PersonUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id := c.Param("id")
+	if err := h.repo.RenamePerson(id, strings.TrimSpace(req.Name)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
+		return
+	}
+
+	person, err := h.repo.GetPerson(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, person)
+}
+
+func (h *FaceHandler) GetPersonImages(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := h.repo.GetPerson(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
+		return
+	}
+
+	ids, err := h.repo.ImageIDsForPerson(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	images := []models.Image{}
+	for _, imageID := range ids {
+		if image, err := h.imageRepo.GetByID(imageID); err == nil {
+			images = append(images, *image)
+		}
+	}
+	c.JSON(http.StatusOK, images)
+}
+
+// Recluster rebuilds all people from scratch. Names carry over to the new
+// cluster that holds most of a named person's faces.
+func (h *FaceHandler) Recluster(c *gin.Context) {
+	job := h.jobs.Submit("faces.recluster", func(ctx context.Context, job *jobs.Job) error {
+		count, err := h.recluster()
+		if err != nil {
+			return err
+		}
+		job.SetResult(gin.H{"people": count})
+		return nil
+	})
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (h *FaceHandler) submit(id string) *jobs.Job {
+	return h.jobs.Submit("faces.detect", func(ctx context.Context, job *jobs.Job) error {
+		found, err := h.detect(id)
+		if err != nil {
+			log.Printf("Face detection failed for %s: %v", id, err)
+			return err
+		}
+		job.SetResult(gin.H{"image_id": id, "faces": found})
+		return nil
+	})
+}
+
+// sameFaceOverlap is how much a box must overlap one found by an earlier
+// detection on the same image to be taken for the same face.
+const sameFaceOverlap = 0.5
+
+// detect finds faces in an image and assigns each to the most similar
+// existing person, creating a new person when none is close enough. Faces
+// found again where an earlier detection found one keep its person, so
+// re-detecting an image does not undo naming or manual assignment.
+func (h *FaceHandler) detect(id string) ([]models.Face, error) {
+	imageRecord, err := h.imageRepo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := imaging.Open(imageRecord.Path, imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, err
+	}
+
+	detections, err := h.detector.Detect(src)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	found := []models.Face{}
+	for _, d := range detections {
+		embedding, err := h.embedder.Embed(src, d.Box)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, models.Face{
+			ID:        uuid.New().String(),
+			ImageID:   id,
+			X:         d.Box.Min.X,
+			Y:         d.Box.Min.Y,
+			Width:     d.Box.Dx(),
+			Height:    d.Box.Dy(),
+			Score:     d.Score,
+			Embedding: embedding,
+			CreatedAt: now,
+		})
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	existing, err := h.repo.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	// The image's previous faces count towards the centroids too, or a
+	// person seen only in this image could not be matched again
+	var previous []models.Face
+	members := make(map[string][][]float32)
+	for _, f := range existing {
+		if f.ImageID == id {
+			previous = append(previous, f)
+		}
+		if f.PersonID != "" {
+			members[f.PersonID] = append(members[f.PersonID], f.Embedding)
+		}
+	}
+	centroids := make(map[string][]float32, len(members))
+	for personID, embeddings := range members {
+		centroids[personID] = faces.Centroid(embeddings)
+	}
+
+	for i := range found {
+		if personID := samePerson(&found[i], previous); personID != "" {
+			found[i].PersonID = personID
+			continue
+		}
+		best, bestID := faces.DefaultThreshold, ""
+		for personID, centroid := range centroids {
+			if s := faces.Similarity(found[i].Embedding, centroid); s >= best {
+				best, bestID = s, personID
+			}
+		}
+		if bestID == "" {
+			person := &models.Person{ID: uuid.New().String(), CreatedAt: now}
+			if err := h.repo.CreatePerson(person); err != nil {
+				return nil, err
+			}
+			bestID = person.ID
+			centroids[bestID] = found[i].Embedding
+		}
+		found[i].PersonID = bestID
+	}
+
+	if err := h.repo.ReplaceForImage(id, found); err != nil {
+		return nil, err
+	}
+	return found, h.repo.DeleteEmptyPeople()
+}
+
+// samePerson returns the person of the previous face that face overlaps
+// most, if any overlaps enough, and clears it in previous so that every
+// previous face is matched only once.
+func samePerson(face *models.Face, previous []models.Face) string {
+	best, bestIndex := sameFaceOverlap, -1
+	for i, p := range previous {
+		if p.PersonID == "" {
+			continue
+		}
+		if o := faces.Overlap(faceBox(*face), faceBox(p)); o >= best {
+			best, bestIndex = o, i
+		}
+	}
+	if bestIndex < 0 {
+		return ""
+	}
+	personID := previous[bestIndex].PersonID
+	previous[bestIndex].PersonID = ""
+	return personID
+}
+
+func faceBox(f models.Face) image.Rectangle {
+	return image.Rect(f.X, f.Y, f.X+f.Width, f.Y+f.Height)
+}
+
+func (h *FaceHandler) recluster() (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	all, err := h.repo.ListAll()
+	if err != nil {
+		return 0, err
+	}
+	oldPeople, err := h.repo.ListPeople()
+	if err != nil {
+		return 0, err
+	}
+	previous := make(map[string]models.Person)
+	for _, p := range oldPeople {
+		previous[p.ID] = p
+	}
+
+	embeddings := make([][]float32, len(all))
+	for i, f := range all {
+		embeddings[i] = f.Embedding
+	}
+	labels := faces.Cluster(embeddings, faces.DefaultThreshold)
+
+	// For each cluster find the previous person most of its faces belonged
+	// to, so IDs and names stay stable across reclustering.
+	votes := make(map[int]map[string]int)
+	for i, label := range labels {
+		if votes[label] == nil {
+			votes[label] = make(map[string]int)
+		}
+		if all[i].PersonID != "" {
+			votes[label][all[i].PersonID]++
+		}
+	}
+
+	now := time.Now()
+	used := make(map[string]bool)
+	clusterPerson := make(map[int]string)
+	var people []models.Person
+	for label, counts := range votes {
+		bestID, bestCount := "", 0
+		for personID, n := range counts {
+			if n > bestCount && !used[personID] {
+				bestID, bestCount = personID, n
+			}
+		}
+		person := models.Person{ID: bestID, Name: previous[bestID].Name, CreatedAt: previous[bestID].CreatedAt}
+		if bestID == "" {
+			person.ID = uuid.New().String()
+			person.CreatedAt = now
+		}
+		used[person.ID] = true
+		clusterPerson[label] = person.ID
+		people = append(people, person)
+	}
+
+	// Named people keep existing even if they lost all faces
+	for _, p := range oldPeople {
+		if p.Name != "" && !used[p.ID] {
+			people = append(people, models.Person{ID: p.ID, Name: p.Name, CreatedAt: p.CreatedAt})
+		}
+	}
+
+	assignments := make(map[string]string, len(all))
+	for i, f := range all {
+		assignments[f.ID] = clusterPerson[labels[i]]
+	}
+	if err := h.repo.ApplyClusters(people, assignments); err != nil {
+		return 0, err
+	}
+	return len(clusterPerson), nil
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goga/internal/geo"
+	"goga/internal/jobs"
+	"goga/internal/models"
+	"goga/internal/repository"
+	"goga/pkg/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultRadius is used for ?near= without ?radius= (km).
+const defaultRadius = 10.0
+
+// defaultColorTolerance is used for ?color= without ?tolerance= (delta E).
+const defaultColorTolerance = 20.0
+
+type GeoHandler struct {
+	repo     *repository.ImageRepository
+	geocoder *geo.Geocoder
+	jobs     *jobs.Manager
+}
+
+func NewGeoHandler(repo *repository.ImageRepository, geocoder *geo.Geocoder, jobManager *jobs.Manager) *GeoHandler {
+	return &GeoHandler{
+		repo:     repo,
+		geocoder: geocoder,
+		jobs:     jobManager,
+	}
+}
+
+// GetGeoJSON returns geotagged images as a GeoJSON FeatureCollection.
+// Images close together at the requested ?zoom= are merged into a single
+// cluster feature; listing filters such as ?bbox= apply as well.
+func (h *GeoHandler) GetGeoJSON(c *gin.Context) {
+	filter, err := parseImageFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.Geotagged = true
+
+	zoom := 2
+	if v := c.Query("zoom"); v != "" {
+		if zoom, err = strconv.Atoi(v); err != nil || zoom < 0 || zoom > geo.MaxZoom {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("zoom must be between 0 and %d", geo.MaxZoom)})
+			return
+		}
+	}
+
+	images, err := h.repo.List(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	byID := make(map[string]*models.Image, len(images))
+	points := make([]geo.Point, len(images))
+	for i := range images {
+		byID[images[i].ID] = &images[i]
+		points[i] = geo.Point{ID: images[i].ID, Latitude: *images[i].Latitude, Longitude: *images[i].Longitude}
+	}
+
+	features := []gin.H{}
+	for _, cluster := range geo.ClusterPoints(points, zoom) {
+		var properties gin.H
+		if len(cluster.IDs) == 1 {
+			image := byID[cluster.IDs[0]]
+			properties = gin.H{
+				"id":        image.ID,
+				"filename":  image.OriginalName,
+				"thumbnail": fmt.Sprintf("/api/images/%s/v/%s?thumb=200", image.ID, image.Version),
+				"country":   image.Country,
+				"city":      image.City,
+			}
+		} else {
+			properties = gin.H{
+				"cluster":   true,
+				"count":     len(cluster.IDs),
+				"image_ids": cluster.IDs,
+			}
+		}
+		features = append(features, gin.H{
+			"type": "Feature",
+			"geometry": gin.H{
+				"type":        "Point",
+				"coordinates": []float64{cluster.Longitude, cluster.Latitude},
+			},
+			"properties": properties,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"type":     "FeatureCollection",
+		"features": features,
+	})
+}
+
+// SetLocation geotags an image manually. Null coordinates clear the location.
+func (h *GeoHandler) SetLocation(c *gin.Context) {
+	var req models.ImageLocationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.Latitude == nil) != (req.Longitude == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "latitude and longitude must be set together"})
+		return
+	}
+	if req.Latitude != nil && !geo.ValidPosition(*req.Latitude, *req.Longitude) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "latitude must be within ±90 and longitude within ±180"})
+		return
+	}
+
+	id := c.Param("id")
+	if _, err := h.locate(id, req.Latitude, req.Longitude); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	image, err := h.repo.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, image)
+}
+
+// ScanLocations queues a job that reads GPS positions from the files of
+// images that have no location yet, e.g. those uploaded before geotagging
+// was supported.
+func (h *GeoHandler) ScanLocations(c *gin.Context) {
+	job := h.jobs.Submit("geo.scan", func(ctx context.Context, job *jobs.Job) error {
+		images, err := h.repo.GetAll()
+		if err != nil {
+			return err
+		}
+		located := 0
+		for i := range images {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if images[i].Latitude != nil {
+				continue
+			}
+			if h.locateFromFile(&images[i]) {
+				located++
+			}
+		}
+		job.SetResult(gin.H{"scanned": len(images), "located": located})
+		return nil
+	})
+	c.JSON(http.StatusAccepted, job)
+}
+
+// LocateOnUpload is registered as an upload hook. Reading EXIF is cheap so
+// it runs inline and the upload response already carries the location.
+func (h *GeoHandler) LocateOnUpload(image *models.Image) {
+	h.locateFromFile(image)
+}
+
+func (h *GeoHandler) locateFromFile(image *models.Image) bool {
+	info, err := utils.ReadExif(image.Path)
+	if err != nil {
+		if !errors.Is(err, utils.ErrNoExif) {
+			log.Printf("Failed to read EXIF of %s: %v", image.ID, err)
+		}
+		return false
+	}
+	if !info.HasGPS || !geo.ValidPosition(info.Latitude, info.Longitude) {
+		return false
+	}
+	place, err := h.locate(image.ID, &info.Latitude, &info.Longitude)
+	if err != nil {
+		log.Printf("Failed to store location of %s: %v", image.ID, err)
+		return false
+	}
+	image.Latitude, image.Longitude = &info.Latitude, &info.Longitude
+	image.Country, image.City = place.Country, place.City
+	return true
+}
+
+// locate stores a position together with the nearest known place.
+func (h *GeoHandler) locate(id string, latitude, longitude *float64) (geo.Place, error) {
+	var place geo.Place
+	if latitude != nil {
+		place, _ = h.geocoder.Reverse(*latitude, *longitude)
+	}
+	return place, h.repo.SetLocation(id, latitude, longitude, place.Country, place.City)
+}
+
+// parseImageFilter reads the listing filters:
+//
+//	?bbox=minLon,minLat,maxLon,maxLat  images inside the box
+//	?near=lat,lon&radius=km            images within radius (default 10 km)
+//	?country=...&city=...              reverse geocoded place
+//	?color=#rrggbb&tolerance=deltaE    a dominant colour close to it (default 20)
+func parseImageFilter(c *gin.Context) (repository.ImageFilter, error) {
+	return parseImageFilterValues(c.Request.URL.Query())
+}
+
+func parseImageFilterValues(q url.Values) (repository.ImageFilter, error) {
+	filter := repository.ImageFilter{
+		Country: q.Get("country"),
+		City:    q.Get("city"),
+	}
+
+	if v := q.Get("bbox"); v != "" {
+		n, err := parseFloats(v, 4)
+		if err != nil || !geo.ValidPosition(n[1], n[0]) || !geo.ValidPosition(n[3], n[2]) || n[1] > n[3] {
+			return filter, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+		}
+		filter.Bounds = &repository.Bounds{MinLon: n[0], MinLat: n[1], MaxLon: n[2], MaxLat: n[3]}
+	}
+
+	if v := q.Get("near"); v != "" {
+		n, err := parseFloats(v, 2)
+		if err != nil || !geo.ValidPosition(n[0], n[1]) {
+			return filter, errors.New("near must be lat,lon")
+		}
+		radius := defaultRadius
+		if r := q.Get("radius"); r != "" {
+			if radius, err = strconv.ParseFloat(r, 64); err != nil || radius <= 0 {
+				return filter, errors.New("radius must be a positive number of kilometres")
+			}
+		}
+		filter.Near = &repository.Circle{Latitude: n[0], Longitude: n[1], Radius: radius}
+	}
+
+	if v := q.Get("color"); v != "" {
+		c, err := utils.ParseHexColor(v)
+		if err != nil {
+			return filter, errors.New("color must be a hex colour like #ff0000")
+		}
+		tolerance := defaultColorTolerance
+		if t := q.Get("tolerance"); t != "" {
+			if tolerance, err = strconv.ParseFloat(t, 64); err != nil || tolerance < 0 || tolerance > 100 {
+				return filter, errors.New("tolerance must be between 0 and 100")
+			}
+		}
+		filter.Color = &repository.ColorMatch{Color: c, Tolerance: tolerance}
+	}
+	return filter, nil
+}
+
+func parseFloats(s string, count int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != count {
+		return nil, fmt.Errorf("expected %d values", count)
+	}
+	values := make([]float64, count)
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"database/sql"
+	"goga/internal/models"
+	"goga/internal/repository"
+	"image"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// openTestDB opens an empty database in a temporary directory.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "goga.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// createTestImage saves img as a PNG in dir and adds it to repo.
+func createTestImage(t *testing.T, repo *repository.ImageRepository, dir string, img image.Image) *models.Image {
+	t.Helper()
+	id := uuid.New().String()
+	path := filepath.Join(dir, id+".png")
+	if err := imaging.Save(img, path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	imageRecord := &models.Image{
+		ID:           id,
+		Filename:     id + ".png",
+		OriginalName: "test.png",
+		Path:         path,
+		Size:         info.Size(),
+		Width:        img.Bounds().Dx(),
+		Height:       img.Bounds().Dy(),
+		Format:       "png",
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := repo.Create(imageRecord); err != nil {
+		t.Fatal(err)
+	}
+	return imageRecord
+}
+
+// serve sends a request with a JSON body to handler, registered at route.
+func serve(handler gin.HandlerFunc, method, route, target, body string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, route, handler)
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
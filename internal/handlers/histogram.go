@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"fmt"
+	"goga/internal/adjust"
+	"net/http"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+// GetHistogram returns per-channel and luminance counts of the current
+// image version for the editor's histogram display.
+func (h *EditHandler) GetHistogram(c *gin.Context) {
+	imageRecord, err := h.repo.GetByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	etag := fmt.Sprintf(`"%s-%s-histogram"`, imageRecord.ID, imageRecord.Version)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	src, err := imaging.Open(imageRecord.Path, imaging.AutoOrientation(true))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open image"})
+		return
+	}
+	c.JSON(http.StatusOK, adjust.ComputeHistogram(imaging.Clone(src)))
+}
@@ -1,35 +1,90 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"goga/internal/config"
 	"goga/internal/models"
+	"goga/internal/pipeline"
 	"goga/internal/repository"
+	"goga/internal/watermark"
 	"goga/pkg/utils"
 	"io"
+	"log"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/disintegration/imaging"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+// maxSignedURLLifetime caps how long a signed URL may stay valid (seconds).
+const maxSignedURLLifetime = 7 * 24 * 3600
+
+// watermarkedCacheSize bounds the watermarked files kept for share links
+// and exports.
+const watermarkedCacheSize = 512 << 20 // 512MB
+
 type ImageHandler struct {
-	repo      *repository.ImageRepository
-	uploadDir string
+	repo        *repository.ImageRepository
+	watermarks  *repository.WatermarkRepository
+	logos       *watermark.Logos
+	uploadDir   string
+	signingKey  []byte
+	settings    config.Images
+	watermarked *utils.DiskCache
+	uploadHooks []func(*models.Image)
+	deleteHooks []func(id string)
 }
 
-func NewImageHandler(repo *repository.ImageRepository, uploadDir string) *ImageHandler {
-	return &ImageHandler{
-		repo:      repo,
-		uploadDir: uploadDir,
+func NewImageHandler(repo *repository.ImageRepository, watermarks *repository.WatermarkRepository,
+	logos *watermark.Logos, uploadDir string, signingKey []byte, settings config.Images) (*ImageHandler, error) {
+	watermarked, err := utils.NewDiskCache(filepath.Join(uploadDir, "watermarked"), watermarkedCacheSize)
+	if err != nil {
+		return nil, err
 	}
+	return &ImageHandler{
+		repo:        repo,
+		watermarks:  watermarks,
+		logos:       logos,
+		uploadDir:   uploadDir,
+		signingKey:  signingKey,
+		settings:    settings,
+		watermarked: watermarked,
+	}, nil
+}
+
+// OnUpload registers a function that is called after an image has been
+// stored. Hooks run on the request goroutine and should hand long work to
+// the job manager.
+func (h *ImageHandler) OnUpload(hook func(*models.Image)) {
+	h.uploadHooks = append(h.uploadHooks, hook)
+}
+
+// OnDelete registers a function that is called after an image was deleted,
+// so that data stored alongside it can be cleaned up.
+func (h *ImageHandler) OnDelete(hook func(id string)) {
+	h.deleteHooks = append(h.deleteHooks, hook)
 }
 
 func (h *ImageHandler) GetImages(c *gin.Context) {
-	images, err := h.repo.GetAll()
+	filter, err := parseImageFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	images, err := h.repo.List(filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -56,7 +111,7 @@ func (h *ImageHandler) UploadImage(c *gin.Context) {
 	defer file.Close()
 	
 	// CRITICAL: Validate file before processing
-	if err := utils.ValidateImageUpload(file, header); err != nil {
+	if err := utils.ValidateImageUpload(file, header, h.settings.MaxUploadSize()); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -114,9 +169,19 @@ func (h *ImageHandler) UploadImage(c *gin.Context) {
 		return
 	}
 
+	h.created(image)
+
 	c.JSON(http.StatusCreated, image)
 }
 
+// created runs the upload hooks for an image added to the library, whether
+// uploaded or derived from another one.
+func (h *ImageHandler) created(image *models.Image) {
+	for _, hook := range h.uploadHooks {
+		hook(image)
+	}
+}
+
 func (h *ImageHandler) ConvertImage(c *gin.Context) {
 	id := c.Param("id")
 	
@@ -139,13 +204,30 @@ func (h *ImageHandler) ConvertImage(c *gin.Context) {
 	// Convert image
 	quality := req.Quality
 	if quality == 0 {
-		quality = 85 // Default quality
+		quality = h.settings.JPEGQuality
+	}
+
+	policy, err := utils.ParseMetadataPolicy(req.Metadata, utils.MetadataKeep)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Converting to the same format overwrites the source, so read it first
+	original, err := os.ReadFile(image.Path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read image"})
+		return
 	}
 
 	if err := utils.ProcessImage(image.Path, newPath, req.Format, quality); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert image"})
 		return
 	}
+	// Re-encoding drops EXIF, so carry over what the policy allows
+	if err := utils.CopyExif(original, newPath, policy); err != nil {
+		log.Printf("Failed to copy EXIF to %s: %v", newPath, err)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Image converted successfully",
@@ -175,6 +257,10 @@ func (h *ImageHandler) DeleteImage(c *gin.Context) {
 		return
 	}
 
+	for _, hook := range h.deleteHooks {
+		hook(id)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
 }
 
@@ -187,23 +273,331 @@ func (h *ImageHandler) ServeImage(c *gin.Context) {
 		return
 	}
 
-	// Add cache-busting headers
-	c.Header("Cache-Control", "no-cache, no-store, must-revalidate")
-	c.Header("Pragma", "no-cache")
-	c.Header("Expires", "0")
-	
+	policy, ok := metadataPolicy(c, utils.MetadataKeep)
+	if !ok {
+		return
+	}
+
+	// Unversioned URLs must be revalidated, which is cheap thanks to ETags
+	h.serveFile(c, image, "no-cache", policy)
+}
+
+// DownloadImage sends the original as an attachment with the title,
+// caption, copyright and keywords written into JPEG and PNG files.
+// ?metadata= selects what embedded metadata is kept. ?watermark= applies a
+// saved watermark instead, which re-encodes the image without metadata.
+func (h *ImageHandler) DownloadImage(c *gin.Context) {
+	id := c.Param("id")
+
+	image, err := h.repo.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	if ref := c.Query("watermark"); ref != "" {
+		mark, err := savedWatermark(h.watermarks, h.logos, ref)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Watermark not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid watermark: " + err.Error()})
+			return
+		}
+		h.sendWatermarked(c, image, mark, 0, true, "no-cache")
+		return
+	}
+
+	policy, ok := metadataPolicy(c, utils.MetadataKeep)
+	if !ok {
+		return
+	}
+	h.sendDownload(c, image, policy)
+}
+
+// UpdateMetadata edits the descriptive metadata of an image.
+func (h *ImageHandler) UpdateMetadata(c *gin.Context) {
+	var req models.ImageMetadataRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	image, err := h.repo.GetByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	if req.Title != nil {
+		image.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Caption != nil {
+		image.UserCaption = strings.TrimSpace(*req.Caption)
+	}
+	if req.Copyright != nil {
+		image.Copyright = strings.TrimSpace(*req.Copyright)
+	}
+	if req.Keywords != nil {
+		image.Keywords = []string{}
+		for _, k := range *req.Keywords {
+			if k = strings.TrimSpace(k); k != "" {
+				image.Keywords = append(image.Keywords, k)
+			}
+		}
+	}
+
+	if err := h.repo.UpdateMetadata(image); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, image)
+}
+
+// ServeVersionedImage serves /images/:id/v/:version. The version changes
+// whenever the file does, so matching requests can be cached forever.
+func (h *ImageHandler) ServeVersionedImage(c *gin.Context) {
+	id := c.Param("id")
+
+	image, err := h.repo.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	if c.Param("version") != image.Version {
+		target := fmt.Sprintf("/api/images/%s/v/%s", image.ID, image.Version)
+		if c.Request.URL.RawQuery != "" {
+			target += "?" + c.Request.URL.RawQuery
+		}
+		c.Header("Cache-Control", "no-cache")
+		c.Redirect(http.StatusFound, target)
+		return
+	}
+
+	policy, ok := metadataPolicy(c, utils.MetadataKeep)
+	if !ok {
+		return
+	}
+	h.serveFile(c, image, "public, max-age=31536000, immutable", policy)
+}
+
+// SignedURL issues a time-limited HMAC-signed URL for an image.
+func (h *ImageHandler) SignedURL(c *gin.Context) {
+	id := c.Param("id")
+
+	image, err := h.repo.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	expiresIn := 3600
+	if v := c.Query("expires_in"); v != "" {
+		if expiresIn, err = strconv.Atoi(v); err != nil || expiresIn <= 0 || expiresIn > maxSignedURLLifetime {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expires_in must be between 1 and %d", maxSignedURLLifetime)})
+			return
+		}
+	}
+
+	thumb := 0
+	if v := c.Query("thumb"); v != "" {
+		if thumb, err = strconv.Atoi(v); err != nil || thumb <= 0 || thumb > h.settings.MaxThumbSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("thumb must be between 1 and %d", h.settings.MaxThumbSize)})
+			return
+		}
+	}
+
+	expires := time.Now().Add(time.Duration(expiresIn) * time.Second).Unix()
+	sig := utils.SignImageURL(h.signingKey, image.ID, thumb, expires)
+	url := fmt.Sprintf("/signed/images/%s?exp=%d&sig=%s", image.ID, expires, sig)
+	if thumb > 0 {
+		url += fmt.Sprintf("&thumb=%d", thumb)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":        url,
+		"expires_at": time.Unix(expires, 0),
+	})
+}
+
+// ServeSignedImage serves URLs produced by SignedURL after checking the
+// signature and expiry.
+func (h *ImageHandler) ServeSignedImage(c *gin.Context) {
+	id := c.Param("id")
+
+	expires, err := strconv.ParseInt(c.Query("exp"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid signature"})
+		return
+	}
+	thumb := 0
+	if v := c.Query("thumb"); v != "" {
+		if thumb, err = strconv.Atoi(v); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid signature"})
+			return
+		}
+	}
+	if !utils.VerifyImageURL(h.signingKey, id, thumb, expires, c.Query("sig")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid signature"})
+		return
+	}
+	remaining := time.Until(time.Unix(expires, 0))
+	if remaining <= 0 {
+		c.JSON(http.StatusGone, gin.H{"error": "URL has expired"})
+		return
+	}
+
+	image, err := h.repo.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	policy, ok := metadataPolicy(c, utils.MetadataKeep)
+	if !ok {
+		return
+	}
+	h.serveFile(c, image, fmt.Sprintf("private, max-age=%d", int(remaining.Seconds())), policy)
+}
+
+// serveFile sends the image or, when ?thumb= is set, a cached thumbnail.
+// ETag and Last-Modified are set so that http.ServeContent answers
+// conditional requests with 304 Not Modified. Thumbnails are re-encoded and
+// carry no metadata, so policy only applies to the original.
+func (h *ImageHandler) serveFile(c *gin.Context, image *models.Image, cacheControl string, policy utils.MetadataPolicy) {
+	path := image.Path
+	size := 0
+
 	// Get thumbnail size if requested
 	if thumb := c.Query("thumb"); thumb != "" {
-		if size, err := strconv.Atoi(thumb); err == nil && size > 0 && size <= 500 {
-			h.serveThumbnail(c, image, size)
-			return
+		if n, err := strconv.Atoi(thumb); err == nil && n > 0 && n <= h.settings.MaxThumbSize {
+			if thumbPath, err := h.thumbnail(image, n); err == nil {
+				path = thumbPath
+				size = n
+			}
 		}
 	}
 
-	c.File(image.Path)
+	c.Header("Cache-Control", cacheControl)
+	c.Header("Last-Modified", image.UpdatedAt.UTC().Format(http.TimeFormat))
+	if size > 0 || policy == utils.MetadataKeep {
+		c.Header("ETag", fmt.Sprintf(`"%s-%s-%d"`, image.ID, image.Version, size))
+		c.File(path)
+		return
+	}
+
+	data, err := h.export(image, policy, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read image"})
+		return
+	}
+	c.Header("ETag", fmt.Sprintf(`"%s-%s-0-%s"`, image.ID, image.Version, policy))
+	http.ServeContent(c.Writer, c.Request, image.Filename, image.UpdatedAt, bytes.NewReader(data))
+}
+
+// sendDownload sends the original as an attachment. Unless all metadata is
+// stripped, the descriptive fields are embedded as XMP.
+func (h *ImageHandler) sendDownload(c *gin.Context, image *models.Image, policy utils.MetadataPolicy) {
+	data, err := h.export(image, policy, policy != utils.MetadataStripAll)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare download"})
+		return
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": image.OriginalName}))
+	c.Data(http.StatusOK, utils.ContentType(image.Format), data)
+}
+
+// export reads the original file and applies the metadata policy, optionally
+// embedding title, caption, copyright and keywords.
+func (h *ImageHandler) export(image *models.Image, policy utils.MetadataPolicy, embed bool) ([]byte, error) {
+	data, err := os.ReadFile(image.Path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = utils.SanitizeMetadata(data, policy); err != nil {
+		return nil, err
+	}
+	if !embed {
+		return data, nil
+	}
+	return utils.EmbedXMP(data, utils.XMPMetadata{
+		Title:       image.Title,
+		Description: image.UserCaption,
+		Copyright:   image.Copyright,
+		Keywords:    image.Keywords,
+	})
+}
+
+// sendWatermarked serves image with mark applied, as a thumbnail fitting
+// size (0 for full size) or as an attachment. The result is re-encoded,
+// carries no metadata and is cached by image version and watermark.
+func (h *ImageHandler) sendWatermarked(c *gin.Context, image *models.Image, mark *pipeline.Watermark, size int,
+	attachment bool, cacheControl string) {
+	format, quality := image.Format, 90
+	if size > 0 {
+		format, quality = "jpeg", 80
+	} else if format != "png" && format != "webp" {
+		format = "jpeg"
+	}
+	spec, _ := json.Marshal(mark.Spec)
+	sum := sha256.Sum256(spec)
+	key := fmt.Sprintf("%s_%s_%d_%x.%s", image.ID, image.Version, size, sum[:8], format)
+
+	c.Header("Cache-Control", cacheControl)
+	c.Header("ETag", `"`+key+`"`)
+	if attachment {
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": image.OriginalName}))
+	}
+	if f, ok := h.watermarked.Get(key); ok {
+		serveCached(c, f)
+		return
+	}
+
+	src, err := imaging.Open(image.Path, imaging.AutoOrientation(true))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open image"})
+		return
+	}
+	if b := src.Bounds(); size > 0 && (b.Dx() > size || b.Dy() > size) {
+		src = imaging.Fit(src, size, size, imaging.Lanczos)
+	}
+	finalImg := mark.Apply(imaging.Clone(src))
+
+	var buf bytes.Buffer
+	if err := utils.EncodeImage(&buf, finalImg, format, quality); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode image"})
+		return
+	}
+	if err := h.watermarked.Put(key, buf.Bytes()); err != nil {
+		log.Printf("Failed to cache watermarked image %s: %v", key, err)
+	}
+	c.Data(http.StatusOK, utils.ContentType(format), buf.Bytes())
 }
 
-func (h *ImageHandler) serveThumbnail(c *gin.Context, image *models.Image, size int) {
+// serveCached sends a file opened from a DiskCache and closes it. Like
+// c.File it answers conditional and range requests.
+func serveCached(c *gin.Context, f *os.File) {
+	defer f.Close()
+	var modTime time.Time
+	if info, err := f.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+	http.ServeContent(c.Writer, c.Request, filepath.Base(f.Name()), modTime, f)
+}
+
+// metadataPolicy reads ?metadata= and writes a 400 response if it is invalid.
+func metadataPolicy(c *gin.Context, def utils.MetadataPolicy) (utils.MetadataPolicy, bool) {
+	policy, err := utils.ParseMetadataPolicy(c.Query("metadata"), def)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return policy, true
+}
+
+// thumbnail returns the path of a cached thumbnail, generating it if needed.
+func (h *ImageHandler) thumbnail(image *models.Image, size int) (string, error) {
 	thumbDir := filepath.Join(h.uploadDir, "thumbs")
 	utils.EnsureDir(thumbDir)
 	
@@ -213,14 +607,8 @@ func (h *ImageHandler) serveThumbnail(c *gin.Context, image *models.Image, size
 	if _, err := os.Stat(thumbPath); os.IsNotExist(err) {
 		// Generate thumbnail
 		if err := utils.ProcessImage(image.Path, thumbPath, "jpeg", 80); err != nil {
-			c.File(image.Path) // Fallback to original
-			return
+			return "", err
 		}
 	}
-	
-	// Add cache-busting headers for thumbnails
-	c.Header("Cache-Control", "no-cache, no-store, must-revalidate")
-	c.Header("Pragma", "no-cache")
-	c.Header("Expires", "0")
-	c.File(thumbPath)
-}
\ No newline at end of file
+	return thumbPath, nil
+}
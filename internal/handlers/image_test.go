@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"goga/internal/config"
+	"goga/internal/models"
+	"goga/internal/repository"
+	"goga/pkg/utils"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+var testSigningKey = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestImageHandler(t *testing.T) (*ImageHandler, *repository.ImageRepository, *models.Image) {
+	t.Helper()
+	repo := repository.NewImageRepository(openTestDB(t))
+	if err := repo.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	uploadDir := t.TempDir()
+	h, err := NewImageHandler(repo, nil, nil, uploadDir, testSigningKey, config.Default().Images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageRecord := createTestImage(t, repo, uploadDir, imaging.New(64, 48, color.NRGBA{40, 90, 160, 255}))
+	return h, repo, imageRecord
+}
+
+// imageRouter registers the file routes of h as the server does.
+func imageRouter(h *ImageHandler) *gin.Engine {
+	router := gin.New()
+	router.GET("/signed/images/:id", h.ServeSignedImage)
+	router.GET("/api/images/:id/file", h.ServeImage)
+	router.GET("/api/images/:id/v/:version", h.ServeVersionedImage)
+	router.GET("/api/images/:id/signed-url", h.SignedURL)
+	return router
+}
+
+func get(router http.Handler, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestSignedURL(t *testing.T) {
+	h, repo, imageRecord := newTestImageHandler(t)
+	other := createTestImage(t, repo, t.TempDir(), imaging.New(32, 32, color.White))
+	router := imageRouter(h)
+
+	w := get(router, "/api/images/"+imageRecord.ID+"/signed-url?expires_in=60&thumb=32", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("signed-url returned %d: %s", w.Code, w.Body)
+	}
+	var issued struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &issued); err != nil {
+		t.Fatal(err)
+	}
+	w = get(router, issued.URL, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("issued URL returned %d: %s", w.Code, w.Body)
+	}
+	if cc := w.Header().Get("Cache-Control"); !strings.HasPrefix(cc, "private, max-age=") {
+		t.Errorf("Cache-Control is %q, want private with the remaining lifetime", cc)
+	}
+
+	exp := time.Now().Add(time.Minute).Unix()
+	past := time.Now().Add(-time.Minute).Unix()
+	sig := utils.SignImageURL(testSigningKey, imageRecord.ID, 0, exp)
+	signed := func(id string, query string) string {
+		return "/signed/images/" + id + "?" + query
+	}
+	for _, tc := range []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"valid", signed(imageRecord.ID, fmt.Sprintf("exp=%d&sig=%s", exp, sig)), http.StatusOK},
+		{"other image", signed(other.ID, fmt.Sprintf("exp=%d&sig=%s", exp, sig)), http.StatusForbidden},
+		{"later expiry", signed(imageRecord.ID, fmt.Sprintf("exp=%d&sig=%s", exp+3600, sig)), http.StatusForbidden},
+		{"added thumb", signed(imageRecord.ID, fmt.Sprintf("exp=%d&sig=%s&thumb=32", exp, sig)), http.StatusForbidden},
+		{"changed signature", signed(imageRecord.ID, fmt.Sprintf("exp=%d&sig=%s", exp, flipHex(sig))), http.StatusForbidden},
+		{"signature not hex", signed(imageRecord.ID, fmt.Sprintf("exp=%d&sig=zz", exp)), http.StatusForbidden},
+		{"no expiry", signed(imageRecord.ID, "sig="+sig), http.StatusForbidden},
+		{"no signature", signed(imageRecord.ID, fmt.Sprintf("exp=%d", exp)), http.StatusForbidden},
+		{"other key", signed(imageRecord.ID, fmt.Sprintf("exp=%d&sig=%s", exp,
+			utils.SignImageURL([]byte("another key"), imageRecord.ID, 0, exp))), http.StatusForbidden},
+		{"expired", signed(imageRecord.ID, fmt.Sprintf("exp=%d&sig=%s", past,
+			utils.SignImageURL(testSigningKey, imageRecord.ID, 0, past))), http.StatusGone},
+	} {
+		if w := get(router, tc.target, nil); w.Code != tc.want {
+			t.Errorf("%s: returned %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
+
+// flipHex changes the last digit of a hex string.
+func flipHex(s string) string {
+	last := "0"
+	if s[len(s)-1] == '0' {
+		last = "1"
+	}
+	return s[:len(s)-1] + last
+}
+
+func TestConditionalGet(t *testing.T) {
+	h, repo, imageRecord := newTestImageHandler(t)
+	router := imageRouter(h)
+	file := "/api/images/" + imageRecord.ID + "/file"
+	versioned := "/api/images/" + imageRecord.ID + "/v/" + imageRecord.Version
+
+	first := get(router, file, nil)
+	if first.Code != http.StatusOK {
+		t.Fatalf("file returned %d", first.Code)
+	}
+	etag, modified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if etag == "" || modified == "" {
+		t.Fatalf("ETag %q and Last-Modified %q must both be set", etag, modified)
+	}
+	if cc := first.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("unversioned Cache-Control is %q, want no-cache", cc)
+	}
+	thumb := get(router, file+"?thumb=32", nil)
+	if thumb.Code != http.StatusOK || thumb.Header().Get("ETag") == etag {
+		t.Errorf("thumbnail returned %d with ETag %q, want 200 with its own ETag", thumb.Code, thumb.Header().Get("ETag"))
+	}
+
+	for _, tc := range []struct {
+		name   string
+		target string
+		header http.Header
+		want   int
+	}{
+		{"matching ETag", file, http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{"ETag among others", file, http.Header{"If-None-Match": {`"other", ` + etag}}, http.StatusNotModified},
+		{"other ETag", file, http.Header{"If-None-Match": {`"other"`}}, http.StatusOK},
+		{"not modified since", file, http.Header{"If-Modified-Since": {modified}}, http.StatusNotModified},
+		{"modified since", file, http.Header{"If-Modified-Since": {
+			imageRecord.UpdatedAt.Add(-time.Hour).UTC().Format(http.TimeFormat)}}, http.StatusOK},
+		{"thumbnail ETag", file + "?thumb=32", http.Header{"If-None-Match": {thumb.Header().Get("ETag")}}, http.StatusNotModified},
+		{"ETag of the original for a thumbnail", file + "?thumb=32", http.Header{"If-None-Match": {etag}}, http.StatusOK},
+		{"versioned URL", versioned, http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{"stale version", "/api/images/" + imageRecord.ID + "/v/old", nil, http.StatusFound},
+	} {
+		w := get(router, tc.target, tc.header)
+		if w.Code != tc.want {
+			t.Errorf("%s: returned %d, want %d", tc.name, w.Code, tc.want)
+		}
+		if w.Code == http.StatusNotModified && w.Body.Len() > 0 {
+			t.Errorf("%s: 304 with a body", tc.name)
+		}
+	}
+	if cc := get(router, versioned, nil).Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("versioned Cache-Control is %q, want immutable", cc)
+	}
+
+	// Once the image changes the old ETag no longer matches
+	imageRecord.UpdatedAt = imageRecord.UpdatedAt.Add(time.Second)
+	if err := repo.Update(imageRecord); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(router, file, http.Header{"If-None-Match": {etag}}); w.Code != http.StatusOK {
+		t.Errorf("old ETag after a change returned %d, want 200", w.Code)
+	}
+	if w := get(router, versioned, nil); w.Code != http.StatusFound {
+		t.Errorf("old version after a change returned %d, want 302", w.Code)
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"goga/internal/jobs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JobHandler struct {
+	jobs *jobs.Manager
+}
+
+func NewJobHandler(jobManager *jobs.Manager) *JobHandler {
+	return &JobHandler{
+		jobs: jobManager,
+	}
+}
+
+func (h *JobHandler) GetJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, h.jobs.List())
+}
+
+func (h *JobHandler) GetJob(c *gin.Context) {
+	job, ok := h.jobs.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"goga/internal/jobs"
+	"goga/internal/models"
+	"goga/pkg/utils"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OptimizeRequest optimizes an image in place. The original is backed up
+// for ResetImage unless KeepOriginal is false; DryRun only reports what
+// would be saved.
+type OptimizeRequest struct {
+	utils.OptimizeOptions
+	KeepOriginal *bool `json:"keep_original"`
+	DryRun       bool  `json:"dry_run"`
+}
+
+// BatchOptimizeRequest selects images like BatchEditRequest.
+type BatchOptimizeRequest struct {
+	OptimizeRequest
+	IDs    []string `json:"ids"`
+	Filter string   `json:"filter"`
+}
+
+// OptimizeImage re-encodes an image as small as it gets without visible
+// loss, see utils.OptimizeOptions, and responds with the sizes before and
+// after.
+func (h *EditHandler) OptimizeImage(c *gin.Context) {
+	var req OptimizeRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	imageRecord, err := h.repo.GetByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	result, err := h.optimize(imageRecord, req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"image": imageRecord, "result": result})
+}
+
+// BatchOptimize optimizes many images in a background job.
+func (h *EditHandler) BatchOptimize(c *gin.Context) {
+	var req BatchOptimizeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ids, ok := h.batchIDs(c, req.IDs, req.Filter)
+	if !ok {
+		return
+	}
+
+	job := h.jobs.Submit("images.batch_optimize", func(ctx context.Context, job *jobs.Job) error {
+		return h.runBatch(ctx, job, ids, func(imageRecord *models.Image) (any, error) {
+			return h.optimize(imageRecord, req.OptimizeRequest)
+		})
+	})
+	c.JSON(http.StatusAccepted, job)
+}
+
+// OptimizeOnUpload returns an upload hook that queues optimization of
+// every uploaded image with opts. The upload is what gets stored, so no
+// backup is kept.
+func (h *EditHandler) OptimizeOnUpload(opts utils.OptimizeOptions) func(*models.Image) {
+	keep := false
+	req := OptimizeRequest{OptimizeOptions: opts, KeepOriginal: &keep}
+	return func(image *models.Image) {
+		h.jobs.Submit("images.optimize", func(ctx context.Context, job *jobs.Job) error {
+			imageRecord, err := h.repo.GetByID(image.ID)
+			if err != nil {
+				return err
+			}
+			result, err := h.optimize(imageRecord, req)
+			if err != nil {
+				log.Printf("Failed to optimize %s: %v", image.ID, err)
+				return err
+			}
+			job.SetResult(gin.H{"image_id": image.ID, "result": result})
+			return nil
+		})
+	}
+}
+
+// optimize runs the optimizer on the file of imageRecord and, unless it is
+// a dry run, replaces the file when a smaller encoding was found.
+func (h *EditHandler) optimize(imageRecord *models.Image, req OptimizeRequest) (*utils.OptimizeResult, error) {
+	defer h.locks.lock(imageRecord.ID)()
+	if err := h.reload(imageRecord); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(imageRecord.Path)
+	if err != nil {
+		return nil, errors.New("failed to read image")
+	}
+	optimized, result, err := utils.Optimize(data, req.OptimizeOptions)
+	if err != nil {
+		return nil, err
+	}
+	if req.DryRun || result.Method == "none" {
+		return result, nil
+	}
+
+	if req.KeepOriginal == nil || *req.KeepOriginal {
+		if err := h.backup(imageRecord); err != nil {
+			return nil, err
+		}
+	}
+	err = replaceFile(imageRecord.Path, func(w io.Writer) error {
+		_, err := w.Write(optimized)
+		return err
+	})
+	if err != nil {
+		return nil, errors.New("failed to save image")
+	}
+
+	utils.ClearThumbnailCache(h.uploadDir, imageRecord.ID)
+	if err := h.refreshRecord(imageRecord); err != nil {
+		return nil, errors.New("failed to update image record")
+	}
+	return result, nil
+}
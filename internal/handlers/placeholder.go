@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"goga/internal/jobs"
+	"goga/internal/models"
+	"goga/pkg/utils"
+	"image"
+	"log"
+	"net/http"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+// paletteColors is the most dominant colours kept per image.
+const paletteColors = 6
+
+// PlaceholderOnUpload is registered as an upload hook and queues computing
+// the placeholders and palette of the uploaded image. The job reloads the
+// record rather than sharing it with the other upload hooks.
+func (h *EditHandler) PlaceholderOnUpload(image *models.Image) {
+	id := image.ID
+	h.jobs.Submit("images.placeholder", func(ctx context.Context, job *jobs.Job) error {
+		imageRecord, err := h.repo.GetByID(id)
+		if err != nil {
+			return err
+		}
+		if err := setPlaceholders(imageRecord, nil); err != nil {
+			return err
+		}
+		return h.repo.SetPlaceholders(imageRecord)
+	})
+}
+
+// ScanPlaceholders queues a job that computes the placeholders and palette
+// of images that have none yet, e.g. those uploaded before they were
+// supported.
+func (h *EditHandler) ScanPlaceholders(c *gin.Context) {
+	job := h.jobs.Submit("images.placeholder_scan", func(ctx context.Context, job *jobs.Job) error {
+		images, err := h.repo.GetAll()
+		if err != nil {
+			return err
+		}
+		updated := 0
+		for i := range images {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if images[i].BlurHash != "" {
+				continue
+			}
+			if h.updatePlaceholders(&images[i], nil) {
+				updated++
+			}
+		}
+		job.SetResult(gin.H{"scanned": len(images), "updated": updated})
+		return nil
+	})
+	c.JSON(http.StatusAccepted, job)
+}
+
+// updatePlaceholders computes and stores the placeholders and palette of an
+// image, from src if it was decoded already.
+func (h *EditHandler) updatePlaceholders(imageRecord *models.Image, src image.Image) bool {
+	if err := setPlaceholders(imageRecord, src); err != nil {
+		log.Printf("Failed to compute placeholders of %s: %v", imageRecord.ID, err)
+		return false
+	}
+	if err := h.repo.SetPlaceholders(imageRecord); err != nil {
+		log.Printf("Failed to store placeholders of %s: %v", imageRecord.ID, err)
+		return false
+	}
+	return true
+}
+
+// setPlaceholders computes the LQIP, BlurHash and palette of an image from
+// src, or from its file if src is nil. On failure they are cleared rather
+// than left describing an older version of the file.
+func setPlaceholders(imageRecord *models.Image, src image.Image) error {
+	imageRecord.Placeholder, imageRecord.BlurHash, imageRecord.Palette = "", "", nil
+
+	if src == nil {
+		var err error
+		if src, err = imaging.Open(imageRecord.Path, imaging.AutoOrientation(true)); err != nil {
+			return err
+		}
+	}
+	placeholder, err := utils.Placeholder(src)
+	if err != nil {
+		return err
+	}
+	x, y := utils.BlurHashComponents(src.Bounds().Dx(), src.Bounds().Dy())
+	blurHash, err := utils.BlurHash(src, x, y)
+	if err != nil {
+		return err
+	}
+	palette := []models.PaletteColor{}
+	for _, p := range utils.Palette(src, paletteColors) {
+		palette = append(palette, models.PaletteColor{Color: p.Color, Share: p.Share})
+	}
+
+	imageRecord.Placeholder, imageRecord.BlurHash, imageRecord.Palette = placeholder, blurHash, palette
+	return nil
+}
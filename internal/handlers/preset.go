@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goga/internal/models"
+	"goga/internal/repository"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const maxPresetName = 100
+
+// builtinPresets ship with the binary and cannot be changed or deleted.
+var builtinPresets = []models.Preset{
+	{ID: "builtin-bw", Name: "B&W", Edit: json.RawMessage(`{"saturation":-50,"contrast":10}`)},
+	{ID: "builtin-warm", Name: "Warm", Edit: json.RawMessage(`{"temperature":20,"vibrance":10,"brightness":3}`)},
+	{ID: "builtin-high-contrast", Name: "High contrast", Edit: json.RawMessage(`{"contrast":30,"clarity":20,"saturation":10}`)},
+}
+
+func init() {
+	for i := range builtinPresets {
+		builtinPresets[i].BuiltIn = true
+	}
+}
+
+type PresetHandler struct {
+	repo *repository.PresetRepository
+}
+
+func NewPresetHandler(repo *repository.PresetRepository) *PresetHandler {
+	return &PresetHandler{repo: repo}
+}
+
+// GetPresets lists built-in and global presets plus those of ?owner=.
+func (h *PresetHandler) GetPresets(c *gin.Context) {
+	stored, err := h.repo.List(c.Query("owner"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, append(append([]models.Preset{}, builtinPresets...), stored...))
+}
+
+func (h *PresetHandler) GetPreset(c *gin.Context) {
+	preset, err := findPreset(h.repo, c.Param("id"), "")
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Preset not found"})
+		return
+	}
+	c.JSON(http.StatusOK, preset)
+}
+
+func (h *PresetHandler) CreatePreset(c *gin.Context) {
+	var req models.PresetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	preset := &models.Preset{ID: uuid.New().String(), CreatedAt: now, UpdatedAt: now}
+	if err := fillPreset(preset, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := h.repo.GetByName(preset.Owner, preset.Name); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A preset with this name already exists"})
+		return
+	}
+
+	if err := h.repo.Create(preset); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save preset"})
+		return
+	}
+	c.JSON(http.StatusCreated, preset)
+}
+
+func (h *PresetHandler) UpdatePreset(c *gin.Context) {
+	id := c.Param("id")
+	if isBuiltinPreset(id) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Built-in presets cannot be changed"})
+		return
+	}
+
+	var req models.PresetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preset, err := h.repo.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Preset not found"})
+		return
+	}
+	if err := fillPreset(preset, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if other, err := h.repo.GetByName(preset.Owner, preset.Name); err == nil && other.ID != preset.ID {
+		c.JSON(http.StatusConflict, gin.H{"error": "A preset with this name already exists"})
+		return
+	}
+
+	preset.UpdatedAt = time.Now()
+	if err := h.repo.Update(preset); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save preset"})
+		return
+	}
+	c.JSON(http.StatusOK, preset)
+}
+
+func (h *PresetHandler) DeletePreset(c *gin.Context) {
+	id := c.Param("id")
+	if isBuiltinPreset(id) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Built-in presets cannot be deleted"})
+		return
+	}
+	if err := h.repo.Delete(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Preset not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Preset deleted"})
+}
+
+// ExportPresets downloads the global presets and those of ?owner= in the
+// format accepted by ImportPresets. Built-in presets are not exported.
+func (h *PresetHandler) ExportPresets(c *gin.Context) {
+	stored, err := h.repo.List(c.Query("owner"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	export := make([]models.PresetRequest, len(stored))
+	for i, p := range stored {
+		export[i] = models.PresetRequest{Name: p.Name, Owner: p.Owner, Edit: p.Edit}
+	}
+	c.Header("Content-Disposition", `attachment; filename="goga-presets.json"`)
+	c.JSON(http.StatusOK, export)
+}
+
+// ImportPresets creates presets from an exported JSON array. Presets whose
+// owner and name already exist are skipped unless ?replace=true.
+func (h *PresetHandler) ImportPresets(c *gin.Context) {
+	var reqs []models.PresetRequest
+	if err := c.ShouldBindJSON(&reqs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate everything first so a bad entry does not leave a partial import
+	now := time.Now()
+	presets := make([]*models.Preset, len(reqs))
+	for i, req := range reqs {
+		presets[i] = &models.Preset{ID: uuid.New().String(), CreatedAt: now, UpdatedAt: now}
+		if err := fillPreset(presets[i], req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Preset %d: %v", i+1, err)})
+			return
+		}
+	}
+
+	replace := c.Query("replace") == "true"
+	created, replaced, skipped := 0, 0, 0
+	for _, preset := range presets {
+		existing, err := h.repo.GetByName(preset.Owner, preset.Name)
+		switch {
+		case err == nil && !replace:
+			skipped++
+			continue
+		case err == nil:
+			existing.Edit, existing.UpdatedAt = preset.Edit, now
+			err = h.repo.Update(existing)
+			replaced++
+		case errors.Is(err, sql.ErrNoRows):
+			err = h.repo.Create(preset)
+			created++
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save preset " + preset.Name})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"created": created, "replaced": replaced, "skipped": skipped})
+}
+
+// fillPreset validates a request and copies it into preset. The edit must
+// decode into an EditRequest without unknown fields; only the fields given
+// are stored, so presets can be layered over other settings.
+func fillPreset(preset *models.Preset, req models.PresetRequest) error {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxPresetName {
+		return fmt.Errorf("name must be between 1 and %d characters", maxPresetName)
+	}
+
+	var edit EditRequest
+	dec := json.NewDecoder(bytes.NewReader(req.Edit))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&edit); err != nil {
+		return fmt.Errorf("invalid edit: %v", err)
+	}
+	if err := edit.validate(); err != nil {
+		return fmt.Errorf("invalid edit: %v", err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, req.Edit); err != nil {
+		return fmt.Errorf("invalid edit: %v", err)
+	}
+
+	preset.Name = name
+	preset.Owner = strings.TrimSpace(req.Owner)
+	preset.Edit = compact.Bytes()
+	return nil
+}
+
+func isBuiltinPreset(id string) bool {
+	for _, p := range builtinPresets {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// findPreset resolves a preset by ID, or else by name, preferring presets of
+// owner over global and then built-in ones.
+func findPreset(repo *repository.PresetRepository, ref, owner string) (*models.Preset, error) {
+	for i := range builtinPresets {
+		if builtinPresets[i].ID == ref {
+			return &builtinPresets[i], nil
+		}
+	}
+	if preset, err := repo.GetByID(ref); err == nil {
+		return preset, nil
+	}
+	if owner != "" {
+		if preset, err := repo.GetByName(owner, ref); err == nil {
+			return preset, nil
+		}
+	}
+	if preset, err := repo.GetByName("", ref); err == nil {
+		return preset, nil
+	}
+	for i := range builtinPresets {
+		if strings.EqualFold(builtinPresets[i].Name, ref) {
+			return &builtinPresets[i], nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"goga/internal/models"
+	"goga/internal/pipeline"
+	"goga/pkg/utils"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strconv"
+	"sync"
+
+	"github.com/disintegration/imaging"
+)
+
+// Live previews are rendered from downscaled proxies of the original that
+// are kept in memory, keyed by image version and proxy size.
+const (
+	previewDefaultSize = 1600
+	previewMinSize     = 64
+	previewCacheSize   = 256 << 20 // 256MB of decoded pixels
+)
+
+type previewProxy struct {
+	img   *image.NRGBA
+	scale float64 // proxy size relative to the original
+}
+
+// previewer holds the proxy cache and the previews being rendered.
+// Requests with identical settings for the same image and client share
+// one render, and a request with new settings cancels the render it
+// supersedes, so dragging a slider never queues up stale work.
+type previewer struct {
+	proxies *utils.MemoryCache
+
+	mu      sync.Mutex
+	loading map[string]chan struct{}  // proxies being decoded
+	flights map[string]*previewFlight // by image and client
+}
+
+type previewFlight struct {
+	key     string
+	cancel  context.CancelFunc
+	waiters int
+	done    chan struct{}
+	data    []byte
+	err     error
+}
+
+func newPreviewer() *previewer {
+	return &previewer{
+		proxies: utils.NewMemoryCache(previewCacheSize),
+		loading: make(map[string]chan struct{}),
+		flights: make(map[string]*previewFlight),
+	}
+}
+
+// do returns the output of render for key. If slot already renders the same
+// key the caller waits for that result; a different key is cancelled first.
+// The render itself is cancelled, and forgotten, once every caller waiting
+// for it is gone.
+func (p *previewer) do(ctx context.Context, slot, key string, render func(context.Context) ([]byte, error)) ([]byte, error) {
+	p.mu.Lock()
+	f := p.flights[slot]
+	if f != nil && f.key == key {
+		f.waiters++
+	} else {
+		if f != nil {
+			f.cancel()
+		}
+		renderCtx, cancel := context.WithCancel(context.Background())
+		f = &previewFlight{key: key, cancel: cancel, waiters: 1, done: make(chan struct{})}
+		p.flights[slot] = f
+		go func(f *previewFlight) {
+			f.data, f.err = render(renderCtx)
+			cancel()
+			p.mu.Lock()
+			if p.flights[slot] == f {
+				delete(p.flights, slot)
+			}
+			p.mu.Unlock()
+			close(f.done)
+		}(f)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.data, f.err
+	case <-ctx.Done():
+		p.mu.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			// Cancelled flights must not be joined by later requests
+			f.cancel()
+			if p.flights[slot] == f {
+				delete(p.flights, slot)
+			}
+		}
+		p.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// proxy returns the proxy of imageRecord whose long edge is at most
+// maxSize, decoding the original only once however many requests need it.
+func (p *previewer) proxy(ctx context.Context, imageRecord *models.Image, maxSize int) (*previewProxy, error) {
+	key := fmt.Sprintf("%s_%s_%d", imageRecord.ID, imageRecord.Version, maxSize)
+	for {
+		if v, ok := p.proxies.Get(key); ok {
+			return v.(*previewProxy), nil
+		}
+
+		p.mu.Lock()
+		if wait, ok := p.loading[key]; ok {
+			p.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		p.loading[key] = done
+		p.mu.Unlock()
+
+		proxy, err := loadProxy(imageRecord.Path, maxSize)
+		if err == nil {
+			p.proxies.Put(key, proxy, int64(len(proxy.img.Pix)))
+		}
+		p.mu.Lock()
+		delete(p.loading, key)
+		p.mu.Unlock()
+		close(done)
+		return proxy, err
+	}
+}
+
+func loadProxy(path string, maxSize int) (*previewProxy, error) {
+	src, err := imaging.Open(path, imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	if bounds.Dx() <= maxSize && bounds.Dy() <= maxSize {
+		return &previewProxy{img: imaging.Clone(src), scale: 1}, nil
+	}
+	img := imaging.Fit(src, maxSize, maxSize, imaging.Lanczos)
+	return &previewProxy{img: img, scale: float64(img.Rect.Dx()) / float64(bounds.Dx())}, nil
+}
+
+// parsePreviewSize reads ?maxSize=, the long edge of the preview proxy.
+func parsePreviewSize(value string) (int, error) {
+	if value == "" {
+		return previewDefaultSize, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < previewMinSize || size > renderMaxDimension {
+		return 0, fmt.Errorf("maxSize must be between %d and %d", previewMinSize, renderMaxDimension)
+	}
+	return size, nil
+}
+
+// renderPreview applies the resolved steps to the proxy and encodes the
+// result as JPEG.
+func (h *EditHandler) renderPreview(ctx context.Context, imageRecord *models.Image, steps pipeline.Pipeline, maxSize int) ([]byte, error) {
+	proxy, err := h.previews.proxy(ctx, imageRecord, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	finalImg, err := steps.Scaled(proxy.scale).Apply(ctx, proxy.img)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, utils.Flatten(finalImg, color.White), &jpeg.Options{Quality: h.settings.JPEGQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
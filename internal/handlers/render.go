@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"goga/internal/pipeline"
+	"goga/pkg/utils"
+	"image"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+// Limits for /render parameters. Widths and heights must be one of the
+// configured render sizes, quality and blur are snapped to coarse steps and
+// sizes are never larger than the original, which keeps the number of
+// distinct renditions per image small.
+const (
+	renderMaxDimension = 4096
+	renderQualityStep  = 5
+	renderMaxBlur      = 20
+	renderBlurStep     = 0.5
+	renderCacheSize    = 512 << 20 // 512MB
+)
+
+type renderParams struct {
+	Width     int
+	Height    int
+	Fit       string
+	Format    string
+	Quality   int
+	Blur      float64
+	Watermark string // id of a saved watermark
+}
+
+// key identifies a rendition; the image version keeps edited images from
+// being served stale renditions. Saved watermarks cannot be changed, so
+// their id identifies them.
+func (p renderParams) key(id, version string) string {
+	key := fmt.Sprintf("%s_%s_%dx%d_%s_q%d_b%g", id, version, p.Width, p.Height, p.Fit, p.Quality, p.Blur)
+	if p.Watermark != "" {
+		key += "_w" + p.Watermark
+	}
+	return key + "." + p.Format
+}
+
+func parseRenderParams(c *gin.Context, defaultFormat string) (renderParams, error) {
+	p := renderParams{
+		Fit:       strings.ToLower(c.DefaultQuery("fit", "contain")),
+		Format:    strings.ToLower(c.DefaultQuery("fmt", defaultFormat)),
+		Quality:   80,
+		Watermark: c.Query("watermark"),
+	}
+
+	var err error
+	if v := c.Query("w"); v != "" {
+		if p.Width, err = strconv.Atoi(v); err != nil || p.Width < 1 || p.Width > renderMaxDimension {
+			return p, fmt.Errorf("w must be between 1 and %d", renderMaxDimension)
+		}
+	}
+	if v := c.Query("h"); v != "" {
+		if p.Height, err = strconv.Atoi(v); err != nil || p.Height < 1 || p.Height > renderMaxDimension {
+			return p, fmt.Errorf("h must be between 1 and %d", renderMaxDimension)
+		}
+	}
+	if v := c.Query("q"); v != "" {
+		if p.Quality, err = strconv.Atoi(v); err != nil || p.Quality < 1 || p.Quality > 100 {
+			return p, fmt.Errorf("q must be between 1 and 100")
+		}
+		p.Quality = snapQuality(p.Quality)
+	}
+	if v := c.Query("blur"); v != "" {
+		if p.Blur, err = strconv.ParseFloat(v, 64); err != nil || p.Blur < 0 || p.Blur > renderMaxBlur {
+			return p, fmt.Errorf("blur must be between 0 and %d", renderMaxBlur)
+		}
+		p.Blur = math.Round(p.Blur/renderBlurStep) * renderBlurStep
+	}
+
+	switch p.Fit {
+	case "cover", "contain", "fill":
+	default:
+		return p, fmt.Errorf("fit must be one of cover, contain, fill")
+	}
+	switch p.Format {
+	case "jpg":
+		p.Format = "jpeg"
+	case "jpeg", "png", "webp":
+	default:
+		return p, fmt.Errorf("fmt must be one of jpeg, png, webp")
+	}
+	if (p.Fit == "cover" || p.Fit == "fill") && (p.Width == 0 || p.Height == 0) {
+		return p, fmt.Errorf("fit=%s requires both w and h", p.Fit)
+	}
+	return p, nil
+}
+
+// checkRenderSizes rejects widths and heights that are neither configured
+// render sizes nor the image's own.
+func (h *EditHandler) checkRenderSizes(p renderParams, width, height int) error {
+	if p.Width > 0 && p.Width != width && !slices.Contains(h.settings.RenderSizes, p.Width) {
+		return fmt.Errorf("w must be the image width or one of %s", joinSizes(h.settings.RenderSizes))
+	}
+	if p.Height > 0 && p.Height != height && !slices.Contains(h.settings.RenderSizes, p.Height) {
+		return fmt.Errorf("h must be the image height or one of %s", joinSizes(h.settings.RenderSizes))
+	}
+	return nil
+}
+
+func joinSizes(sizes []int) string {
+	s := make([]string, len(sizes))
+	for i, n := range sizes {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ", ")
+}
+
+// snapQuality rounds q to a multiple of renderQualityStep.
+func snapQuality(q int) int {
+	return max(renderQualityStep, int(math.Round(float64(q)/renderQualityStep))*renderQualityStep)
+}
+
+// clamp scales the requested box down so that it never exceeds the original
+// size, keeping its aspect ratio.
+func (p *renderParams) clamp(width, height int) {
+	scale := 1.0
+	if p.Width > width {
+		scale = float64(width) / float64(p.Width)
+	}
+	if p.Height > height {
+		if s := float64(height) / float64(p.Height); s < scale {
+			scale = s
+		}
+	}
+	if scale < 1 {
+		if p.Width > 0 {
+			p.Width = int(math.Max(1, math.Round(float64(p.Width)*scale)))
+		}
+		if p.Height > 0 {
+			p.Height = int(math.Max(1, math.Round(float64(p.Height)*scale)))
+		}
+	}
+}
+
+// Render produces a resized and re-encoded rendition of an image, e.g.
+// /api/images/:id/render?w=800&h=600&fit=cover&fmt=webp&q=75&blur=2.
+// ?watermark= applies a saved watermark after resizing. w and h must be
+// configured render sizes or the image's own width and height.
+//
+// With ?v= set to the current image version, as in the URLs of Srcset, the
+// rendition is cached for good; other URLs are revalidated by ETag, so an
+// edit is never hidden behind a stale copy.
+func (h *EditHandler) Render(c *gin.Context) {
+	id := c.Param("id")
+
+	imageRecord, err := h.repo.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	defaultFormat := imageRecord.Format
+	if defaultFormat != "png" && defaultFormat != "webp" {
+		defaultFormat = "jpeg"
+	}
+	params, err := parseRenderParams(c, defaultFormat)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.checkRenderSizes(params, imageRecord.Width, imageRecord.Height); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	params.clamp(imageRecord.Width, imageRecord.Height)
+
+	var mark *pipeline.Watermark
+	if params.Watermark != "" {
+		mark, err = savedWatermark(h.watermarks, h.logos, params.Watermark)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Watermark not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid watermark: " + err.Error()})
+			return
+		}
+	}
+
+	key := params.key(imageRecord.ID, imageRecord.Version)
+	c.Header("Content-Type", utils.ContentType(params.Format))
+	if c.Query("v") == imageRecord.Version {
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		c.Header("Cache-Control", "no-cache")
+	}
+	c.Header("ETag", `"`+key+`"`)
+
+	if f, ok := h.renders.Get(key); ok {
+		serveCached(c, f)
+		return
+	}
+
+	src, err := imaging.Open(imageRecord.Path, imaging.AutoOrientation(true))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open image"})
+		return
+	}
+
+	data, err := h.rendition(params.resize(src), params, mark, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode image"})
+		return
+	}
+	c.Data(http.StatusOK, utils.ContentType(params.Format), data)
+}
+
+// resize scales src into the box of p.
+func (p renderParams) resize(src image.Image) image.Image {
+	switch {
+	case p.Width == 0 && p.Height == 0:
+		return src
+	case p.Fit == "cover":
+		return imaging.Fill(src, p.Width, p.Height, imaging.Center, imaging.Lanczos)
+	case p.Fit == "fill", p.Width == 0 || p.Height == 0:
+		return imaging.Resize(src, p.Width, p.Height, imaging.Lanczos)
+	default:
+		return imaging.Fit(src, p.Width, p.Height, imaging.Lanczos)
+	}
+}
+
+// rendition blurs, watermarks and encodes an image already resized to
+// params and stores the result in the render cache under key. A result that
+// could not be cached is still returned.
+func (h *EditHandler) rendition(img image.Image, params renderParams, mark *pipeline.Watermark, key string) ([]byte, error) {
+	finalImg := img
+	if params.Blur > 0 {
+		finalImg = h.processImage(finalImg, EditRequest{Blur: params.Blur})
+	}
+	if mark != nil {
+		finalImg = mark.Apply(imaging.Clone(finalImg))
+	}
+
+	var buf bytes.Buffer
+	if err := utils.EncodeImage(&buf, finalImg, params.Format, params.Quality); err != nil {
+		return nil, err
+	}
+	if err := h.renders.Put(key, buf.Bytes()); err != nil {
+		log.Printf("Failed to cache rendition %s: %v", key, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"goga/internal/ai"
+	"goga/internal/models"
+	"goga/internal/pipeline"
+	"goga/internal/repository"
+	"goga/pkg/utils"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// segmentMaxDimension bounds the image handed to the segmenter; the mask
+	// is scaled back up to the image size.
+	segmentMaxDimension = 1024
+	segmentTimeout      = 2 * time.Minute
+	maxSubjectLength    = 200
+	maskCacheSize       = 128 << 20 // 128MB of decoded masks
+)
+
+type SegmentHandler struct {
+	repo      *repository.ImageRepository
+	images    *ImageHandler
+	masks     *repository.MaskRepository
+	segmenter ai.Segmenter
+	uploadDir string
+	decoded   *utils.MemoryCache // masks used by local adjustments
+}
+
+func NewSegmentHandler(repo *repository.ImageRepository, images *ImageHandler, masks *repository.MaskRepository,
+	segmenter ai.Segmenter, uploadDir string) *SegmentHandler {
+	return &SegmentHandler{
+		repo:      repo,
+		images:    images,
+		masks:     masks,
+		segmenter: segmenter,
+		uploadDir: uploadDir,
+		decoded:   utils.NewMemoryCache(maskCacheSize),
+	}
+}
+
+type removeBackgroundRequest struct {
+	Subject string `json:"subject"`
+	MaskID  string `json:"mask_id"` // reuse a stored mask instead of segmenting
+}
+
+func (h *SegmentHandler) GetMasks(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := h.repo.GetByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	masks, err := h.masks.ListByImage(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, masks)
+}
+
+// CreateMask segments the image and stores the mask so that it can be used
+// as a selection later.
+func (h *SegmentHandler) CreateMask(c *gin.Context) {
+	var req models.SegmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	imageRecord, err := h.repo.GetByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	src, ok := h.open(c, imageRecord)
+	if !ok {
+		return
+	}
+	mask, _, ok := h.segment(c, imageRecord, src, req.Subject)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, mask)
+}
+
+// ServeMask sends a mask as a grayscale PNG.
+func (h *SegmentHandler) ServeMask(c *gin.Context) {
+	mask, ok := h.mask(c, c.Param("id"), c.Param("mask"))
+	if !ok {
+		return
+	}
+	c.Header("Cache-Control", "private, max-age=31536000, immutable")
+	c.File(h.maskPath(mask.ID))
+}
+
+func (h *SegmentHandler) DeleteMask(c *gin.Context) {
+	mask, ok := h.mask(c, c.Param("id"), c.Param("mask"))
+	if !ok {
+		return
+	}
+	if err := h.masks.Delete(mask.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	os.Remove(h.maskPath(mask.ID))
+	c.JSON(http.StatusOK, gin.H{"message": "Mask deleted"})
+}
+
+// DeleteForImage is registered as a delete hook.
+func (h *SegmentHandler) DeleteForImage(imageID string) {
+	masks, err := h.masks.ListByImage(imageID)
+	if err != nil {
+		log.Printf("Failed to list masks for %s: %v", imageID, err)
+		return
+	}
+	for _, mask := range masks {
+		if err := h.masks.Delete(mask.ID); err != nil {
+			log.Printf("Failed to delete mask %s: %v", mask.ID, err)
+			continue
+		}
+		os.Remove(h.maskPath(mask.ID))
+	}
+}
+
+// OpenMask loads a stored mask of an image for local adjustments. It
+// implements pipeline.MaskStore.
+func (h *SegmentHandler) OpenMask(id, imageID, version string) (*image.Gray, error) {
+	mask, err := h.masks.GetByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && mask.ImageID != imageID) {
+		return nil, pipeline.ErrMaskNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if mask.ImageVersion != version {
+		return nil, pipeline.ErrMaskStale
+	}
+	if v, ok := h.decoded.Get(mask.ID); ok {
+		return v.(*image.Gray), nil
+	}
+	alpha, err := h.loadMask(mask)
+	if err != nil {
+		return nil, err
+	}
+	h.decoded.Put(mask.ID, alpha, int64(len(alpha.Pix)))
+	return alpha, nil
+}
+
+// RemoveBackground saves a copy of the image as a PNG whose background is
+// transparent. The mask is segmented for the request, or a stored mask of
+// the image is reused with mask_id. The original is left unchanged. The
+// copy keeps the user metadata and location of the original and goes
+// through the same upload hooks as an uploaded image.
+func (h *SegmentHandler) RemoveBackground(c *gin.Context) {
+	var req removeBackgroundRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	imageRecord, err := h.repo.GetByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	src, ok := h.open(c, imageRecord)
+	if !ok {
+		return
+	}
+
+	var mask *models.Mask
+	var alpha *image.Gray
+	if req.MaskID != "" {
+		if mask, ok = h.mask(c, imageRecord.ID, req.MaskID); !ok {
+			return
+		}
+		if mask.ImageVersion != imageRecord.Version {
+			c.JSON(http.StatusConflict, gin.H{"error": "Mask was made for an earlier version of the image"})
+			return
+		}
+		if alpha, err = h.loadMask(mask); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read mask"})
+			return
+		}
+	} else if mask, alpha, ok = h.segment(c, imageRecord, src, req.Subject); !ok {
+		return
+	}
+
+	// Multiply the alpha of the image by the mask
+	cutout := imaging.Clone(src)
+	for y := 0; y < cutout.Rect.Dy(); y++ {
+		row := cutout.Pix[y*cutout.Stride : y*cutout.Stride+cutout.Rect.Dx()*4]
+		for x := 0; x < cutout.Rect.Dx(); x++ {
+			row[x*4+3] = uint8(uint16(row[x*4+3]) * uint16(alpha.Pix[y*alpha.Stride+x]) / 255)
+		}
+	}
+
+	id := uuid.New().String()
+	filename := id + ".png"
+	filePath := filepath.Join(h.uploadDir, filename)
+	if err := imaging.Save(cutout, filePath); err != nil {
+		os.Remove(filePath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+		return
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+		return
+	}
+
+	base := strings.TrimSuffix(imageRecord.OriginalName, filepath.Ext(imageRecord.OriginalName))
+	now := time.Now()
+	derivative := &models.Image{
+		ID:           id,
+		Filename:     filename,
+		OriginalName: base + "-cutout.png",
+		Path:         filePath,
+		Size:         info.Size(),
+		Width:        cutout.Rect.Dx(),
+		Height:       cutout.Rect.Dy(),
+		Format:       "png",
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		Title:        imageRecord.Title,
+		UserCaption:  imageRecord.UserCaption,
+		Copyright:    imageRecord.Copyright,
+		Keywords:     imageRecord.Keywords,
+	}
+	if err := h.repo.Create(derivative); err != nil {
+		os.Remove(filePath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image record"})
+		return
+	}
+	if err := h.repo.UpdateMetadata(derivative); err != nil {
+		log.Printf("Failed to copy metadata to %s: %v", derivative.ID, err)
+	}
+	// The PNG has no EXIF for the geo hook to find the position in
+	if imageRecord.Latitude != nil {
+		err := h.repo.SetLocation(derivative.ID, imageRecord.Latitude, imageRecord.Longitude,
+			imageRecord.Country, imageRecord.City)
+		if err != nil {
+			log.Printf("Failed to copy location to %s: %v", derivative.ID, err)
+		} else {
+			derivative.Latitude, derivative.Longitude = imageRecord.Latitude, imageRecord.Longitude
+			derivative.Country, derivative.City = imageRecord.Country, imageRecord.City
+		}
+	}
+	h.images.created(derivative)
+
+	c.JSON(http.StatusCreated, gin.H{"image": derivative, "mask": mask})
+}
+
+// open decodes the image as displayed, responding with an error if it
+// cannot be read.
+func (h *SegmentHandler) open(c *gin.Context, imageRecord *models.Image) (*image.NRGBA, bool) {
+	src, err := imaging.Open(imageRecord.Path, imaging.AutoOrientation(true))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open image"})
+		return nil, false
+	}
+	return imaging.Clone(src), true
+}
+
+// segment runs the segmenter on a downscaled copy of src and stores the
+// mask at full size.
+func (h *SegmentHandler) segment(c *gin.Context, imageRecord *models.Image, src *image.NRGBA,
+	subject string) (*models.Mask, *image.Gray, bool) {
+	subject = strings.TrimSpace(subject)
+	if len(subject) > maxSubjectLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("subject must be at most %d characters", maxSubjectLength)})
+		return nil, nil, false
+	}
+	if !h.segmenter.Configured() {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": ai.ErrNotConfigured.Error()})
+		return nil, nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), segmentTimeout)
+	defer cancel()
+
+	small := image.Image(src)
+	if src.Rect.Dx() > segmentMaxDimension || src.Rect.Dy() > segmentMaxDimension {
+		small = imaging.Fit(src, segmentMaxDimension, segmentMaxDimension, imaging.Lanczos)
+	}
+	alpha, err := h.segmenter.Segment(ctx, small, subject)
+	if err != nil {
+		log.Printf("Segmentation failed for %s: %v", imageRecord.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Segmentation failed: " + err.Error()})
+		return nil, nil, false
+	}
+	if alpha.Rect.Size() != src.Rect.Size() {
+		alpha = toGray(imaging.Resize(alpha, src.Rect.Dx(), src.Rect.Dy(), imaging.Linear))
+	}
+
+	mask := &models.Mask{
+		ID:           uuid.New().String(),
+		ImageID:      imageRecord.ID,
+		ImageVersion: imageRecord.Version,
+		Subject:      subject,
+		Provider:     h.segmenter.Name(),
+		Width:        alpha.Rect.Dx(),
+		Height:       alpha.Rect.Dy(),
+		CreatedAt:    time.Now(),
+	}
+	if err := h.saveMask(mask, alpha); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save mask"})
+		return nil, nil, false
+	}
+	return mask, alpha, true
+}
+
+// mask loads a stored mask of the image, responding with 404 if there is
+// none.
+func (h *SegmentHandler) mask(c *gin.Context, imageID, maskID string) (*models.Mask, bool) {
+	mask, err := h.masks.GetByID(maskID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && mask.ImageID != imageID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mask not found"})
+		return nil, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return mask, true
+}
+
+func (h *SegmentHandler) maskPath(id string) string {
+	return filepath.Join(h.uploadDir, "masks", id+".png")
+}
+
+func (h *SegmentHandler) saveMask(mask *models.Mask, alpha *image.Gray) error {
+	path := h.maskPath(mask.ID)
+	if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, alpha); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	if err := h.masks.Create(mask); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+func (h *SegmentHandler) loadMask(mask *models.Mask) (*image.Gray, error) {
+	file, err := os.Open(h.maskPath(mask.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	return toGray(img), nil
+}
+
+// toGray converts img to a grayscale mask from its first channel.
+func toGray(img image.Image) *image.Gray {
+	if gray, ok := img.(*image.Gray); ok && gray.Rect.Min == (image.Point{}) {
+		return gray
+	}
+	nrgba := imaging.Clone(img)
+	out := image.NewGray(image.Rect(0, 0, nrgba.Rect.Dx(), nrgba.Rect.Dy()))
+	for i := range out.Pix {
+		out.Pix[i] = nrgba.Pix[i*4]
+	}
+	return out
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"goga/internal/ai"
+	"goga/internal/config"
+	"goga/internal/models"
+	"goga/internal/repository"
+	"image"
+	"image/color"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// subjectOnPlainBackground draws a dark red disc on a white background.
+func subjectOnPlainBackground(w, h, radius int) *image.NRGBA {
+	img := imaging.New(w, h, color.NRGBA{245, 245, 245, 255})
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x-w/2, y-h/2
+			if dx*dx+dy*dy <= radius*radius {
+				img.SetNRGBA(x, y, color.NRGBA{150, 20, 30, 255})
+			}
+		}
+	}
+	return img
+}
+
+func newTestSegmentHandler(t *testing.T) (*SegmentHandler, *repository.ImageRepository, string) {
+	t.Helper()
+	db := openTestDB(t)
+	repo := repository.NewImageRepository(db)
+	if err := repo.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	masks := repository.NewMaskRepository(db)
+	if err := masks.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	uploadDir := t.TempDir()
+	images, err := NewImageHandler(repo, nil, nil, uploadDir, nil, config.Default().Images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSegmentHandler(repo, images, masks, ai.NewLocalSegmenter(), uploadDir), repo, uploadDir
+}
+
+type removeBackgroundResponse struct {
+	Image models.Image `json:"image"`
+	Mask  models.Mask  `json:"mask"`
+}
+
+func removeBackground(t *testing.T, h *SegmentHandler, imageID, body string, wantCode int) removeBackgroundResponse {
+	t.Helper()
+	w := serve(h.RemoveBackground, http.MethodPost, "/images/:id/edit/remove-background",
+		"/images/"+imageID+"/edit/remove-background", body)
+	if w.Code != wantCode {
+		t.Fatalf("remove-background returned %d, want %d: %s", w.Code, wantCode, w.Body)
+	}
+	var resp removeBackgroundResponse
+	if wantCode == http.StatusCreated {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp
+}
+
+func TestRemoveBackgroundLocal(t *testing.T) {
+	h, repo, uploadDir := newTestSegmentHandler(t)
+	var uploaded []string
+	h.images.OnUpload(func(image *models.Image) { uploaded = append(uploaded, image.ID) })
+	imageRecord := createTestImage(t, repo, uploadDir, subjectOnPlainBackground(240, 180, 50))
+	imageRecord.Title, imageRecord.UserCaption, imageRecord.Keywords = "Disc", "A red disc", []string{"red"}
+	if err := repo.UpdateMetadata(imageRecord); err != nil {
+		t.Fatal(err)
+	}
+	latitude, longitude := 46.2, 6.15
+	if err := repo.SetLocation(imageRecord.ID, &latitude, &longitude, "Switzerland", "Geneva"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := removeBackground(t, h, imageRecord.ID, "", http.StatusCreated)
+	if resp.Mask.Provider != "local" || resp.Mask.ImageVersion != imageRecord.Version {
+		t.Errorf("mask is %+v, want a local mask of version %s", resp.Mask, imageRecord.Version)
+	}
+	if len(uploaded) != 1 || uploaded[0] != resp.Image.ID {
+		t.Errorf("upload hooks ran for %v, want only the cutout %s", uploaded, resp.Image.ID)
+	}
+	stored, err := repo.GetByID(resp.Image.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "Disc" || stored.UserCaption != "A red disc" || !slices.Equal(stored.Keywords, []string{"red"}) {
+		t.Errorf("cutout metadata is %q, %q, %v, want that of the original", stored.Title, stored.UserCaption, stored.Keywords)
+	}
+	if stored.City != "Geneva" || stored.Latitude == nil || *stored.Latitude != latitude {
+		t.Errorf("cutout is located in %q at %v, want Geneva", stored.City, stored.Latitude)
+	}
+
+	cutout, err := imaging.Open(resp.Image.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cutout.Bounds().Size(); got != (image.Point{240, 180}) {
+		t.Fatalf("cutout is %v, want 240x180", got)
+	}
+	alpha := func(x, y int) uint8 {
+		return color.NRGBAModel.Convert(cutout.At(x, y)).(color.NRGBA).A
+	}
+	for _, p := range []image.Point{{0, 0}, {239, 0}, {0, 179}, {239, 179}, {20, 90}, {220, 90}} {
+		if a := alpha(p.X, p.Y); a != 0 {
+			t.Errorf("background at %v has alpha %d, want 0", p, a)
+		}
+	}
+	for _, p := range []image.Point{{120, 90}, {100, 80}, {140, 110}} {
+		if a := alpha(p.X, p.Y); a != 255 {
+			t.Errorf("subject at %v has alpha %d, want 255", p, a)
+		}
+	}
+
+	// The stored mask is reused as long as the image has not changed
+	body := `{"mask_id": "` + resp.Mask.ID + `"}`
+	again := removeBackground(t, h, imageRecord.ID, body, http.StatusCreated)
+	if again.Mask.ID != resp.Mask.ID {
+		t.Errorf("mask %s was not reused, got %s", resp.Mask.ID, again.Mask.ID)
+	}
+
+	// but not after an edit, even one that keeps the size
+	imageRecord.UpdatedAt = imageRecord.UpdatedAt.Add(time.Second)
+	if err := repo.Update(imageRecord); err != nil {
+		t.Fatal(err)
+	}
+	removeBackground(t, h, imageRecord.ID, body, http.StatusConflict)
+}
+
+func TestLocalAdjustmentStoredMask(t *testing.T) {
+	segments, repo, uploadDir := newTestSegmentHandler(t)
+	edits, err := NewEditHandler(repo, nil, nil, segments, nil, uploadDir, nil, config.Default().Images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageRecord := createTestImage(t, repo, uploadDir, subjectOnPlainBackground(240, 180, 50))
+	other := createTestImage(t, repo, uploadDir, subjectOnPlainBackground(240, 180, 30))
+
+	createMask := func(imageID string) models.Mask {
+		t.Helper()
+		w := serve(segments.CreateMask, http.MethodPost, "/images/:id/masks", "/images/"+imageID+"/masks", "")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create mask returned %d: %s", w.Code, w.Body)
+		}
+		var mask models.Mask
+		if err := json.Unmarshal(w.Body.Bytes(), &mask); err != nil {
+			t.Fatal(err)
+		}
+		return mask
+	}
+	own, foreign := createMask(imageRecord.ID), createMask(other.ID)
+	gone := createMask(imageRecord.ID)
+	if w := serve(segments.DeleteMask, http.MethodDelete, "/images/:id/masks/:mask",
+		"/images/"+imageRecord.ID+"/masks/"+gone.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("delete mask returned %d: %s", w.Code, w.Body)
+	}
+
+	preview := func(maskID string) int {
+		body := `{"operations": [{"op": "local", "params": {
+			"mask": {"type": "stored", "id": "` + maskID + `"},
+			"operations": [{"op": "brightness", "params": {"value": 50}}]}}]}`
+		return serve(edits.PreviewEdit, http.MethodPost, "/images/:id/edit/preview",
+			"/images/"+imageRecord.ID+"/edit/preview", body).Code
+	}
+	for _, tt := range []struct {
+		name   string
+		maskID string
+		want   int
+	}{
+		{"own mask", own.ID, http.StatusOK},
+		{"mask of another image", foreign.ID, http.StatusBadRequest},
+		{"deleted mask", gone.ID, http.StatusBadRequest},
+		{"unknown mask", "missing", http.StatusBadRequest},
+	} {
+		if got := preview(tt.maskID); got != tt.want {
+			t.Errorf("%s: preview returned %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// After the image changes its masks no longer line up with it
+	imageRecord.UpdatedAt = imageRecord.UpdatedAt.Add(time.Second)
+	if err := repo.Update(imageRecord); err != nil {
+		t.Fatal(err)
+	}
+	if got := preview(own.ID); got != http.StatusConflict {
+		t.Errorf("stale mask: preview returned %d, want 409", got)
+	}
+}
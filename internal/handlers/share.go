@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"goga/internal/models"
+	"goga/internal/repository"
+	"goga/pkg/utils"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// shareViewTTL is how long a counted gallery view lets the visitor
+	// load the images of a link with a view limit.
+	shareViewTTL = time.Hour
+	// shareFetchesPerImage covers the thumbnail, the full image and the
+	// download of every image, plus a reload.
+	shareFetchesPerImage = 4
+
+	// Failed password attempts allowed per visitor and per link within
+	// sharePasswordWindow; the latter bounds guessing from many addresses.
+	sharePasswordVisitorAttempts = 5
+	sharePasswordLinkAttempts    = 50
+	sharePasswordWindow          = 15 * time.Minute
+)
+
+type ShareHandler struct {
+	repo       *repository.ShareRepository
+	imageRepo  *repository.ImageRepository
+	watermarks *repository.WatermarkRepository
+	images     *ImageHandler
+
+	visitorAttempts *utils.AttemptLimiter
+	linkAttempts    *utils.AttemptLimiter
+}
+
+func NewShareHandler(repo *repository.ShareRepository, imageRepo *repository.ImageRepository,
+	watermarks *repository.WatermarkRepository, images *ImageHandler) *ShareHandler {
+	return &ShareHandler{
+		repo:       repo,
+		imageRepo:  imageRepo,
+		watermarks: watermarks,
+		images:     images,
+
+		visitorAttempts: utils.NewAttemptLimiter(sharePasswordVisitorAttempts, sharePasswordWindow),
+		linkAttempts:    utils.NewAttemptLimiter(sharePasswordLinkAttempts, sharePasswordWindow),
+	}
+}
+
+// ShareImage creates a link for a single image.
+func (h *ShareHandler) ShareImage(c *gin.Context) {
+	var req models.ShareCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ImageIDs = []string{c.Param("id")}
+	h.create(c, req)
+}
+
+// CreateShare creates a gallery link for a set of images.
+func (h *ShareHandler) CreateShare(c *gin.Context) {
+	var req models.ShareCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.ImageIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image_ids is required"})
+		return
+	}
+	h.create(c, req)
+}
+
+func (h *ShareHandler) create(c *gin.Context, req models.ShareCreateRequest) {
+	if req.ExpiresIn < 0 || req.MaxViews < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in and max_views must not be negative"})
+		return
+	}
+
+	seen := make(map[string]bool)
+	var imageIDs []string
+	for _, id := range req.ImageIDs {
+		if seen[id] {
+			continue
+		}
+		if _, err := h.imageRepo.GetByID(id); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found: " + id})
+			return
+		}
+		seen[id] = true
+		imageIDs = append(imageIDs, id)
+	}
+
+	policy, err := utils.ParseMetadataPolicy(req.Metadata, utils.MetadataStripGPS)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The watermark is copied so that later changes to the saved ones do
+	// not affect existing links
+	var mark json.RawMessage
+	if req.WatermarkID != "" {
+		saved, err := h.watermarks.GetByID(req.WatermarkID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Watermark not found"})
+			return
+		}
+		if _, err := decodeWatermark(saved.Spec, h.images.logos); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid watermark: " + err.Error()})
+			return
+		}
+		mark = saved.Spec
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	share := &models.Share{
+		ID:            uuid.New().String(),
+		Token:         token,
+		Title:         req.Title,
+		ImageIDs:      imageIDs,
+		MaxViews:      req.MaxViews,
+		AllowDownload: req.AllowDownload,
+		Metadata:      string(policy),
+		Watermark:     mark,
+		CreatedAt:     time.Now(),
+	}
+	if req.ExpiresIn > 0 {
+		expires := share.CreatedAt.Add(time.Duration(req.ExpiresIn) * time.Second)
+		share.ExpiresAt = &expires
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		share.PasswordHash = string(hash)
+	}
+
+	if err := h.repo.Create(share); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save share"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, shareResponse(share))
+}
+
+func (h *ShareHandler) ListShares(c *gin.Context) {
+	shares, err := h.repo.ListActive(c.Query("image_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := make([]gin.H, 0, len(shares))
+	for i := range shares {
+		response = append(response, shareResponse(&shares[i]))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *ShareHandler) RevokeShare(c *gin.Context) {
+	if err := h.repo.Revoke(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Share revoked"})
+}
+
+// Gallery renders the public read-only page for a share token.
+func (h *ShareHandler) Gallery(c *gin.Context) {
+	share, ok := h.lookup(c)
+	if !ok {
+		return
+	}
+
+	if share.HasPassword() && !h.unlocked(c, share) {
+		if c.Request.Method != http.MethodPost {
+			c.HTML(http.StatusUnauthorized, "share.html", gin.H{
+				"title":         "Protected gallery - Goga",
+				"needsPassword": true,
+			})
+			return
+		}
+
+		now := time.Now()
+		visitor := share.ID + "|" + c.ClientIP()
+		visitorOK, visitorWait := h.visitorAttempts.Allow(visitor, now)
+		linkOK, linkWait := h.linkAttempts.Allow(share.ID, now)
+		if !visitorOK || !linkOK {
+			c.Header("Retry-After", strconv.Itoa(int(max(visitorWait, linkWait).Seconds())+1))
+			c.HTML(http.StatusTooManyRequests, "share.html", gin.H{
+				"title":         "Protected gallery - Goga",
+				"needsPassword": true,
+				"error":         "Too many attempts, try again later",
+			})
+			return
+		}
+
+		password := c.PostForm("password")
+		if bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) != nil {
+			h.visitorAttempts.Fail(visitor, now)
+			h.linkAttempts.Fail(share.ID, now)
+			c.HTML(http.StatusUnauthorized, "share.html", gin.H{
+				"title":         "Protected gallery - Goga",
+				"needsPassword": true,
+				"error":         "Incorrect password",
+			})
+			return
+		}
+		h.visitorAttempts.Reset(visitor)
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(shareCookieName(share), shareCookieValue(share), 0, "/s/"+share.Token, "", false, true)
+		c.Redirect(http.StatusSeeOther, "/s/"+share.Token)
+		return
+	}
+
+	counted, err := h.startView(c, share)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "share.html", gin.H{"error": "Something went wrong"})
+		return
+	}
+	if !counted {
+		c.HTML(http.StatusGone, "share.html", gin.H{"error": "This link has reached its view limit"})
+		return
+	}
+
+	var images []*models.Image
+	for _, id := range share.ImageIDs {
+		if image, err := h.imageRepo.GetByID(id); err == nil {
+			images = append(images, image)
+		}
+	}
+
+	title := share.Title
+	if title == "" {
+		title = "Shared photos"
+	}
+	c.HTML(http.StatusOK, "share.html", gin.H{
+		"title":         title + " - Goga",
+		"heading":       title,
+		"token":         share.Token,
+		"images":        images,
+		"allowDownload": share.AllowDownload,
+		"expiresAt":     share.ExpiresAt,
+	})
+}
+
+// ServeFile serves an image that belongs to a share, honouring its rules.
+func (h *ShareHandler) ServeFile(c *gin.Context) {
+	share, ok := h.lookup(c)
+	if !ok {
+		return
+	}
+	if share.HasPassword() && !h.unlocked(c, share) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Password required"})
+		return
+	}
+
+	id := c.Param("id")
+	if !share.Contains(id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	image, err := h.imageRepo.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	if share.MaxViews > 0 && !h.fetch(c, share) {
+		return
+	}
+
+	// Visitors may ask for less metadata than the link allows, never more
+	policy, ok := metadataPolicy(c, utils.MetadataKeep)
+	if !ok {
+		return
+	}
+	policy = policy.Stricter(utils.MetadataPolicy(share.Metadata))
+
+	download := c.Query("download") != ""
+	if download && !share.AllowDownload {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Downloads are disabled for this link"})
+		return
+	}
+
+	// Watermarked links never serve the original, thumbnails included
+	if len(share.Watermark) > 0 {
+		mark, err := decodeWatermark(share.Watermark, h.images.logos)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply watermark"})
+			return
+		}
+		size := 0
+		if n, err := strconv.Atoi(c.Query("thumb")); err == nil && n > 0 && n <= h.images.settings.MaxThumbSize {
+			size = n
+		}
+		if !download {
+			c.Header("Content-Disposition", "inline")
+		}
+		h.images.sendWatermarked(c, image, mark, size, download, "private, no-cache")
+		return
+	}
+
+	if download {
+		h.images.sendDownload(c, image, policy)
+		return
+	}
+
+	c.Header("Content-Disposition", "inline")
+	h.images.serveFile(c, image, "private, no-cache", policy)
+}
+
+// lookup resolves the :token parameter and writes an error response when the
+// share is missing, revoked or expired.
+func (h *ShareHandler) lookup(c *gin.Context) (*models.Share, bool) {
+	share, err := h.repo.GetByToken(c.Param("token"))
+	if err != nil {
+		c.HTML(http.StatusNotFound, "share.html", gin.H{"error": "This link does not exist"})
+		return nil, false
+	}
+	if !share.Active(time.Now()) {
+		c.HTML(http.StatusGone, "share.html", gin.H{"error": "This link has expired"})
+		return nil, false
+	}
+	return share, true
+}
+
+func (h *ShareHandler) unlocked(c *gin.Context, share *models.Share) bool {
+	value, err := c.Cookie(shareCookieName(share))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(value), []byte(shareCookieValue(share))) == 1
+}
+
+func shareCookieName(share *models.Share) string {
+	return "goga_share_" + share.ID
+}
+
+// shareCookieValue is derived from the password hash so that changing or
+// revoking the password invalidates existing sessions.
+func shareCookieValue(share *models.Share) string {
+	sum := sha256.Sum256([]byte(share.Token + ":" + share.PasswordHash))
+	return hex.EncodeToString(sum[:])
+}
+
+// startView counts a gallery view. On links with a view limit the view gets
+// a random id, kept in a cookie, that the visitor's file fetches are counted
+// against, so the files cannot be fetched without using up views.
+func (h *ShareHandler) startView(c *gin.Context, share *models.Share) (bool, error) {
+	if share.MaxViews == 0 {
+		return h.repo.RecordView(share.ID)
+	}
+	viewID, err := newShareToken()
+	if err != nil {
+		return false, err
+	}
+	counted, err := h.repo.StartView(share.ID, viewID, time.Now().Add(shareViewTTL),
+		shareFetchesPerImage*len(share.ImageIDs))
+	if err != nil || !counted {
+		return false, err
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(shareViewCookieName(share), viewID, int(shareViewTTL.Seconds()), "/s/"+share.Token, "", false, true)
+	return true, nil
+}
+
+// fetch counts a file fetch against the visitor's view and writes an error
+// response if there is no view or it is used up.
+func (h *ShareHandler) fetch(c *gin.Context, share *models.Share) bool {
+	counted := false
+	if viewID, err := c.Cookie(shareViewCookieName(share)); err == nil {
+		if counted, err = h.repo.RecordFetch(share.ID, viewID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+	switch {
+	case counted:
+		return true
+	case share.ViewsExhausted():
+		c.JSON(http.StatusGone, gin.H{"error": "This link has reached its view limit"})
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Open the link to view its images"})
+	}
+	return false
+}
+
+func shareViewCookieName(share *models.Share) string {
+	return "goga_share_view_" + share.ID
+}
+
+func newShareToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func shareResponse(share *models.Share) gin.H {
+	return gin.H{
+		"id":             share.ID,
+		"token":          share.Token,
+		"url":            "/s/" + share.Token,
+		"title":          share.Title,
+		"image_ids":      share.ImageIDs,
+		"has_password":   share.HasPassword(),
+		"expires_at":     share.ExpiresAt,
+		"max_views":      share.MaxViews,
+		"views":          share.Views,
+		"allow_download": share.AllowDownload,
+		"metadata":       share.Metadata,
+		"watermark":      share.Watermark,
+		"created_at":     share.CreatedAt,
+	}
+}
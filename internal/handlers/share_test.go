@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"goga/internal/config"
+	"goga/internal/models"
+	"goga/internal/repository"
+	"html/template"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testSharePassword = "correct horse"
+
+type shareTest struct {
+	t      *testing.T
+	repo   *repository.ShareRepository
+	router *gin.Engine
+	image  *models.Image
+}
+
+func newShareTest(t *testing.T) *shareTest {
+	t.Helper()
+	db := openTestDB(t)
+	imageRepo := repository.NewImageRepository(db)
+	if err := imageRepo.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewShareRepository(db)
+	if err := repo.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	uploadDir := t.TempDir()
+	images, err := NewImageHandler(imageRepo, nil, nil, uploadDir, nil, config.Default().Images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewShareHandler(repo, imageRepo, nil, images)
+
+	router := gin.New()
+	router.SetHTMLTemplate(template.Must(template.New("share.html").Parse(`{{.error}}`)))
+	router.GET("/s/:token", h.Gallery)
+	router.POST("/s/:token", h.Gallery)
+	router.GET("/s/:token/images/:id", h.ServeFile)
+
+	image := createTestImage(t, imageRepo, uploadDir, imaging.New(64, 48, color.NRGBA{200, 120, 60, 255}))
+	return &shareTest{t: t, repo: repo, router: router, image: image}
+}
+
+// share stores a link to the test image after change has adjusted it.
+func (s *shareTest) share(change func(*models.Share)) *models.Share {
+	s.t.Helper()
+	token, err := newShareToken()
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	share := &models.Share{
+		ID:        uuid.New().String(),
+		Token:     token,
+		ImageIDs:  []string{s.image.ID},
+		Metadata:  "strip_gps",
+		CreatedAt: time.Now(),
+	}
+	if change != nil {
+		change(share)
+	}
+	if err := s.repo.Create(share); err != nil {
+		s.t.Fatal(err)
+	}
+	return share
+}
+
+// visitor is a browser at addr that keeps the cookies it is sent.
+func (s *shareTest) visitor(addr string) *shareVisitor {
+	return &shareVisitor{router: s.router, addr: addr, cookies: make(map[string]*http.Cookie)}
+}
+
+type shareVisitor struct {
+	router  http.Handler
+	addr    string
+	cookies map[string]*http.Cookie
+}
+
+func (v *shareVisitor) get(target string) *httptest.ResponseRecorder {
+	return v.do(httptest.NewRequest(http.MethodGet, target, nil))
+}
+
+func (v *shareVisitor) enterPassword(share *models.Share, password string) *httptest.ResponseRecorder {
+	form := url.Values{"password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/s/"+share.Token, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return v.do(req)
+}
+
+func (v *shareVisitor) do(req *http.Request) *httptest.ResponseRecorder {
+	req.RemoteAddr = v.addr + ":40000"
+	for _, cookie := range v.cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	v.router.ServeHTTP(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		v.cookies[cookie.Name] = cookie
+	}
+	return w
+}
+
+func galleryURL(share *models.Share) string {
+	return "/s/" + share.Token
+}
+
+func fileURL(share *models.Share, imageID string) string {
+	return "/s/" + share.Token + "/images/" + imageID
+}
+
+func passwordHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestShareAccess(t *testing.T) {
+	s := newShareTest(t)
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	hash := passwordHash(t, testSharePassword)
+
+	for _, tc := range []struct {
+		name        string
+		change      func(*models.Share)
+		revoke      bool
+		gallery     int
+		file        int
+		otherImage  int
+		forDownload int
+	}{
+		{"open link", nil, false, http.StatusOK, http.StatusOK, http.StatusNotFound, http.StatusForbidden},
+		{"downloads allowed", func(sh *models.Share) { sh.AllowDownload = true }, false,
+			http.StatusOK, http.StatusOK, http.StatusNotFound, http.StatusOK},
+		{"expires later", func(sh *models.Share) { sh.ExpiresAt = &future }, false,
+			http.StatusOK, http.StatusOK, http.StatusNotFound, http.StatusForbidden},
+		{"expired", func(sh *models.Share) { sh.ExpiresAt = &past }, false,
+			http.StatusGone, http.StatusGone, http.StatusGone, http.StatusGone},
+		{"revoked", nil, true, http.StatusGone, http.StatusGone, http.StatusGone, http.StatusGone},
+		{"password not entered", func(sh *models.Share) { sh.PasswordHash = hash }, false,
+			http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			share := s.share(tc.change)
+			if tc.revoke {
+				if err := s.repo.Revoke(share.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			v := s.visitor("192.0.2.1")
+			if w := v.get(galleryURL(share)); w.Code != tc.gallery {
+				t.Errorf("gallery returned %d, want %d", w.Code, tc.gallery)
+			}
+			if w := v.get(fileURL(share, s.image.ID)); w.Code != tc.file {
+				t.Errorf("file returned %d, want %d", w.Code, tc.file)
+			}
+			if w := v.get(fileURL(share, uuid.New().String())); w.Code != tc.otherImage {
+				t.Errorf("image outside the share returned %d, want %d", w.Code, tc.otherImage)
+			}
+			if w := v.get(fileURL(share, s.image.ID) + "?download=1"); w.Code != tc.forDownload {
+				t.Errorf("download returned %d, want %d", w.Code, tc.forDownload)
+			}
+		})
+	}
+
+	if w := s.visitor("192.0.2.1").get("/s/unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown token returned %d, want 404", w.Code)
+	}
+}
+
+func TestSharePassword(t *testing.T) {
+	s := newShareTest(t)
+	hash := passwordHash(t, testSharePassword)
+	share := s.share(func(sh *models.Share) { sh.PasswordHash = hash })
+
+	v := s.visitor("192.0.2.1")
+	for _, tc := range []struct {
+		password string
+		want     int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Correct Horse", http.StatusUnauthorized},
+		{testSharePassword, http.StatusSeeOther},
+	} {
+		if w := v.enterPassword(share, tc.password); w.Code != tc.want {
+			t.Errorf("password %q returned %d, want %d", tc.password, w.Code, tc.want)
+		}
+	}
+	if w := v.get(galleryURL(share)); w.Code != http.StatusOK {
+		t.Errorf("gallery after the password returned %d, want 200", w.Code)
+	}
+	if w := v.get(fileURL(share, s.image.ID)); w.Code != http.StatusOK {
+		t.Errorf("file after the password returned %d, want 200", w.Code)
+	}
+
+	// The cookie of one link does not open another with the same password
+	other := s.share(func(sh *models.Share) { sh.PasswordHash = hash })
+	for name, cookie := range v.cookies {
+		v.cookies[strings.Replace(name, share.ID, other.ID, 1)] = cookie
+	}
+	if w := v.get(galleryURL(other)); w.Code != http.StatusUnauthorized {
+		t.Errorf("gallery of another link returned %d, want 401", w.Code)
+	}
+}
+
+func TestSharePasswordAttemptsLimited(t *testing.T) {
+	s := newShareTest(t)
+	share := s.share(func(sh *models.Share) { sh.PasswordHash = passwordHash(t, testSharePassword) })
+
+	guesser := s.visitor("192.0.2.1")
+	for i := 0; i < sharePasswordVisitorAttempts; i++ {
+		if w := guesser.enterPassword(share, "guess"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d returned %d, want 401", i+1, w.Code)
+		}
+	}
+	// Once over the limit even the right password is refused
+	w := guesser.enterPassword(share, testSharePassword)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("attempt over the limit returned %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("429 without Retry-After")
+	}
+
+	// Other visitors are not locked out by one guesser
+	if w := s.visitor("192.0.2.2").enterPassword(share, testSharePassword); w.Code != http.StatusSeeOther {
+		t.Errorf("another visitor returned %d, want 303", w.Code)
+	}
+}
+
+func TestShareViewLimit(t *testing.T) {
+	s := newShareTest(t)
+	share := s.share(func(sh *models.Share) { sh.MaxViews = 2 })
+	file := fileURL(share, s.image.ID)
+
+	first := s.visitor("192.0.2.1")
+	if w := first.get(file); w.Code != http.StatusForbidden {
+		t.Errorf("file before opening the link returned %d, want 403", w.Code)
+	}
+	if w := first.get(galleryURL(share)); w.Code != http.StatusOK {
+		t.Fatalf("first view returned %d, want 200", w.Code)
+	}
+	for i := 0; i < shareFetchesPerImage; i++ {
+		if w := first.get(file); w.Code != http.StatusOK {
+			t.Fatalf("fetch %d of the first view returned %d, want 200", i+1, w.Code)
+		}
+	}
+	if w := first.get(file); w.Code != http.StatusForbidden {
+		t.Errorf("fetch beyond the view's allowance returned %d, want 403", w.Code)
+	}
+
+	second := s.visitor("192.0.2.2")
+	if w := second.get(galleryURL(share)); w.Code != http.StatusOK {
+		t.Fatalf("second view returned %d, want 200", w.Code)
+	}
+	if w := second.get(file); w.Code != http.StatusOK {
+		t.Errorf("fetch of the second view returned %d, want 200", w.Code)
+	}
+
+	third := s.visitor("192.0.2.3")
+	if w := third.get(galleryURL(share)); w.Code != http.StatusGone {
+		t.Errorf("view over the limit returned %d, want 410", w.Code)
+	}
+	if w := third.get(file); w.Code != http.StatusGone {
+		t.Errorf("file after the views ran out returned %d, want 410", w.Code)
+	}
+
+	// A made-up view id is not counted
+	forger := s.visitor("192.0.2.4")
+	forger.cookies[shareViewCookieName(share)] = &http.Cookie{Name: shareViewCookieName(share), Value: "made-up"}
+	if w := forger.get(file); w.Code != http.StatusGone {
+		t.Errorf("file with a forged view returned %d, want 410", w.Code)
+	}
+}
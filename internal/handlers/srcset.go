@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"goga/internal/models"
+	"goga/internal/pipeline"
+	"goga/pkg/utils"
+	"html"
+	"image"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+const srcsetMaxWidths = 10
+
+// srcsetWidths are generated unless ?widths= is given. Widths above the
+// image width are replaced by the image width.
+var srcsetWidths = []int{320, 640, 960, 1280, 1920, 2560}
+
+// srcsetSource is one <source> of a <picture>.
+type srcsetSource struct {
+	Type   string `json:"type"`
+	Srcset string `json:"srcset"`
+}
+
+// srcsetImage is the fallback <img> of a <picture>.
+type srcsetImage struct {
+	Src    string `json:"src"`
+	Srcset string `json:"srcset"`
+	Sizes  string `json:"sizes"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Alt    string `json:"alt"`
+}
+
+// Srcset generates renditions of an image for responsive embedding and
+// describes them as srcset attributes and a ready-to-use <picture> element.
+// ?widths= lists the widths (default those of 320 to 2560 that are render
+// sizes, never wider than the image) and ?formats= the formats in order of preference, the last one
+// being the fallback for the <img> (default webp, then png for PNG images
+// and jpeg otherwise). ?q=, ?watermark= work as for Render and ?sizes= is
+// passed through to the sizes attribute (default 100vw).
+//
+// The URLs point to Render, whose cache the renditions are stored in, and
+// include the image version so that they can be cached forever.
+func (h *EditHandler) Srcset(c *gin.Context) {
+	imageRecord, err := h.repo.GetByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	widths, err := parseSrcsetWidths(c.Query("widths"), imageRecord.Width, h.settings.RenderSizes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fallback := "jpeg"
+	if imageRecord.Format == "png" {
+		fallback = "png"
+	}
+	formats, err := parseSrcsetFormats(c.DefaultQuery("formats", "webp,"+fallback))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	quality := 80
+	if v := c.Query("q"); v != "" {
+		if quality, err = strconv.Atoi(v); err != nil || quality < 1 || quality > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q must be between 1 and 100"})
+			return
+		}
+		quality = snapQuality(quality)
+	}
+	sizes := c.DefaultQuery("sizes", "100vw")
+
+	var mark *pipeline.Watermark
+	watermarkID := c.Query("watermark")
+	if watermarkID != "" {
+		mark, err = savedWatermark(h.watermarks, h.logos, watermarkID)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Watermark not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid watermark: " + err.Error()})
+			return
+		}
+	}
+
+	// Generate the renditions that are not cached yet, decoding and
+	// resizing the image once for all formats
+	var src image.Image
+	sources := make([]srcsetSource, len(formats))
+	for i, format := range formats {
+		sources[i].Type = utils.ContentType(format)
+	}
+	for _, width := range widths {
+		var resized image.Image
+		for i, format := range formats {
+			params := renderParams{Width: width, Fit: "contain", Format: format, Quality: quality, Watermark: watermarkID}
+			if key := params.key(imageRecord.ID, imageRecord.Version); !h.renders.Has(key) {
+				if src == nil {
+					if src, err = imaging.Open(imageRecord.Path, imaging.AutoOrientation(true)); err != nil {
+						c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open image"})
+						return
+					}
+				}
+				if resized == nil {
+					resized = params.resize(src)
+				}
+				if _, err := h.rendition(resized, params, mark, key); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode image"})
+					return
+				}
+			}
+
+			if sources[i].Srcset != "" {
+				sources[i].Srcset += ", "
+			}
+			sources[i].Srcset += fmt.Sprintf("%s %dw", renderURL(imageRecord, params), width)
+		}
+	}
+
+	if imageRecord.Placeholder == "" {
+		// Images uploaded before placeholders existed
+		h.updatePlaceholders(imageRecord, src)
+	}
+
+	// The largest fallback rendition is the src for browsers without
+	// srcset support
+	last := len(formats) - 1
+	largest := renderParams{Width: widths[len(widths)-1], Fit: "contain", Format: formats[last], Quality: quality, Watermark: watermarkID}
+	img := srcsetImage{
+		Src:    renderURL(imageRecord, largest),
+		Srcset: sources[last].Srcset,
+		Sizes:  sizes,
+		Width:  imageRecord.Width,
+		Height: imageRecord.Height,
+		Alt:    imageRecord.AltText,
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":          imageRecord.ID,
+		"version":     imageRecord.Version,
+		"width":       imageRecord.Width,
+		"height":      imageRecord.Height,
+		"widths":      widths,
+		"placeholder": imageRecord.Placeholder,
+		"sources":     sources[:last],
+		"img":         img,
+		"html":        pictureHTML(sources[:last], img, imageRecord.Placeholder),
+	})
+}
+
+// parseSrcsetWidths parses a comma-separated list of widths, sorted and
+// limited to the image width. Widths must be render sizes or the image
+// width; the defaults that are not render sizes are left out. A list that
+// reaches beyond the image width ends with the image width itself.
+func parseSrcsetWidths(value string, imageWidth int, renderSizes []int) ([]int, error) {
+	var requested []int
+	if value == "" {
+		for _, w := range srcsetWidths {
+			if slices.Contains(renderSizes, w) {
+				requested = append(requested, w)
+			}
+		}
+		if len(requested) == 0 {
+			requested = []int{imageWidth}
+		}
+	} else {
+		for _, v := range strings.Split(value, ",") {
+			w, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil || (w != imageWidth && !slices.Contains(renderSizes, w)) {
+				return nil, fmt.Errorf("widths must be the image width or one of %s", joinSizes(renderSizes))
+			}
+			requested = append(requested, w)
+		}
+		if len(requested) > srcsetMaxWidths {
+			return nil, fmt.Errorf("at most %d widths are allowed", srcsetMaxWidths)
+		}
+	}
+
+	var widths []int
+	for _, w := range requested {
+		widths = append(widths, min(w, imageWidth))
+	}
+	slices.Sort(widths)
+	return slices.Compact(widths), nil
+}
+
+// parseSrcsetFormats parses a comma-separated list of formats.
+func parseSrcsetFormats(value string) ([]string, error) {
+	var formats []string
+	for _, f := range strings.Split(strings.ToLower(value), ",") {
+		f = strings.TrimSpace(f)
+		switch f {
+		case "jpg":
+			f = "jpeg"
+		case "jpeg", "png", "webp":
+		default:
+			return nil, errors.New("formats must be a list of jpeg, png, webp")
+		}
+		if !slices.Contains(formats, f) {
+			formats = append(formats, f)
+		}
+	}
+	return formats, nil
+}
+
+// renderURL returns the Render URL of a rendition. The version parameter is
+// ignored by Render but changes with every edit, so browsers and CDNs never
+// serve a stale rendition.
+func renderURL(imageRecord *models.Image, p renderParams) string {
+	url := fmt.Sprintf("/api/images/%s/render?w=%d&fmt=%s&q=%d", imageRecord.ID, p.Width, p.Format, p.Quality)
+	if p.Watermark != "" {
+		url += "&watermark=" + p.Watermark
+	}
+	return url + "&v=" + imageRecord.Version
+}
+
+// pictureHTML renders a <picture> element. The placeholder is shown as the
+// background of the <img> until the image has loaded.
+func pictureHTML(sources []srcsetSource, img srcsetImage, placeholder string) string {
+	var b strings.Builder
+	b.WriteString("<picture>")
+	for _, s := range sources {
+		fmt.Fprintf(&b, `<source type="%s" srcset="%s" sizes="%s">`,
+			s.Type, html.EscapeString(s.Srcset), html.EscapeString(img.Sizes))
+	}
+	fmt.Fprintf(&b, `<img src="%s" srcset="%s" sizes="%s" width="%d" height="%d" alt="%s" loading="lazy" decoding="async"`,
+		html.EscapeString(img.Src), html.EscapeString(img.Srcset), html.EscapeString(img.Sizes),
+		img.Width, img.Height, html.EscapeString(img.Alt))
+	if placeholder != "" {
+		fmt.Fprintf(&b, ` style="background-size:cover;background-image:url(%s)"`, placeholder)
+	}
+	b.WriteString("></picture>")
+	return b.String()
+}
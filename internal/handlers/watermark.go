@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"goga/internal/models"
+	"goga/internal/pipeline"
+	"goga/internal/repository"
+	"goga/internal/watermark"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	maxWatermarkName = 100
+	maxLogoSize      = 5 << 20 // 5MB
+)
+
+type WatermarkHandler struct {
+	repo  *repository.WatermarkRepository
+	logos *watermark.Logos
+}
+
+func NewWatermarkHandler(repo *repository.WatermarkRepository, logos *watermark.Logos) *WatermarkHandler {
+	return &WatermarkHandler{repo: repo, logos: logos}
+}
+
+func (h *WatermarkHandler) ListWatermarks(c *gin.Context) {
+	watermarks, err := h.repo.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, watermarks)
+}
+
+// CreateWatermark saves a named watermark for use with share links
+// (watermark_id) and exports (?watermark=).
+func (h *WatermarkHandler) CreateWatermark(c *gin.Context) {
+	var req models.WatermarkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxWatermarkName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1 to 100 characters"})
+		return
+	}
+	op, err := decodeWatermark(req.Spec, h.logos)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Store the spec as decoded so that it holds only known fields
+	spec, _ := json.Marshal(op.Spec)
+	saved := &models.Watermark{ID: uuid.New().String(), Name: name, Spec: spec, CreatedAt: time.Now()}
+	if err := h.repo.Create(saved); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save watermark"})
+		return
+	}
+	c.JSON(http.StatusCreated, saved)
+}
+
+// DeleteWatermark removes a saved watermark. Share links created with it
+// keep their own copy and stay watermarked.
+func (h *WatermarkHandler) DeleteWatermark(c *gin.Context) {
+	if err := h.repo.Delete(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Watermark not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Watermark deleted"})
+}
+
+func (h *WatermarkHandler) ListLogos(c *gin.Context) {
+	ids, err := h.logos.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"logos": ids})
+}
+
+// UploadLogo stores the PNG in the "logo" form field; the returned id is
+// used as the logo of a watermark.
+func (h *WatermarkHandler) UploadLogo(c *gin.Context) {
+	file, _, err := c.Request.FormFile("logo")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxLogoSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	if len(data) > maxLogoSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Logo must be at most 5MB"})
+		return
+	}
+
+	id, err := h.logos.Save(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"id": id})
+}
+
+// DeleteLogo removes an uploaded logo. Watermarks that use it are refused
+// from then on rather than served without the logo.
+func (h *WatermarkHandler) DeleteLogo(c *gin.Context) {
+	if err := h.logos.Delete(c.Param("id")); errors.Is(err, watermark.ErrLogoNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Logo not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logo deleted"})
+}
+
+// decodeWatermark reads and validates a watermark spec and loads its logo
+// from logos.
+func decodeWatermark(spec json.RawMessage, logos *watermark.Logos) (*pipeline.Watermark, error) {
+	op := &pipeline.Watermark{}
+	dec := json.NewDecoder(bytes.NewReader(spec))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(op); err != nil {
+		return nil, err
+	}
+	if err := pipeline.ValidateOperation(op); err != nil {
+		return nil, err
+	}
+	if err := op.Resolve(pipeline.Env{Logos: logos}); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// savedWatermark loads the saved watermark with the given id.
+func savedWatermark(repo *repository.WatermarkRepository, logos *watermark.Logos, id string) (*pipeline.Watermark, error) {
+	saved, err := repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return decodeWatermark(saved.Spec, logos)
+}
@@ -0,0 +1,188 @@
+// Package jobs runs background work such as AI descriptions and batch edits
+// and keeps track of its status in memory.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// retention is how long finished jobs stay queryable.
+const retention = 24 * time.Hour
+
+// Func is the work performed by a job. Returning an error marks the job failed.
+type Func func(ctx context.Context, job *Job) error
+
+type Job struct {
+	mu sync.Mutex
+
+	id         string
+	kind       string
+	status     Status
+	err        string
+	result     interface{}
+	createdAt  time.Time
+	startedAt  *time.Time
+	finishedAt *time.Time
+}
+
+func (j *Job) ID() string {
+	return j.id
+}
+
+// SetResult attaches a JSON-serialisable result, e.g. per-item progress.
+func (j *Job) SetResult(result interface{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.result = result
+}
+
+func (j *Job) Status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+func (j *Job) MarshalJSON() ([]byte, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return json.Marshal(struct {
+		ID         string      `json:"id"`
+		Type       string      `json:"type"`
+		Status     Status      `json:"status"`
+		Error      string      `json:"error,omitempty"`
+		Result     interface{} `json:"result,omitempty"`
+		CreatedAt  time.Time   `json:"created_at"`
+		StartedAt  *time.Time  `json:"started_at,omitempty"`
+		FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	}{j.id, j.kind, j.status, j.err, j.result, j.createdAt, j.startedAt, j.finishedAt})
+}
+
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.finishedAt = &now
+	if err != nil {
+		j.status = StatusFailed
+		j.err = err.Error()
+		return
+	}
+	j.status = StatusSucceeded
+}
+
+// Manager runs jobs with bounded concurrency.
+type Manager struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	slots  chan struct{}
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewManager(workers int) *Manager {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		ctx:    ctx,
+		cancel: cancel,
+		slots:  make(chan struct{}, workers),
+		jobs:   make(map[string]*Job),
+	}
+}
+
+// Submit queues fn and returns immediately.
+func (m *Manager) Submit(kind string, fn Func) *Job {
+	job := &Job{
+		id:        uuid.New().String(),
+		kind:      kind,
+		status:    StatusPending,
+		createdAt: time.Now(),
+	}
+
+	m.mu.Lock()
+	m.prune()
+	m.jobs[job.id] = job
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		select {
+		case m.slots <- struct{}{}:
+		case <-m.ctx.Done():
+			job.finish(m.ctx.Err())
+			return
+		}
+		defer func() { <-m.slots }()
+
+		job.mu.Lock()
+		now := time.Now()
+		job.status = StatusRunning
+		job.startedAt = &now
+		job.mu.Unlock()
+
+		job.finish(fn(m.ctx, job))
+	}()
+	return job
+}
+
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// List returns known jobs, newest first.
+func (m *Manager) List() []*Job {
+	m.mu.Lock()
+	list := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		list = append(list, job)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(list, func(i, k int) bool {
+		return list[i].createdAt.After(list[k].createdAt)
+	})
+	return list
+}
+
+// Close cancels running jobs and waits for them to return.
+func (m *Manager) Close() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// prune drops finished jobs past retention. Callers must hold m.mu.
+func (m *Manager) prune() {
+	cutoff := time.Now().Add(-retention)
+	for id, job := range m.jobs {
+		job.mu.Lock()
+		expired := job.finishedAt != nil && job.finishedAt.Before(cutoff)
+		job.mu.Unlock()
+		if expired {
+			delete(m.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+type Face struct {
+	ID        string    `json:"id" db:"id"`
+	ImageID   string    `json:"image_id" db:"image_id"`
+	PersonID  string    `json:"person_id,omitempty" db:"person_id"`
+	X         int       `json:"x" db:"x"`
+	Y         int       `json:"y" db:"y"`
+	Width     int       `json:"width" db:"width"`
+	Height    int       `json:"height" db:"height"`
+	Score     float64   `json:"score" db:"score"`
+	Embedding []float32 `json:"-" db:"embedding"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type Person struct {
+	ID         string    `json:"id" db:"id"`
+	Name       string    `json:"name" db:"name"`
+	FaceCount  int       `json:"face_count"`
+	ImageCount int       `json:"image_count"`
+	CoverFace  *Face     `json:"cover_face,omitempty"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+type PersonUpdateRequest struct {
+	Name string `json:"name"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strconv"
 	"time"
 )
 
@@ -15,6 +16,46 @@ type Image struct {
 	Format      string    `json:"format" db:"format"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+
+	// Descriptive metadata set by the user, the only metadata written into
+	// downloaded files
+	Title       string   `json:"title" db:"title"`
+	UserCaption string   `json:"user_caption" db:"user_caption"`
+	Copyright   string   `json:"copyright" db:"copyright"`
+	Keywords    []string `json:"keywords" db:"keywords"`
+
+	// AI-generated description, replaced by every describe job
+	Caption     string     `json:"caption" db:"caption"`
+	Tags        []string   `json:"tags" db:"tags"`
+	AltText     string     `json:"alt_text" db:"alt_text"`
+	DescribedAt *time.Time `json:"described_at,omitempty" db:"described_at"`
+
+	// Location from EXIF GPS or set manually; country and city are reverse geocoded
+	Latitude  *float64 `json:"latitude" db:"latitude"`
+	Longitude *float64 `json:"longitude" db:"longitude"`
+	Country   string   `json:"country" db:"country"`
+	City      string   `json:"city" db:"city"`
+
+	// Previews shown while the image loads and its dominant colours, derived
+	// from the file. Placeholder is a tiny JPEG as a data URI.
+	Placeholder string         `json:"placeholder" db:"placeholder"`
+	BlurHash    string         `json:"blurhash" db:"blurhash"`
+	Palette     []PaletteColor `json:"palette" db:"palette"`
+
+	// Version changes whenever the file changes and is used in cacheable URLs
+	Version string `json:"version" db:"-"`
+}
+
+// PaletteColor is a dominant colour of an image, as #rrggbb, and the share
+// of the image it covers.
+type PaletteColor struct {
+	Color string  `json:"color"`
+	Share float64 `json:"share"`
+}
+
+// SetVersion derives Version from UpdatedAt.
+func (i *Image) SetVersion() {
+	i.Version = strconv.FormatInt(i.UpdatedAt.UnixNano(), 36)
 }
 
 type ImageUploadRequest struct {
@@ -22,7 +63,23 @@ type ImageUploadRequest struct {
 	Name string `json:"name"`
 }
 
+// ImageLocationRequest sets or, with null coordinates, clears a location.
+type ImageLocationRequest struct {
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+}
+
+// ImageMetadataRequest edits descriptive metadata. Omitted fields are left
+// unchanged. The AI caption and tags are kept apart and never changed.
+type ImageMetadataRequest struct {
+	Title     *string   `json:"title"`
+	Caption   *string   `json:"caption"`
+	Copyright *string   `json:"copyright"`
+	Keywords  *[]string `json:"keywords"`
+}
+
 type ImageConvertRequest struct {
-	Format  string `json:"format"`
-	Quality int    `json:"quality,omitempty"`
+	Format   string `json:"format"`
+	Quality  int    `json:"quality,omitempty"`
+	Metadata string `json:"metadata,omitempty"` // keep, strip_gps or strip_all
 }
\ No newline at end of file
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Mask is a stored selection of an image, 255 where selected and 0
+// elsewhere, kept as a grayscale PNG of the image's size at ImageVersion.
+// Masks can be reused as selections for local adjustments.
+type Mask struct {
+	ID           string    `json:"id" db:"id"`
+	ImageID      string    `json:"image_id" db:"image_id"`
+	ImageVersion string    `json:"image_version" db:"image_version"`
+	Subject      string    `json:"subject" db:"subject"`   // what was asked for; empty for the main subject
+	Provider     string    `json:"provider" db:"provider"` // segmenter that produced the mask
+	Width        int       `json:"width" db:"width"`
+	Height       int       `json:"height" db:"height"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// SegmentRequest asks for a mask of Subject, or of the main subject.
+type SegmentRequest struct {
+	Subject string `json:"subject"`
+}
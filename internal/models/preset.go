@@ -0,0 +1,24 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Preset is a named set of edit settings. Presets without an owner are
+// global; owned presets are only listed for that owner.
+type Preset struct {
+	ID        string          `json:"id" db:"id"`
+	Name      string          `json:"name" db:"name"`
+	Owner     string          `json:"owner" db:"owner"`
+	Edit      json.RawMessage `json:"edit" db:"edit"` // an edit request
+	BuiltIn   bool            `json:"built_in" db:"-"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+type PresetRequest struct {
+	Name  string          `json:"name" binding:"required"`
+	Owner string          `json:"owner"`
+	Edit  json.RawMessage `json:"edit" binding:"required"`
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Share struct {
+	ID            string          `json:"id" db:"id"`
+	Token         string          `json:"token" db:"token"`
+	Title         string          `json:"title" db:"title"`
+	ImageIDs      []string        `json:"image_ids"`
+	PasswordHash  string          `json:"-" db:"password_hash"`
+	ExpiresAt     *time.Time      `json:"expires_at,omitempty" db:"expires_at"`
+	MaxViews      int             `json:"max_views" db:"max_views"`
+	Views         int             `json:"views" db:"views"`
+	AllowDownload bool            `json:"allow_download" db:"allow_download"`
+	Metadata      string          `json:"metadata" db:"metadata"`             // metadata policy for served files
+	Watermark     json.RawMessage `json:"watermark,omitempty" db:"watermark"` // applied to every served file
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+	RevokedAt     *time.Time      `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// HasPassword reports whether visitors must enter a password.
+func (s *Share) HasPassword() bool {
+	return s.PasswordHash != ""
+}
+
+// Active reports whether the link is neither revoked nor expired.
+func (s *Share) Active(now time.Time) bool {
+	if s.RevokedAt != nil {
+		return false
+	}
+	if s.ExpiresAt != nil && now.After(*s.ExpiresAt) {
+		return false
+	}
+	return true
+}
+
+// ViewsExhausted reports whether the gallery has been opened max_views times.
+func (s *Share) ViewsExhausted() bool {
+	return s.MaxViews > 0 && s.Views >= s.MaxViews
+}
+
+// Contains reports whether the image is part of the share.
+func (s *Share) Contains(imageID string) bool {
+	for _, id := range s.ImageIDs {
+		if id == imageID {
+			return true
+		}
+	}
+	return false
+}
+
+type ShareCreateRequest struct {
+	ImageIDs      []string `json:"image_ids"`
+	Title         string   `json:"title"`
+	Password      string   `json:"password"`
+	ExpiresIn     int      `json:"expires_in"` // seconds, 0 means never
+	MaxViews      int      `json:"max_views"`
+	AllowDownload bool     `json:"allow_download"`
+	Metadata      string   `json:"metadata"`     // keep, strip_gps (default) or strip_all
+	WatermarkID   string   `json:"watermark_id"` // saved watermark to apply to every served file
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Watermark is a saved watermark that share links and exports refer to by
+// id. Spec holds the overlay settings (see watermark.Spec).
+type Watermark struct {
+	ID        string          `json:"id" db:"id"`
+	Name      string          `json:"name" db:"name"`
+	Spec      json.RawMessage `json:"spec" db:"spec"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
+
+type WatermarkRequest struct {
+	Name string          `json:"name" binding:"required"`
+	Spec json.RawMessage `json:"spec" binding:"required"`
+}
@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"goga/internal/adjust"
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+func init() {
+	Register(Definition{"local", "Apply operations only within a radial, linear, brushed or stored mask", func() Operation { return &Local{} }})
+}
+
+// notLocal are the operations that cannot be used in a local adjustment
+// because they move pixels or change the size of the image.
+var notLocal = map[string]bool{
+	"crop": true, "flip": true, "local": true, "perspective": true, "resize": true,
+	"rotate": true, "rotate90": true, "straighten": true, "watermark": true,
+}
+
+// Local runs its own operations on the image and blends the result in
+// where the mask selects, e.g. to brighten a face or darken the sky.
+type Local struct {
+	Mask       Mask     `json:"mask"`
+	Operations Pipeline `json:"operations"`
+}
+
+func (o *Local) Validate() error {
+	if len(o.Operations) == 0 {
+		return errors.New("local adjustment needs operations")
+	}
+	for i, step := range o.Operations {
+		if notLocal[step.Name] {
+			return fmt.Errorf("operations[%d]: %s cannot be applied locally", i, step.Name)
+		}
+	}
+	if err := o.Operations.Validate(); err != nil {
+		return err
+	}
+	return o.Mask.Validate()
+}
+
+func (o *Local) Resolve(env Env) error {
+	if err := o.Mask.Resolve(env); err != nil {
+		return fmt.Errorf("mask: %w", err)
+	}
+	return o.Operations.Resolve(env)
+}
+
+func (o *Local) Apply(img *image.NRGBA) *image.NRGBA {
+	adjusted := imaging.Clone(img)
+	for _, step := range o.Operations {
+		adjusted = step.Op.Apply(adjusted)
+	}
+	if adjusted.Rect.Size() != img.Rect.Size() {
+		return img
+	}
+
+	w := img.Rect.Dx()
+	weights := o.Mask.Render(w, img.Rect.Dy())
+	adjust.ForEachRow(img, func(y int, row []uint8) {
+		from := adjusted.Pix[y*adjusted.Stride:]
+		for x := 0; x < w; x++ {
+			m := weights[y*w+x]
+			if m == 0 {
+				continue
+			}
+			for i := x * 4; i < x*4+4; i++ {
+				row[i] = uint8(float32(row[i]) + (float32(from[i])-float32(row[i]))*m + 0.5)
+			}
+		}
+	})
+	return img
+}
+
+// Scaled scales the operations; the mask is relative to the image size.
+func (o *Local) Scaled(f float64) Operation {
+	return &Local{Mask: o.Mask, Operations: o.Operations.Scaled(f)}
+}
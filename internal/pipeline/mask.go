@@ -0,0 +1,335 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Limits on brush masks, which arrive with every preview request.
+const (
+	maxStrokes      = 500
+	maxStrokePoints = 100000 // over all strokes
+	maxMaskPNG      = 8 << 20
+)
+
+// Defaults for the optional Mask and Stroke fields.
+const (
+	defaultMaskFeather   = 50
+	defaultBrushFeather  = 50
+	defaultMaskDensity   = 100
+	defaultStrokeOpacity = 100
+)
+
+var (
+	// ErrMaskNotFound is returned for stored masks that do not exist or
+	// belong to another image.
+	ErrMaskNotFound = errors.New("mask not found")
+	// ErrMaskStale is returned for stored masks made before the image was
+	// last changed.
+	ErrMaskStale = errors.New("mask was made for an earlier version of the image")
+)
+
+// MaskStore opens the stored mask id of image imageID at version. It
+// returns ErrMaskNotFound for masks of other images and ErrMaskStale for
+// masks of other versions.
+type MaskStore interface {
+	OpenMask(id, imageID, version string) (*image.Gray, error)
+}
+
+// Mask selects the part of an image a local adjustment applies to.
+// Positions are fractions of the image's width and height, so a mask fits
+// previews and the original alike.
+//
+// A radial mask is an ellipse around (x, y) with radii radius_x and
+// radius_y, turned by angle degrees. A linear mask is a gradient from full
+// effect at (x, y) to none at (x2, y2). For both, feather is the percentage
+// of the distance over which the effect fades out. A brush mask is painted
+// with strokes, over an optional grayscale PNG (base64, white selects) that
+// is stretched to the image. A stored mask is one saved by segmentation,
+// stretched to the image as it is at this step.
+type Mask struct {
+	Type    string   `json:"type" enum:"radial,linear,brush,stored"`
+	X       float64  `json:"x" min:"-1" max:"2"`
+	Y       float64  `json:"y" min:"-1" max:"2"`
+	X2      float64  `json:"x2" min:"-1" max:"2"`
+	Y2      float64  `json:"y2" min:"-1" max:"2"`
+	RadiusX float64  `json:"radius_x" min:"0" max:"2"`
+	RadiusY float64  `json:"radius_y" min:"0" max:"2"`
+	Angle   float64  `json:"angle" min:"-180" max:"180"`
+	Feather *float64 `json:"feather" min:"0" max:"100"`
+	Strokes []Stroke `json:"strokes"`
+	PNG     string   `json:"png"`
+	ID      string   `json:"id"`
+	Invert  bool     `json:"invert"`
+	Density *float64 `json:"density" min:"0" max:"100"` // strength of the selection, in percent
+
+	decoded image.Image // PNG, decoded once
+	stored  *image.Gray // stored mask, loaded by Resolve
+}
+
+// Stroke is a brush stroke through points given as [x, y] fractions of the
+// image size. Size is the brush diameter as a percentage of the image's
+// shorter side and feather the percentage of the radius that is soft.
+// Erasing strokes remove from the mask.
+type Stroke struct {
+	Points  [][2]float64 `json:"points"`
+	Size    float64      `json:"size" min:"0.01" max:"100"`
+	Feather *float64     `json:"feather" min:"0" max:"100"`
+	Opacity *float64     `json:"opacity" min:"0" max:"100"`
+	Erase   bool         `json:"erase"`
+}
+
+func (m *Mask) Validate() error {
+	switch m.Type {
+	case "radial":
+		if m.RadiusX <= 0 || m.RadiusY <= 0 {
+			return errors.New("radial mask needs radius_x and radius_y")
+		}
+	case "linear":
+		if m.X == m.X2 && m.Y == m.Y2 {
+			return errors.New("linear mask needs distinct start and end points")
+		}
+	case "brush":
+		if len(m.Strokes) == 0 && m.PNG == "" {
+			return errors.New("brush mask needs strokes or a png")
+		}
+		if len(m.Strokes) > maxStrokes {
+			return fmt.Errorf("brush mask has more than %d strokes", maxStrokes)
+		}
+		points := 0
+		for i := range m.Strokes {
+			if err := validateStruct(reflect.ValueOf(m.Strokes[i])); err != nil {
+				return fmt.Errorf("strokes[%d]: %v", i, err)
+			}
+			if len(m.Strokes[i].Points) == 0 {
+				return fmt.Errorf("strokes[%d]: stroke needs points", i)
+			}
+			points += len(m.Strokes[i].Points)
+		}
+		if points > maxStrokePoints {
+			return fmt.Errorf("brush mask has more than %d points", maxStrokePoints)
+		}
+		if m.PNG != "" {
+			if _, err := m.png(); err != nil {
+				return err
+			}
+		}
+	case "stored":
+		if m.ID == "" {
+			return errors.New("stored mask needs an id")
+		}
+	}
+	return nil
+}
+
+// Resolve loads a stored mask, which must belong to the image and version
+// in env.
+func (m *Mask) Resolve(env Env) error {
+	if m.Type != "stored" {
+		return nil
+	}
+	if env.Masks == nil {
+		return ErrMaskNotFound
+	}
+	stored, err := env.Masks.OpenMask(m.ID, env.ImageID, env.Version)
+	if err != nil {
+		return err
+	}
+	m.stored = stored
+	return nil
+}
+
+func (m *Mask) png() (image.Image, error) {
+	if m.decoded != nil {
+		return m.decoded, nil
+	}
+	if len(m.PNG) > maxMaskPNG {
+		return nil, errors.New("mask png is too large")
+	}
+	data, err := base64.StdEncoding.DecodeString(m.PNG[strings.IndexByte(m.PNG, ',')+1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid mask png: %v", err)
+	}
+	// Check the size before decoding, a small PNG can expand to gigabytes
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid mask png: %v", err)
+	}
+	if cfg.Width > maxDimension || cfg.Height > maxDimension {
+		return nil, fmt.Errorf("mask png is larger than %dx%d", maxDimension, maxDimension)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid mask png: %v", err)
+	}
+	m.decoded = img
+	return img, nil
+}
+
+// Render returns the mask for a w×h image as one weight from 0 to 1 per
+// pixel. A stored mask that was not resolved selects nothing.
+func (m *Mask) Render(w, h int) []float32 {
+	weights := make([]float32, w*h)
+	switch m.Type {
+	case "radial":
+		m.radial(weights, w, h)
+	case "linear":
+		m.linear(weights, w, h)
+	case "brush":
+		if m.PNG != "" {
+			if img, err := m.png(); err == nil {
+				stretch(weights, img, w, h)
+			}
+		}
+		for _, s := range m.Strokes {
+			s.paint(weights, w, h)
+		}
+	case "stored":
+		if m.stored != nil {
+			stretch(weights, m.stored, w, h)
+		}
+	}
+
+	density := float32(valueOr(m.Density, defaultMaskDensity) / 100)
+	for i, v := range weights {
+		if m.Invert {
+			v = 1 - v
+		}
+		weights[i] = v * density
+	}
+	return weights
+}
+
+func (m *Mask) radial(weights []float32, w, h int) {
+	cx, cy := m.X*float64(w), m.Y*float64(h)
+	rx, ry := m.RadiusX*float64(w), m.RadiusY*float64(h)
+	sin, cos := math.Sincos(m.Angle * math.Pi / 180)
+	inner := 1 - valueOr(m.Feather, defaultMaskFeather)/100
+	for y := 0; y < h; y++ {
+		dy := float64(y) + 0.5 - cy
+		for x := 0; x < w; x++ {
+			dx := float64(x) + 0.5 - cx
+			u, v := (dx*cos+dy*sin)/rx, (dy*cos-dx*sin)/ry
+			weights[y*w+x] = float32(1 - smoothstep(inner, 1, math.Hypot(u, v)))
+		}
+	}
+}
+
+func (m *Mask) linear(weights []float32, w, h int) {
+	x0, y0 := m.X*float64(w), m.Y*float64(h)
+	dx, dy := m.X2*float64(w)-x0, m.Y2*float64(h)-y0
+	length := dx*dx + dy*dy
+	// The fade starts feather percent of the way before the end point
+	start := 1 - valueOr(m.Feather, defaultMaskFeather)/100
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			t := ((float64(x)+0.5-x0)*dx + (float64(y)+0.5-y0)*dy) / length
+			weights[y*w+x] = float32(1 - smoothstep(start, 1, t))
+		}
+	}
+}
+
+// paint combines the stroke into weights. Within a stroke coverage is the
+// maximum over its segments, so joints are not painted twice.
+func (s Stroke) paint(weights []float32, w, h int) {
+	short := float64(min(w, h))
+	radius := math.Max(0.5, s.Size/100*short/2)
+	hard := radius * (1 - valueOr(s.Feather, defaultBrushFeather)/100)
+	opacity := valueOr(s.Opacity, defaultStrokeOpacity) / 100
+
+	points := make([][2]float64, len(s.Points))
+	bounds := image.Rectangle{}
+	for i, p := range s.Points {
+		points[i] = [2]float64{p[0] * float64(w), p[1] * float64(h)}
+		r := image.Rect(
+			int(math.Floor(points[i][0]-radius)), int(math.Floor(points[i][1]-radius)),
+			int(math.Ceil(points[i][0]+radius))+1, int(math.Ceil(points[i][1]+radius))+1,
+		)
+		bounds = bounds.Union(r)
+	}
+	bounds = bounds.Intersect(image.Rect(0, 0, w, h))
+	if bounds.Empty() {
+		return
+	}
+
+	coverage := make([]float64, bounds.Dx()*bounds.Dy())
+	for i := range points {
+		a, b := points[i], points[max(0, i-1)]
+		seg := image.Rect(
+			int(math.Floor(math.Min(a[0], b[0])-radius)), int(math.Floor(math.Min(a[1], b[1])-radius)),
+			int(math.Ceil(math.Max(a[0], b[0])+radius))+1, int(math.Ceil(math.Max(a[1], b[1])+radius))+1,
+		).Intersect(bounds)
+		for y := seg.Min.Y; y < seg.Max.Y; y++ {
+			for x := seg.Min.X; x < seg.Max.X; x++ {
+				d := segmentDistance(float64(x)+0.5, float64(y)+0.5, a, b)
+				if d >= radius {
+					continue
+				}
+				c := &coverage[(y-bounds.Min.Y)*bounds.Dx()+x-bounds.Min.X]
+				*c = math.Max(*c, 1-smoothstep(hard, radius, d))
+			}
+		}
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := float32(coverage[(y-bounds.Min.Y)*bounds.Dx()+x-bounds.Min.X] * opacity)
+			if v := &weights[y*w+x]; s.Erase {
+				*v *= 1 - c
+			} else {
+				*v = max(*v, c)
+			}
+		}
+	}
+}
+
+// segmentDistance returns the distance from (x, y) to the segment a-b.
+func segmentDistance(x, y float64, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = math.Max(0, math.Min(1, ((x-a[0])*dx+(y-a[1])*dy)/length))
+	}
+	return math.Hypot(x-a[0]-t*dx, y-a[1]-t*dy)
+}
+
+// stretch scales img to w×h and adds its brightness, times its alpha, to
+// weights.
+func stretch(weights []float32, img image.Image, w, h int) {
+	scaled := imaging.Resize(img, w, h, imaging.Linear)
+	for y := 0; y < h; y++ {
+		row := scaled.Pix[y*scaled.Stride:]
+		for x := 0; x < w; x++ {
+			r, g, b, a := float32(row[x*4]), float32(row[x*4+1]), float32(row[x*4+2]), float32(row[x*4+3])
+			v := (0.299*r + 0.587*g + 0.114*b) / 255 * a / 255
+			weights[y*w+x] = max(weights[y*w+x], v)
+		}
+	}
+}
+
+func smoothstep(edge0, edge1, x float64) float64 {
+	if edge1 <= edge0 {
+		if x < edge1 {
+			return 0
+		}
+		return 1
+	}
+	t := math.Max(0, math.Min(1, (x-edge0)/(edge1-edge0)))
+	return t * t * (3 - 2*t)
+}
+
+func valueOr(p *float64, def float64) float64 {
+	if p == nil {
+		return def
+	}
+	return *p
+}
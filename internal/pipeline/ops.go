@@ -0,0 +1,343 @@
+package pipeline
+
+import (
+	"errors"
+	"goga/internal/adjust"
+	"goga/pkg/utils"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/gift"
+	"github.com/disintegration/imaging"
+)
+
+// maxDimension bounds the output size of resize operations.
+const maxDimension = 16384
+
+func init() {
+	for _, def := range []Definition{
+		{"white_balance", "Temperature (blue-yellow) and tint (green-magenta) in linear light", func() Operation { return &WhiteBalance{} }},
+		{"shadows_highlights", "Brighten or darken shadows and highlights separately", func() Operation { return &ShadowsHighlights{} }},
+		{"levels", "Black point, white point and gamma, per channel and for RGB", func() Operation { return &Levels{} }},
+		{"curves", "Tone curves through control points, per channel and for RGB", func() Operation { return &Curves{} }},
+		{"vibrance", "Saturation that spares already saturated colours", func() Operation { return &Vibrance{} }},
+		{"hsl", "Hue, saturation and luminance per colour range", func() Operation { return &HSL{} }},
+		{"mixer", "Channel mixer, each output channel as percentages of the inputs", func() Operation { return &Mixer{} }},
+		{"brightness", "Brightness", func() Operation { return &Brightness{} }},
+		{"contrast", "Contrast", func() Operation { return &Contrast{} }},
+		{"saturation", "Saturation", func() Operation { return &Saturation{} }},
+		{"hue", "Rotate all hues by the given degrees", func() Operation { return &Hue{} }},
+		{"gamma", "Gamma; positive values brighten midtones", func() Operation { return &Gamma{} }},
+		{"blur", "Gaussian blur with the given sigma in pixels", func() Operation { return &Blur{} }},
+		{"sharpen", "Unsharp mask with the given sigma in pixels", func() Operation { return &Sharpen{} }},
+		{"clarity", "Local contrast", func() Operation { return &Clarity{} }},
+		{"noise_reduction", "Smooth out noise", func() Operation { return &NoiseReduction{} }},
+		{"rotate", "Rotate counter-clockwise by any angle in degrees", func() Operation { return &Rotate{} }},
+		{"crop", "Crop to a rectangle given as fractions of the image size", func() Operation { return &Crop{} }},
+		{"flip", "Mirror horizontally or vertically", func() Operation { return &Flip{} }},
+		{"resize", "Fit within a box in pixels, keeping the aspect ratio", func() Operation { return &Resize{} }},
+		{"vignette", "Darken or lighten towards the edges", func() Operation { return &Vignette{} }},
+	} {
+		Register(def)
+	}
+}
+
+// applyFilter runs a gift filter into a new image.
+func applyFilter(img *image.NRGBA, filter gift.Filter) *image.NRGBA {
+	g := gift.New(filter)
+	dst := image.NewNRGBA(g.Bounds(img.Bounds()))
+	g.Draw(dst, img)
+	return dst
+}
+
+type WhiteBalance struct {
+	Temperature float64 `json:"temperature" min:"-100" max:"100"`
+	Tint        float64 `json:"tint" min:"-100" max:"100"`
+}
+
+func (o *WhiteBalance) Apply(img *image.NRGBA) *image.NRGBA {
+	adjust.WhiteBalance(img, o.Temperature, o.Tint)
+	return img
+}
+
+type ShadowsHighlights struct {
+	Shadows    float64 `json:"shadows" min:"-100" max:"100"`
+	Highlights float64 `json:"highlights" min:"-100" max:"100"`
+}
+
+func (o *ShadowsHighlights) Apply(img *image.NRGBA) *image.NRGBA {
+	adjust.ShadowsHighlights(img, o.Shadows, o.Highlights)
+	return img
+}
+
+type Levels struct {
+	adjust.Levels
+}
+
+func (o *Levels) Apply(img *image.NRGBA) *image.NRGBA {
+	adjust.ApplyLevels(img, &o.Levels)
+	return img
+}
+
+type Curves struct {
+	adjust.Curves
+}
+
+func (o *Curves) Apply(img *image.NRGBA) *image.NRGBA {
+	adjust.ApplyCurves(img, &o.Curves)
+	return img
+}
+
+type Vibrance struct {
+	Value float64 `json:"value" min:"-100" max:"100"`
+}
+
+func (o *Vibrance) Apply(img *image.NRGBA) *image.NRGBA {
+	adjust.Vibrance(img, o.Value)
+	return img
+}
+
+type HSL struct {
+	Ranges adjust.HSL `json:"ranges"`
+}
+
+func (o *HSL) Validate() error {
+	return o.Ranges.Validate()
+}
+
+func (o *HSL) Apply(img *image.NRGBA) *image.NRGBA {
+	adjust.ApplyHSL(img, o.Ranges)
+	return img
+}
+
+type Mixer struct {
+	adjust.Mixer
+}
+
+func (o *Mixer) Apply(img *image.NRGBA) *image.NRGBA {
+	adjust.ApplyMixer(img, &o.Mixer)
+	return img
+}
+
+type Brightness struct {
+	Value float64 `json:"value" min:"-100" max:"100"`
+}
+
+func (o *Brightness) Apply(img *image.NRGBA) *image.NRGBA {
+	return applyFilter(img, gift.Brightness(float32(o.Value*0.3)))
+}
+
+type Contrast struct {
+	Value float64 `json:"value" min:"-100" max:"100"`
+}
+
+func (o *Contrast) Apply(img *image.NRGBA) *image.NRGBA {
+	return applyFilter(img, gift.Contrast(float32(o.Value*0.5)))
+}
+
+type Saturation struct {
+	Value float64 `json:"value" min:"-100" max:"100"`
+}
+
+func (o *Saturation) Apply(img *image.NRGBA) *image.NRGBA {
+	return applyFilter(img, gift.Saturation(float32(math.Max(0, 100+o.Value*2))))
+}
+
+type Hue struct {
+	Value float64 `json:"value" min:"-180" max:"180"`
+}
+
+func (o *Hue) Apply(img *image.NRGBA) *image.NRGBA {
+	return applyFilter(img, gift.Hue(float32(o.Value)))
+}
+
+type Gamma struct {
+	Value float64 `json:"value" min:"-100" max:"100"`
+}
+
+func (o *Gamma) Apply(img *image.NRGBA) *image.NRGBA {
+	return applyFilter(img, gift.Gamma(float32(math.Max(0.1, math.Min(5, 1+o.Value*0.02)))))
+}
+
+type Blur struct {
+	Sigma float64 `json:"sigma" min:"0" max:"100"`
+}
+
+func (o *Blur) Apply(img *image.NRGBA) *image.NRGBA {
+	return applyFilter(img, gift.GaussianBlur(float32(o.Sigma)))
+}
+
+func (o *Blur) Scaled(f float64) Operation {
+	return &Blur{Sigma: o.Sigma * f}
+}
+
+type Sharpen struct {
+	Sigma float64 `json:"sigma" min:"0" max:"50"`
+}
+
+func (o *Sharpen) Apply(img *image.NRGBA) *image.NRGBA {
+	return applyFilter(img, gift.UnsharpMask(float32(o.Sigma), 1.0, 0.05))
+}
+
+func (o *Sharpen) Scaled(f float64) Operation {
+	return &Sharpen{Sigma: o.Sigma * f}
+}
+
+// Clarity and NoiseReduction tie their radius to the value; for previews
+// only the radius is scaled, by radiusScale (0 meaning 1).
+
+type Clarity struct {
+	Value       float64 `json:"value" min:"-100" max:"100"`
+	radiusScale float64
+}
+
+func (o *Clarity) Apply(img *image.NRGBA) *image.NRGBA {
+	return applyFilter(img, gift.UnsharpMask(float32(o.Value*0.1*orOne(o.radiusScale)), 2.0, 0.1))
+}
+
+func (o *Clarity) Scaled(f float64) Operation {
+	return &Clarity{Value: o.Value, radiusScale: orOne(o.radiusScale) * f}
+}
+
+type NoiseReduction struct {
+	Value       float64 `json:"value" min:"0" max:"100"`
+	radiusScale float64
+}
+
+func (o *NoiseReduction) Apply(img *image.NRGBA) *image.NRGBA {
+	return applyFilter(img, gift.GaussianBlur(float32(o.Value*0.1*orOne(o.radiusScale))))
+}
+
+func (o *NoiseReduction) Scaled(f float64) Operation {
+	return &NoiseReduction{Value: o.Value, radiusScale: orOne(o.radiusScale) * f}
+}
+
+func orOne(v float64) float64 {
+	if v == 0 {
+		return 1
+	}
+	return v
+}
+
+// Rotate fills the corners it uncovers with Background (#rgb, #rrggbb or
+// #rrggbbaa), transparent by default.
+type Rotate struct {
+	Angle      float64 `json:"angle" min:"-360" max:"360"`
+	Background string  `json:"background"`
+}
+
+func (o *Rotate) Validate() error {
+	if o.Background == "" {
+		return nil
+	}
+	_, err := utils.ParseHexColor(o.Background)
+	return err
+}
+
+func (o *Rotate) Apply(img *image.NRGBA) *image.NRGBA {
+	var bg color.Color = color.Transparent
+	if c, err := utils.ParseHexColor(o.Background); err == nil {
+		bg = c
+	}
+	return imaging.Rotate(img, o.Angle, bg)
+}
+
+// Crop with an Aspect preset shrinks the rectangle around its centre to
+// that exact ratio in pixels.
+type Crop struct {
+	X      float64 `json:"x" min:"0" max:"1"`
+	Y      float64 `json:"y" min:"0" max:"1"`
+	Width  float64 `json:"width" min:"0" max:"1"`
+	Height float64 `json:"height" min:"0" max:"1"`
+	Aspect string  `json:"aspect" enum:",original,1:1,4:5,5:4,2:3,3:2,3:4,4:3,9:16,16:9"`
+}
+
+func (o *Crop) Validate() error {
+	const eps = 1e-6
+	if o.Width <= 0 || o.Height <= 0 {
+		return errors.New("width and height must be positive")
+	}
+	if o.X+o.Width > 1+eps || o.Y+o.Height > 1+eps {
+		return errors.New("crop must lie within the image")
+	}
+	return nil
+}
+
+func (o *Crop) Apply(img *image.NRGBA) *image.NRGBA {
+	bounds := img.Bounds()
+	x := int(o.X * float64(bounds.Dx()))
+	y := int(o.Y * float64(bounds.Dy()))
+	w := max(1, int(o.Width*float64(bounds.Dx())))
+	h := max(1, int(o.Height*float64(bounds.Dy())))
+	rect := image.Rect(x, y, x+w, y+h)
+	if o.Aspect == "original" {
+		rect = fitAspect(rect, float64(bounds.Dx())/float64(bounds.Dy()))
+	} else if aspect, ok := cropAspects[o.Aspect]; ok {
+		rect = fitAspect(rect, aspect)
+	}
+	return imaging.Crop(img, rect)
+}
+
+type Flip struct {
+	Direction string `json:"direction" enum:"horizontal,vertical"`
+}
+
+func (o *Flip) Apply(img *image.NRGBA) *image.NRGBA {
+	if o.Direction == "vertical" {
+		return imaging.FlipV(img)
+	}
+	return imaging.FlipH(img)
+}
+
+type Resize struct {
+	Width  int `json:"width" min:"0" max:"16384"`
+	Height int `json:"height" min:"0" max:"16384"`
+}
+
+func (o *Resize) Validate() error {
+	if o.Width == 0 && o.Height == 0 {
+		return errors.New("width or height is required")
+	}
+	return nil
+}
+
+func (o *Resize) Apply(img *image.NRGBA) *image.NRGBA {
+	bounds := img.Bounds()
+	w, h := o.Width, o.Height
+	if w <= 0 {
+		w = bounds.Dx()
+	}
+	if h <= 0 {
+		h = bounds.Dy()
+	}
+	return imaging.Fit(img, min(w, maxDimension), min(h, maxDimension), imaging.Lanczos)
+}
+
+func (o *Resize) Scaled(f float64) Operation {
+	scaled := &Resize{}
+	if o.Width > 0 {
+		scaled.Width = max(1, int(math.Round(float64(o.Width)*f)))
+	}
+	if o.Height > 0 {
+		scaled.Height = max(1, int(math.Round(float64(o.Height)*f)))
+	}
+	return scaled
+}
+
+type Vignette struct {
+	Amount    float64  `json:"amount" min:"-100" max:"100"`
+	Midpoint  *float64 `json:"midpoint" min:"0" max:"100"`
+	Feather   *float64 `json:"feather" min:"0" max:"100"`
+	Roundness *float64 `json:"roundness" min:"-100" max:"100"`
+}
+
+func (o *Vignette) Apply(img *image.NRGBA) *image.NRGBA {
+	adjust.ApplyVignette(img, adjust.Vignette{
+		Amount:    o.Amount,
+		Midpoint:  o.Midpoint,
+		Feather:   o.Feather,
+		Roundness: o.Roundness,
+	})
+	return img
+}
@@ -0,0 +1,197 @@
+// Package pipeline expresses an edit as an ordered list of typed operations.
+// Operations are registered by name, decoded from
+//
+//	{"op": "crop", "params": {"x": 0.1, "y": 0, "width": 0.8, "height": 1}}
+//
+// and validated against the ranges declared in their struct tags, which are
+// also published as JSON Schema for clients.
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"goga/internal/watermark"
+	"image"
+	"reflect"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// Operation is one step of an edit. Apply may modify img in place or return
+// a new image, e.g. when the size changes.
+type Operation interface {
+	Apply(img *image.NRGBA) *image.NRGBA
+}
+
+// Scaler is implemented by operations with parameters measured in pixels.
+// Scaled returns a copy adjusted for an image f times the original size.
+type Scaler interface {
+	Scaled(f float64) Operation
+}
+
+// Validator is implemented by operations with checks beyond field ranges.
+type Validator interface {
+	Validate() error
+}
+
+// Resolver is implemented by operations that refer to stored data, such as
+// masks and logos. Resolve loads it from env.
+type Resolver interface {
+	Resolve(env Env) error
+}
+
+// Env is what operations may refer to besides their parameters: the image
+// being edited and where its stored masks and uploaded logos are found.
+type Env struct {
+	ImageID string
+	Version string
+	Masks   MaskStore
+	Logos   *watermark.Logos
+}
+
+// Definition registers an operation. New returns a pointer to the
+// operation's parameters with their defaults set.
+type Definition struct {
+	Name        string
+	Description string
+	New         func() Operation
+}
+
+var (
+	registry  = make(map[string]Definition)
+	typeNames = make(map[reflect.Type]string)
+)
+
+// Register adds an operation. Registering a name twice panics.
+func Register(def Definition) {
+	if _, dup := registry[def.Name]; dup {
+		panic("pipeline: operation registered twice: " + def.Name)
+	}
+	registry[def.Name] = def
+	typeNames[reflect.TypeOf(def.New())] = def.Name
+}
+
+// Names lists the registered operations in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Step is an operation together with its registered name.
+type Step struct {
+	Name string
+	Op   Operation
+}
+
+// NewStep wraps op, which must be a pointer to a registered operation type.
+func NewStep(op Operation) Step {
+	name, ok := typeNames[reflect.TypeOf(op)]
+	if !ok {
+		panic(fmt.Sprintf("pipeline: unregistered operation type %T", op))
+	}
+	return Step{Name: name, Op: op}
+}
+
+func (s Step) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Op     string    `json:"op"`
+		Params Operation `json:"params"`
+	}{s.Name, s.Op})
+}
+
+func (s *Step) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Op     string          `json:"op"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	def, ok := registry[raw.Op]
+	if !ok {
+		return fmt.Errorf("unknown operation %q", raw.Op)
+	}
+
+	op := def.New()
+	if len(raw.Params) > 0 && string(raw.Params) != "null" {
+		dec := json.NewDecoder(bytes.NewReader(raw.Params))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(op); err != nil {
+			return fmt.Errorf("%s: %v", raw.Op, err)
+		}
+	}
+	s.Name, s.Op = raw.Op, op
+	return nil
+}
+
+// Pipeline is an ordered list of operations.
+type Pipeline []Step
+
+// Validate checks every step's parameters against its declared ranges and
+// its own Validate method.
+func (p Pipeline) Validate() error {
+	for i, step := range p {
+		if err := ValidateOperation(step.Op); err != nil {
+			return fmt.Errorf("operations[%d] (%s): %v", i, step.Name, err)
+		}
+	}
+	return nil
+}
+
+// ValidateOperation checks op's parameters against their declared ranges
+// and its own Validate method.
+func ValidateOperation(op Operation) error {
+	if err := validateParams(op); err != nil {
+		return err
+	}
+	if v, ok := op.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// Resolve loads what the operations refer to from env and fails if any of
+// it is missing, so that no part of an edit is silently left out. It is
+// called before Apply.
+func (p Pipeline) Resolve(env Env) error {
+	for i, step := range p {
+		if r, ok := step.Op.(Resolver); ok {
+			if err := r.Resolve(env); err != nil {
+				return fmt.Errorf("operations[%d] (%s): %w", i, step.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Scaled returns a copy of p for an image f times the original size.
+func (p Pipeline) Scaled(f float64) Pipeline {
+	scaled := make(Pipeline, len(p))
+	for i, step := range p {
+		scaled[i] = step
+		if s, ok := step.Op.(Scaler); ok && f != 1 {
+			scaled[i].Op = s.Scaled(f)
+		}
+	}
+	return scaled
+}
+
+// Apply runs the operations in order on a copy of src. It stops between
+// operations once ctx is done and returns ctx.Err().
+func (p Pipeline) Apply(ctx context.Context, src image.Image) (image.Image, error) {
+	img := imaging.Clone(src)
+	for _, step := range p {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		img = step.Op.Apply(img)
+	}
+	return img, nil
+}
@@ -0,0 +1,188 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func decode(data string) (Pipeline, error) {
+	var p Pipeline
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		return nil, err
+	}
+	return p, p.Validate()
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		json    string
+		wantErr string // empty when valid
+	}{
+		{"valid", `[
+			{"op": "crop", "params": {"x": 0.1, "y": 0, "width": 0.8, "height": 1, "aspect": "3:2"}},
+			{"op": "brightness", "params": {"value": 20}},
+			{"op": "flip", "params": {"direction": "vertical"}},
+			{"op": "vignette", "params": {"amount": -30, "midpoint": 40}}
+		]`, ""},
+		{"defaults", `[{"op": "contrast"}, {"op": "saturation", "params": null}]`, ""},
+		{"empty", `[]`, ""},
+		{"unknown operation", `[{"op": "sepia"}]`, `unknown operation "sepia"`},
+		{"unknown parameter", `[{"op": "brightness", "params": {"amount": 20}}]`, `unknown field "amount"`},
+		{"wrong type", `[{"op": "brightness", "params": {"value": "20"}}]`, "brightness:"},
+		{"above maximum", `[{"op": "brightness", "params": {"value": 101}}]`, "value must be between -100 and 100"},
+		{"below minimum", `[{"op": "crop", "params": {"x": -0.1, "width": 1, "height": 1}}]`, "x must be between 0 and 1"},
+		{"pointer out of range", `[{"op": "vignette", "params": {"amount": 50, "midpoint": 150}}]`, "midpoint must be between 0 and 100"},
+		{"not in enum", `[{"op": "flip", "params": {"direction": "diagonal"}}]`, "direction must be one of horizontal, vertical"},
+		{"aspect not in enum", `[{"op": "crop", "params": {"width": 1, "height": 1, "aspect": "7:5"}}]`, "aspect must be one of"},
+		{"index in error", `[{"op": "contrast"}, {"op": "brightness", "params": {"value": 500}}]`, "operations[1] (brightness)"},
+		{"crop outside", `[{"op": "crop", "params": {"x": 0.5, "width": 0.6, "height": 1}}]`, "crop must lie within the image"},
+		{"empty crop", `[{"op": "crop", "params": {"width": 0, "height": 1}}]`, "width and height must be positive"},
+		{"quarter turn", `[{"op": "rotate90", "params": {"angle": 45}}]`, "angle must be a multiple of 90"},
+		{"rotate background", `[{"op": "rotate", "params": {"angle": 10, "background": "white"}}]`, "operations[0] (rotate)"},
+		{"resize without size", `[{"op": "resize"}]`, "width or height is required"},
+		{"resize too large", `[{"op": "resize", "params": {"width": 20000}}]`, "width must be between 0 and 16384"},
+		{"perspective not convex", `[{"op": "perspective", "params": {"points": [[0, 0], [1, 1], [1, 0], [0, 1]]}}]`, "convex quadrilateral"},
+		{"local", `[{"op": "local", "params": {
+			"mask": {"type": "radial", "x": 0.5, "y": 0.5, "radius_x": 0.2, "radius_y": 0.3},
+			"operations": [{"op": "brightness", "params": {"value": 30}}]}}]`, ""},
+		{"local without operations", `[{"op": "local", "params": {
+			"mask": {"type": "radial", "radius_x": 0.2, "radius_y": 0.3}}}]`, "local adjustment needs operations"},
+		{"local crop", `[{"op": "local", "params": {
+			"mask": {"type": "radial", "radius_x": 0.2, "radius_y": 0.3},
+			"operations": [{"op": "crop", "params": {"width": 1, "height": 1}}]}}]`, "crop cannot be applied locally"},
+		{"local nested range", `[{"op": "local", "params": {
+			"mask": {"type": "radial", "radius_x": 0.2, "radius_y": 0.3},
+			"operations": [{"op": "contrast", "params": {"value": -200}}]}}]`, "value must be between -100 and 100"},
+		{"mask type", `[{"op": "local", "params": {
+			"mask": {"type": "lasso"},
+			"operations": [{"op": "contrast"}]}}]`, "type must be one of radial, linear, brush, stored"},
+		{"mask range", `[{"op": "local", "params": {
+			"mask": {"type": "radial", "radius_x": 3, "radius_y": 0.3},
+			"operations": [{"op": "contrast"}]}}]`, "radius_x must be between 0 and 2"},
+		{"stored mask without id", `[{"op": "local", "params": {
+			"mask": {"type": "stored"},
+			"operations": [{"op": "contrast"}]}}]`, "stored mask needs an id"},
+		{"stroke range", `[{"op": "local", "params": {
+			"mask": {"type": "brush", "strokes": [{"points": [[0.5, 0.5]], "size": 200}]},
+			"operations": [{"op": "contrast"}]}}]`, "strokes[0]: size must be between 0.01 and 100"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := decode(tc.json)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.wantErr != "" && err == nil:
+				t.Errorf("no error, want one containing %q", tc.wantErr)
+			case tc.wantErr != "" && !strings.Contains(err.Error(), tc.wantErr):
+				t.Errorf("error is %q, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestSchemaBoundsEnforced checks that every bound the published schema
+// declares for a number parameter is also enforced by validation.
+func TestSchemaBoundsEnforced(t *testing.T) {
+	for name, schema := range Schemas() {
+		properties, _ := schema.(map[string]any)["properties"].(map[string]any)
+		for param, s := range properties {
+			prop := s.(map[string]any)
+			if prop["type"] != "number" && prop["type"] != "integer" {
+				continue
+			}
+			for bound, beyond := range map[string]float64{"minimum": -1, "maximum": 1} {
+				limit, ok := prop[bound].(float64)
+				if !ok {
+					continue
+				}
+				data := fmt.Sprintf(`[{"op": %q, "params": {%q: %g}}]`, name, param, limit+beyond)
+				_, err := decode(data)
+				if err == nil || !strings.Contains(err.Error(), param) {
+					t.Errorf("%s: %s beyond its %s %g: got %v, want an error about %s", name, param, bound, limit, err, param)
+				}
+			}
+		}
+	}
+}
+
+func TestSchemas(t *testing.T) {
+	schemas := Schemas()
+	if names := Names(); len(schemas) != len(names) {
+		t.Fatalf("%d schemas for %d operations", len(schemas), len(names))
+	}
+	for _, name := range Names() {
+		schema, ok := schemas[name].(map[string]any)
+		if !ok {
+			t.Errorf("no schema for %s", name)
+			continue
+		}
+		if schema["type"] != "object" || schema["additionalProperties"] != false {
+			t.Errorf("%s: schema must be a closed object, got %v", name, schema)
+		}
+		if d, _ := schema["description"].(string); d == "" {
+			t.Errorf("%s: no description", name)
+		}
+	}
+
+	property := func(op, param string) map[string]any {
+		t.Helper()
+		properties := schemas[op].(map[string]any)["properties"].(map[string]any)
+		p, ok := properties[param].(map[string]any)
+		if !ok {
+			t.Fatalf("%s has no parameter %s in %v", op, param, properties)
+		}
+		return p
+	}
+	if p := property("brightness", "value"); p["type"] != "number" || p["minimum"] != -100.0 || p["maximum"] != 100.0 {
+		t.Errorf("brightness value is %v", p)
+	}
+	if p := property("resize", "width"); p["type"] != "integer" || p["maximum"] != 16384.0 {
+		t.Errorf("resize width is %v", p)
+	}
+	if p := property("flip", "direction"); !reflect.DeepEqual(p["enum"], []string{"horizontal", "vertical"}) {
+		t.Errorf("flip direction is %v", p)
+	}
+	if p := property("perspective", "points"); p["minItems"] != 4 || p["maxItems"] != 4 {
+		t.Errorf("perspective points is %v", p)
+	}
+	// Embedded parameters are flattened into the operation
+	if p := property("watermark", "opacity"); p["type"] != "number" {
+		t.Errorf("watermark opacity is %v", p)
+	}
+	nested := property("local", "operations")["items"].(map[string]any)["properties"].(map[string]any)["op"].(map[string]any)
+	if !slices.Equal(nested["enum"].([]string), Names()) {
+		t.Errorf("local operations may be %v, want every operation", nested["enum"])
+	}
+}
+
+func TestStepRoundTrip(t *testing.T) {
+	midpoint := 30.0
+	p := Pipeline{
+		NewStep(&Crop{X: 0.25, Width: 0.5, Height: 1, Aspect: "1:1"}),
+		NewStep(&Vignette{Amount: 40, Midpoint: &midpoint}),
+		NewStep(&Local{
+			Mask:       Mask{Type: "linear", X: 0, Y: 0, X2: 0, Y2: 1},
+			Operations: Pipeline{NewStep(&Brightness{Value: -20})},
+		}),
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decode(string(data))
+	if err != nil {
+		t.Fatalf("%s: %v", data, err)
+	}
+	again, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("round trip changed\n%s\nto\n%s", data, again)
+	}
+}
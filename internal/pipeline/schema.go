@@ -0,0 +1,181 @@
+package pipeline
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Parameters declare their constraints with struct tags next to the JSON
+// name, which serve both validation and the published schema:
+//
+//	Value     float64 `json:"value" min:"-100" max:"100"`
+//	Direction string  `json:"direction" enum:"horizontal,vertical"`
+
+// Schemas returns a JSON Schema of every operation's parameters, keyed by
+// operation name.
+func Schemas() map[string]any {
+	schemas := make(map[string]any, len(registry))
+	for name, def := range registry {
+		schema := typeSchema(reflect.TypeOf(def.New()))
+		schema["description"] = def.Description
+		schemas[name] = schema
+	}
+	return schemas
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(Step{}) {
+		// Nested operations, described by their own schemas
+		return map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"op":     map[string]any{"type": "string", "enum": Names()},
+				"params": map[string]any{"type": "object"},
+			},
+			"required":             []string{"op"},
+			"additionalProperties": false,
+		}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]any)
+		addProperties(t, properties)
+		return map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+	}
+	return map[string]any{}
+}
+
+func addProperties(t reflect.Type, properties map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			addProperties(f.Type, properties)
+			continue
+		}
+		name := jsonName(f)
+		if name == "-" {
+			continue
+		}
+		schema := typeSchema(f.Type)
+		if min, ok := floatTag(f, "min"); ok {
+			schema["minimum"] = min
+		}
+		if max, ok := floatTag(f, "max"); ok {
+			schema["maximum"] = max
+		}
+		if enum := f.Tag.Get("enum"); enum != "" {
+			schema["enum"] = strings.Split(enum, ",")
+		}
+		properties[name] = schema
+	}
+}
+
+// validateParams checks op's fields against their min, max and enum tags.
+func validateParams(op Operation) error {
+	v := reflect.ValueOf(op)
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	return validateStruct(v)
+}
+
+func validateStruct(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		for fv.Kind() == reflect.Pointer && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Pointer {
+			continue
+		}
+		if fv.Kind() == reflect.Struct {
+			if err := validateStruct(fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := jsonName(f)
+		var n float64
+		switch fv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			n = fv.Float()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(fv.Int())
+		case reflect.String:
+			if enum := f.Tag.Get("enum"); enum != "" && !contains(strings.Split(enum, ","), fv.String()) {
+				return fmt.Errorf("%s must be one of %s", name, strings.Trim(strings.ReplaceAll(enum, ",", ", "), ", "))
+			}
+			continue
+		default:
+			continue
+		}
+		min, hasMin := floatTag(f, "min")
+		max, hasMax := floatTag(f, "max")
+		switch {
+		case hasMin && hasMax && (n < min || n > max):
+			return fmt.Errorf("%s must be between %g and %g", name, min, max)
+		case hasMin && n < min:
+			return fmt.Errorf("%s must be at least %g", name, min)
+		case hasMax && n > max:
+			return fmt.Errorf("%s must be at most %g", name, max)
+		}
+	}
+	return nil
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func floatTag(f reflect.StructField, key string) (float64, bool) {
+	tag := f.Tag.Get(key)
+	if tag == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(tag, 64)
+	return v, err == nil
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package pipeline
+
+import (
+	"errors"
+	"goga/internal/adjust"
+	"goga/pkg/utils"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+func init() {
+	for _, def := range []Definition{
+		{"rotate90", "Rotate counter-clockwise in exact quarter turns without resampling", func() Operation { return &Rotate90{} }},
+		{"straighten", "Rotate by a small angle and crop away the empty corners", func() Operation { return &Straighten{} }},
+		{"perspective", "Map a quadrilateral, given as four corner points, onto the full frame", func() Operation { return &Perspective{} }},
+	} {
+		Register(def)
+	}
+}
+
+// cropAspects are the aspect ratio presets accepted by crop; "original"
+// keeps the ratio of the image being cropped.
+var cropAspects = map[string]float64{
+	"1:1": 1, "4:5": 4.0 / 5, "5:4": 5.0 / 4, "2:3": 2.0 / 3, "3:2": 3.0 / 2,
+	"3:4": 3.0 / 4, "4:3": 4.0 / 3, "9:16": 9.0 / 16, "16:9": 16.0 / 9,
+}
+
+// fitAspect shrinks r around its centre to the given width/height ratio.
+func fitAspect(r image.Rectangle, aspect float64) image.Rectangle {
+	w, h := r.Dx(), r.Dy()
+	if float64(w) > float64(h)*aspect {
+		w = max(1, int(math.Round(float64(h)*aspect)))
+	} else {
+		h = max(1, int(math.Round(float64(w)/aspect)))
+	}
+	x := r.Min.X + (r.Dx()-w)/2
+	y := r.Min.Y + (r.Dy()-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+type Rotate90 struct {
+	Angle int `json:"angle" min:"-270" max:"270"`
+}
+
+func (o *Rotate90) Validate() error {
+	if o.Angle%90 != 0 {
+		return errors.New("angle must be a multiple of 90")
+	}
+	return nil
+}
+
+func (o *Rotate90) Apply(img *image.NRGBA) *image.NRGBA {
+	switch (o.Angle%360 + 360) % 360 {
+	case 90:
+		return imaging.Rotate90(img)
+	case 180:
+		return imaging.Rotate180(img)
+	case 270:
+		return imaging.Rotate270(img)
+	}
+	return img
+}
+
+// Orientation reports whether p consists only of quarter turns and flips
+// and, if so, returns the EXIF orientation with the same effect, so JPEGs
+// can be reoriented without re-encoding.
+func (p Pipeline) Orientation() (int, bool) {
+	if len(p) == 0 {
+		return 0, false
+	}
+	orientation := 1
+	for _, step := range p {
+		var o int
+		switch op := step.Op.(type) {
+		case *Rotate90:
+			o = map[int]int{0: 1, 90: 8, 180: 3, 270: 6}[(op.Angle%360+360)%360]
+		case *Flip:
+			o = 2
+			if op.Direction == "vertical" {
+				o = 4
+			}
+		default:
+			return 0, false
+		}
+		orientation = utils.ComposeOrientation(orientation, o)
+	}
+	return orientation, true
+}
+
+type Straighten struct {
+	Angle float64 `json:"angle" min:"-45" max:"45"`
+}
+
+// Apply rotates and then crops the largest rectangle with the original
+// aspect ratio that lies entirely inside the rotated image.
+func (o *Straighten) Apply(img *image.NRGBA) *image.NRGBA {
+	if o.Angle == 0 {
+		return img
+	}
+	w, h := float64(img.Rect.Dx()), float64(img.Rect.Dy())
+	sin, cos := math.Abs(math.Sin(o.Angle*math.Pi/180)), math.Abs(math.Cos(o.Angle*math.Pi/180))
+	scale := math.Min(w/(w*cos+h*sin), h/(w*sin+h*cos))
+
+	rotated := imaging.Rotate(img, o.Angle, color.Transparent)
+	// Stay a pixel clear of the interpolated, partly transparent edges
+	cw := max(1, int(w*scale)-2)
+	ch := max(1, int(h*scale)-2)
+	x := (rotated.Rect.Dx() - cw) / 2
+	y := (rotated.Rect.Dy() - ch) / 2
+	return imaging.Crop(rotated, image.Rect(x, y, x+cw, y+ch))
+}
+
+// Perspective corrects keystone distortion. Points are the corners of the
+// region to straighten as fractions of the image size, in the order top
+// left, top right, bottom right, bottom left.
+type Perspective struct {
+	Points [4][2]float64 `json:"points"`
+}
+
+func (o *Perspective) Validate() error {
+	for _, p := range o.Points {
+		if p[0] < 0 || p[0] > 1 || p[1] < 0 || p[1] > 1 {
+			return errors.New("points must lie within the image (0..1)")
+		}
+	}
+	// The quadrilateral must be convex and clockwise in image coordinates
+	for i := range o.Points {
+		a, b, c := o.Points[i], o.Points[(i+1)%4], o.Points[(i+2)%4]
+		if (b[0]-a[0])*(c[1]-b[1])-(b[1]-a[1])*(c[0]-b[0]) <= 0 {
+			return errors.New("points must form a convex quadrilateral in the order top left, top right, bottom right, bottom left")
+		}
+	}
+	return nil
+}
+
+func (o *Perspective) Apply(img *image.NRGBA) *image.NRGBA {
+	w, h := float64(img.Rect.Dx()), float64(img.Rect.Dy())
+	var quad [4][2]float64
+	for i, p := range o.Points {
+		quad[i] = [2]float64{p[0] * w, p[1] * h}
+	}
+	dist := func(a, b [2]float64) float64 { return math.Hypot(b[0]-a[0], b[1]-a[1]) }
+	outW := min(maxDimension, max(1, int(math.Round(math.Max(dist(quad[0], quad[1]), dist(quad[3], quad[2]))))))
+	outH := min(maxDimension, max(1, int(math.Round(math.Max(dist(quad[0], quad[3]), dist(quad[1], quad[2]))))))
+
+	m, ok := homography([4][2]float64{{0, 0}, {float64(outW), 0}, {float64(outW), float64(outH)}, {0, float64(outH)}}, quad)
+	if !ok {
+		return img
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, outW, outH))
+	adjust.ForEachRow(dst, func(y int, row []uint8) {
+		fy := float64(y) + 0.5
+		for x := 0; x < outW; x++ {
+			fx := float64(x) + 0.5
+			d := m[6]*fx + m[7]*fy + 1
+			sx := (m[0]*fx + m[1]*fy + m[2]) / d
+			sy := (m[3]*fx + m[4]*fy + m[5]) / d
+			c := sampleBilinear(img, sx-0.5, sy-0.5)
+			copy(row[x*4:x*4+4], c[:])
+		}
+	})
+	return dst
+}
+
+// homography returns the projective transform mapping the four from points
+// onto the to points, as the first eight entries of a 3x3 matrix whose last
+// entry is 1.
+func homography(from, to [4][2]float64) ([8]float64, bool) {
+	var a [8][9]float64
+	for i := 0; i < 4; i++ {
+		x, y := from[i][0], from[i][1]
+		u, v := to[i][0], to[i][1]
+		a[2*i] = [9]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u}
+		a[2*i+1] = [9]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v}
+	}
+
+	// Gaussian elimination with partial pivoting
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for r := col + 1; r < 8; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return [8]float64{}, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for r := 0; r < 8; r++ {
+			if r == col {
+				continue
+			}
+			f := a[r][col] / a[col][col]
+			for k := col; k < 9; k++ {
+				a[r][k] -= f * a[col][k]
+			}
+		}
+	}
+
+	var m [8]float64
+	for i := range m {
+		m[i] = a[i][8] / a[i][i]
+	}
+	return m, true
+}
+
+// sampleBilinear interpolates img at (x, y) in pixel-centre coordinates,
+// clamping to the edges. Colours are weighted by alpha.
+func sampleBilinear(img *image.NRGBA, x, y float64) [4]uint8 {
+	maxX, maxY := img.Rect.Dx()-1, img.Rect.Dy()-1
+	x = math.Max(0, math.Min(float64(maxX), x))
+	y = math.Max(0, math.Min(float64(maxY), y))
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, maxX), min(y0+1, maxY)
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	var sum [4]float64
+	for _, s := range [4]struct {
+		x, y int
+		w    float64
+	}{
+		{x0, y0, (1 - fx) * (1 - fy)}, {x1, y0, fx * (1 - fy)},
+		{x0, y1, (1 - fx) * fy}, {x1, y1, fx * fy},
+	} {
+		i := s.y*img.Stride + s.x*4
+		a := float64(img.Pix[i+3]) * s.w
+		sum[0] += float64(img.Pix[i]) * a
+		sum[1] += float64(img.Pix[i+1]) * a
+		sum[2] += float64(img.Pix[i+2]) * a
+		sum[3] += a
+	}
+	if sum[3] == 0 {
+		return [4]uint8{}
+	}
+	return [4]uint8{
+		uint8(sum[0]/sum[3] + 0.5), uint8(sum[1]/sum[3] + 0.5), uint8(sum[2]/sum[3] + 0.5), uint8(sum[3] + 0.5),
+	}
+}
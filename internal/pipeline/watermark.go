@@ -0,0 +1,44 @@
+package pipeline
+
+import (
+	"goga/internal/watermark"
+	"image"
+)
+
+func init() {
+	Register(Definition{"watermark", "Overlay text or an uploaded logo, at a position or tiled", func() Operation { return &Watermark{} }})
+}
+
+type Watermark struct {
+	watermark.Spec
+
+	logo image.Image // loaded by Resolve
+}
+
+func (o *Watermark) Validate() error {
+	return o.Spec.Validate()
+}
+
+// Resolve loads the logo, so that a watermark is never silently left out.
+func (o *Watermark) Resolve(env Env) error {
+	if o.Logo == "" {
+		return nil
+	}
+	if env.Logos == nil {
+		return watermark.ErrLogoNotFound
+	}
+	logo, err := env.Logos.Open(o.Logo)
+	if err != nil {
+		return err
+	}
+	o.logo = logo
+	return nil
+}
+
+// Apply leaves out a logo watermark that was not resolved.
+func (o *Watermark) Apply(img *image.NRGBA) *image.NRGBA {
+	if o.Logo != "" && o.logo == nil {
+		return img
+	}
+	return o.Spec.Apply(img, o.logo)
+}
@@ -0,0 +1,265 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"goga/internal/models"
+	"math"
+)
+
+type FaceRepository struct {
+	db *sql.DB
+}
+
+func NewFaceRepository(db *sql.DB) *FaceRepository {
+	return &FaceRepository{db: db}
+}
+
+// ReplaceForImage swaps the stored faces of an image for a new detection run.
+func (r *FaceRepository) ReplaceForImage(imageID string, faces []models.Face) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM faces WHERE image_id = ?`, imageID); err != nil {
+		return err
+	}
+	for _, f := range faces {
+		_, err := tx.Exec(`
+			INSERT INTO faces (id, image_id, person_id, x, y, width, height, score, embedding, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, f.ID, f.ImageID, nullString(f.PersonID), f.X, f.Y, f.Width, f.Height, f.Score,
+			encodeEmbedding(f.Embedding), f.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *FaceRepository) DeleteForImage(imageID string) error {
+	_, err := r.db.Exec(`DELETE FROM faces WHERE image_id = ?`, imageID)
+	return err
+}
+
+const faceColumns = `id, image_id, person_id, x, y, width, height, score, embedding, created_at`
+
+func (r *FaceRepository) ListByImage(imageID string) ([]models.Face, error) {
+	return r.listFaces(`SELECT `+faceColumns+` FROM faces WHERE image_id = ? ORDER BY x`, imageID)
+}
+
+// ListAll returns every face including its embedding, for clustering.
+func (r *FaceRepository) ListAll() ([]models.Face, error) {
+	return r.listFaces(`SELECT ` + faceColumns + ` FROM faces ORDER BY created_at`)
+}
+
+func (r *FaceRepository) CreatePerson(person *models.Person) error {
+	_, err := r.db.Exec(`INSERT INTO people (id, name, created_at) VALUES (?, ?, ?)`,
+		person.ID, person.Name, person.CreatedAt)
+	return err
+}
+
+func (r *FaceRepository) SetPerson(faceID, personID string) error {
+	_, err := r.db.Exec(`UPDATE faces SET person_id = ? WHERE id = ?`, nullString(personID), faceID)
+	return err
+}
+
+// ApplyClusters replaces all people and assignments in one transaction.
+// assignments maps face IDs to person IDs.
+func (r *FaceRepository) ApplyClusters(people []models.Person, assignments map[string]string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE faces SET person_id = NULL`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM people`); err != nil {
+		return err
+	}
+	for _, p := range people {
+		if _, err := tx.Exec(`INSERT INTO people (id, name, created_at) VALUES (?, ?, ?)`, p.ID, p.Name, p.CreatedAt); err != nil {
+			return err
+		}
+	}
+	for faceID, personID := range assignments {
+		if _, err := tx.Exec(`UPDATE faces SET person_id = ? WHERE id = ?`, personID, faceID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListPeople returns people with at least one face, largest first.
+func (r *FaceRepository) ListPeople() ([]models.Person, error) {
+	rows, err := r.db.Query(`
+		SELECT p.id, p.name, p.created_at, COUNT(f.id), COUNT(DISTINCT f.image_id)
+		FROM people p JOIN faces f ON f.person_id = p.id
+		GROUP BY p.id
+		ORDER BY COUNT(f.id) DESC, p.created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var people []models.Person
+	for rows.Next() {
+		var p models.Person
+		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.FaceCount, &p.ImageCount); err != nil {
+			return nil, err
+		}
+		people = append(people, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range people {
+		if people[i].CoverFace, err = r.coverFace(people[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return people, nil
+}
+
+func (r *FaceRepository) GetPerson(id string) (*models.Person, error) {
+	var p models.Person
+	err := r.db.QueryRow(`
+		SELECT p.id, p.name, p.created_at, COUNT(f.id), COUNT(DISTINCT f.image_id)
+		FROM people p LEFT JOIN faces f ON f.person_id = p.id
+		WHERE p.id = ?
+		GROUP BY p.id
+	`, id).Scan(&p.ID, &p.Name, &p.CreatedAt, &p.FaceCount, &p.ImageCount)
+	if err != nil {
+		return nil, err
+	}
+	if p.CoverFace, err = r.coverFace(p.ID); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *FaceRepository) RenamePerson(id, name string) error {
+	result, err := r.db.Exec(`UPDATE people SET name = ? WHERE id = ?`, name, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ImageIDsForPerson returns the images a person appears in, newest first.
+func (r *FaceRepository) ImageIDsForPerson(personID string) ([]string, error) {
+	rows, err := r.db.Query(`
+		SELECT image_id FROM faces WHERE person_id = ?
+		GROUP BY image_id ORDER BY MAX(created_at) DESC
+	`, personID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteEmptyPeople removes unnamed people that no longer have faces.
+func (r *FaceRepository) DeleteEmptyPeople() error {
+	_, err := r.db.Exec(`
+		DELETE FROM people
+		WHERE name = '' AND id NOT IN (SELECT person_id FROM faces WHERE person_id IS NOT NULL)
+	`)
+	return err
+}
+
+func (r *FaceRepository) InitSchema() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS people (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS faces (
+			id TEXT PRIMARY KEY,
+			image_id TEXT NOT NULL,
+			person_id TEXT,
+			x INTEGER NOT NULL,
+			y INTEGER NOT NULL,
+			width INTEGER NOT NULL,
+			height INTEGER NOT NULL,
+			score REAL NOT NULL,
+			embedding BLOB NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_faces_image ON faces(image_id);
+		CREATE INDEX IF NOT EXISTS idx_faces_person ON faces(person_id);
+	`
+	_, err := r.db.Exec(query)
+	return err
+}
+
+func (r *FaceRepository) coverFace(personID string) (*models.Face, error) {
+	faces, err := r.listFaces(`SELECT `+faceColumns+` FROM faces WHERE person_id = ? ORDER BY score DESC LIMIT 1`, personID)
+	if err != nil || len(faces) == 0 {
+		return nil, err
+	}
+	return &faces[0], nil
+}
+
+func (r *FaceRepository) listFaces(query string, args ...interface{}) ([]models.Face, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var faces []models.Face
+	for rows.Next() {
+		var f models.Face
+		var personID sql.NullString
+		var embedding []byte
+		err := rows.Scan(&f.ID, &f.ImageID, &personID, &f.X, &f.Y, &f.Width, &f.Height, &f.Score,
+			&embedding, &f.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		f.PersonID = personID.String
+		f.Embedding = decodeEmbedding(embedding)
+		faces = append(faces, f)
+	}
+	return faces, rows.Err()
+}
+
+func encodeEmbedding(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(f))
+	}
+	return buf
+}
+
+func decodeEmbedding(buf []byte) []float32 {
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:]))
+	}
+	return v
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -2,7 +2,14 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
+	"goga/internal/geo"
 	"goga/internal/models"
+	"goga/pkg/utils"
+	"image/color"
+	"math"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -22,12 +29,97 @@ func (r *ImageRepository) Create(image *models.Image) error {
 	`
 	_, err := r.db.Exec(query, image.ID, image.Filename, image.OriginalName, image.Path,
 		image.Size, image.Width, image.Height, image.Format, image.CreatedAt, image.UpdatedAt)
-	return err
+	if err != nil {
+		return err
+	}
+	image.SetVersion()
+	return nil
+}
+
+const imageColumns = `id, filename, original_name, path, size, width, height, format, created_at, updated_at,
+	caption, tags, alt_text, described_at, latitude, longitude, country, city, title, copyright,
+	placeholder, blurhash, palette, user_caption, keywords`
+
+// ImageFilter narrows a listing. Zero values match every image.
+type ImageFilter struct {
+	Bounds    *Bounds // only geotagged images inside the box
+	Near      *Circle // only geotagged images within the radius
+	Country   string
+	City      string
+	Geotagged bool
+	Color     *ColorMatch // only images with a dominant colour close to it
+}
+
+// Bounds is a lat/lon box. MinLon > MaxLon denotes a box crossing the
+// antimeridian.
+type Bounds struct {
+	MinLat, MinLon, MaxLat, MaxLon float64
+}
+
+type Circle struct {
+	Latitude, Longitude float64
+	Radius              float64 // km
+}
+
+// ColorMatch selects images with a palette colour within Tolerance, in
+// CIE76 delta E, of Color.
+type ColorMatch struct {
+	Color     color.NRGBA
+	Tolerance float64
+}
+
+// matches reports whether any colour of palette is close enough.
+func (m *ColorMatch) matches(palette []models.PaletteColor) bool {
+	for _, p := range palette {
+		c, err := utils.ParseHexColor(p.Color)
+		if err == nil && utils.ColorDistance(m.Color, c) <= m.Tolerance {
+			return true
+		}
+	}
+	return false
 }
 
 func (r *ImageRepository) GetAll() ([]models.Image, error) {
-	query := `SELECT id, filename, original_name, path, size, width, height, format, created_at, updated_at FROM images ORDER BY created_at DESC`
-	rows, err := r.db.Query(query)
+	return r.List(ImageFilter{})
+}
+
+// List returns images matching filter, newest first.
+func (r *ImageRepository) List(filter ImageFilter) ([]models.Image, error) {
+	var where []string
+	var args []interface{}
+	if filter.Geotagged {
+		where = append(where, "latitude IS NOT NULL")
+	}
+	if b := filter.Bounds; b != nil {
+		where = append(where, boundsClause(b))
+		args = append(args, b.MinLat, b.MaxLat, b.MinLon, b.MaxLon)
+	}
+	if n := filter.Near; n != nil {
+		// Narrow to the enclosing box in SQL and check the distance below
+		b := n.bounds()
+		where = append(where, boundsClause(&b))
+		args = append(args, b.MinLat, b.MaxLat, b.MinLon, b.MaxLon)
+	}
+	if filter.Country != "" {
+		where = append(where, "country = ? COLLATE NOCASE")
+		args = append(args, filter.Country)
+	}
+	if filter.City != "" {
+		where = append(where, "city = ? COLLATE NOCASE")
+		args = append(args, filter.City)
+	}
+	if filter.Color != nil {
+		// Only analysed images can match; distances are checked below
+		where = append(where, "palette != '[]'")
+	}
+
+	query := `SELECT ` + imageColumns + ` FROM images`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -35,28 +127,138 @@ func (r *ImageRepository) GetAll() ([]models.Image, error) {
 
 	var images []models.Image
 	for rows.Next() {
-		var img models.Image
-		err := rows.Scan(&img.ID, &img.Filename, &img.OriginalName, &img.Path,
-			&img.Size, &img.Width, &img.Height, &img.Format, &img.CreatedAt, &img.UpdatedAt)
+		img, err := scanImage(rows)
 		if err != nil {
 			return nil, err
 		}
-		images = append(images, img)
+		if n := filter.Near; n != nil && geo.Distance(n.Latitude, n.Longitude, *img.Latitude, *img.Longitude) > n.Radius {
+			continue
+		}
+		if m := filter.Color; m != nil && !m.matches(img.Palette) {
+			continue
+		}
+		images = append(images, *img)
+	}
+	return images, rows.Err()
+}
+
+func boundsClause(b *Bounds) string {
+	if b.MinLon > b.MaxLon {
+		return "latitude BETWEEN ? AND ? AND (longitude >= ? OR longitude <= ?)"
+	}
+	return "latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?"
+}
+
+// bounds returns the box enclosing the circle.
+func (c *Circle) bounds() Bounds {
+	dLat := c.Radius / 111.0
+	b := Bounds{MinLat: c.Latitude - dLat, MaxLat: c.Latitude + dLat, MinLon: -180, MaxLon: 180}
+	cos := math.Cos(c.Latitude * math.Pi / 180)
+	if b.MinLat > -90 && b.MaxLat < 90 && cos > 0 {
+		if dLon := dLat / cos; dLon < 180 {
+			b.MinLon = wrapLongitude(c.Longitude - dLon)
+			b.MaxLon = wrapLongitude(c.Longitude + dLon)
+		}
+	}
+	return b
+}
+
+func wrapLongitude(lon float64) float64 {
+	if lon < -180 {
+		return lon + 360
+	}
+	if lon > 180 {
+		return lon - 360
 	}
-	return images, nil
+	return lon
 }
 
 func (r *ImageRepository) GetByID(id string) (*models.Image, error) {
-	query := `SELECT id, filename, original_name, path, size, width, height, format, created_at, updated_at FROM images WHERE id = ?`
-	row := r.db.QueryRow(query, id)
+	query := `SELECT ` + imageColumns + ` FROM images WHERE id = ?`
+	return scanImage(r.db.QueryRow(query, id))
+}
 
-	var img models.Image
-	err := row.Scan(&img.ID, &img.Filename, &img.OriginalName, &img.Path,
-		&img.Size, &img.Width, &img.Height, &img.Format, &img.CreatedAt, &img.UpdatedAt)
+// SetDescription stores AI-generated caption, tags and alt text.
+func (r *ImageRepository) SetDescription(id string, caption string, tags []string, altText string, describedAt time.Time) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	tagsJSON, err := json.Marshal(tags)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &img, nil
+	query := `UPDATE images SET caption = ?, tags = ?, alt_text = ?, described_at = ? WHERE id = ?`
+	_, err = r.db.Exec(query, caption, string(tagsJSON), altText, describedAt, id)
+	return err
+}
+
+// UpdateMetadata stores the user-editable descriptive fields. They have
+// columns of their own so that describe jobs cannot overwrite them.
+func (r *ImageRepository) UpdateMetadata(image *models.Image) error {
+	if image.Keywords == nil {
+		image.Keywords = []string{}
+	}
+	keywordsJSON, err := json.Marshal(image.Keywords)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE images SET title = ?, user_caption = ?, copyright = ?, keywords = ? WHERE id = ?`
+	_, err = r.db.Exec(query, image.Title, image.UserCaption, image.Copyright, string(keywordsJSON), image.ID)
+	return err
+}
+
+// SetLocation stores a position and its reverse geocoded place. Nil
+// coordinates clear the location.
+func (r *ImageRepository) SetLocation(id string, latitude, longitude *float64, country, city string) error {
+	query := `UPDATE images SET latitude = ?, longitude = ?, country = ?, city = ? WHERE id = ?`
+	result, err := r.db.Exec(query, latitude, longitude, country, city, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetPlaceholders stores the placeholders and palette derived from the
+// image file.
+func (r *ImageRepository) SetPlaceholders(image *models.Image) error {
+	paletteJSON, err := marshalPalette(image)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE images SET placeholder = ?, blurhash = ?, palette = ? WHERE id = ?`
+	_, err = r.db.Exec(query, image.Placeholder, image.BlurHash, paletteJSON, image.ID)
+	return err
+}
+
+func marshalPalette(image *models.Image) (string, error) {
+	if image.Palette == nil {
+		image.Palette = []models.PaletteColor{}
+	}
+	paletteJSON, err := json.Marshal(image.Palette)
+	return string(paletteJSON), err
+}
+
+// Update stores the file attributes of an image after it has been rewritten.
+func (r *ImageRepository) Update(image *models.Image) error {
+	paletteJSON, err := marshalPalette(image)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE images SET filename = ?, path = ?, size = ?, width = ?, height = ?, format = ?, updated_at = ?,
+			placeholder = ?, blurhash = ?, palette = ?
+		WHERE id = ?
+	`
+	_, err = r.db.Exec(query, image.Filename, image.Path, image.Size, image.Width, image.Height,
+		image.Format, image.UpdatedAt, image.Placeholder, image.BlurHash, paletteJSON, image.ID)
+	if err != nil {
+		return err
+	}
+	image.SetVersion()
+	return nil
 }
 
 func (r *ImageRepository) Delete(id string) error {
@@ -80,6 +282,73 @@ func (r *ImageRepository) InitSchema() error {
 			updated_at DATETIME NOT NULL
 		)
 	`
-	_, err := r.db.Exec(query)
+	if _, err := r.db.Exec(query); err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema
+	err := addColumns(r.db, "images", []column{
+		{"caption", "TEXT NOT NULL DEFAULT ''"},
+		{"tags", "TEXT NOT NULL DEFAULT '[]'"},
+		{"alt_text", "TEXT NOT NULL DEFAULT ''"},
+		{"described_at", "DATETIME"},
+		{"latitude", "REAL"},
+		{"longitude", "REAL"},
+		{"country", "TEXT NOT NULL DEFAULT ''"},
+		{"city", "TEXT NOT NULL DEFAULT ''"},
+		{"title", "TEXT NOT NULL DEFAULT ''"},
+		{"copyright", "TEXT NOT NULL DEFAULT ''"},
+		{"placeholder", "TEXT NOT NULL DEFAULT ''"},
+		{"blurhash", "TEXT NOT NULL DEFAULT ''"},
+		{"palette", "TEXT NOT NULL DEFAULT '[]'"},
+		{"user_caption", "TEXT NOT NULL DEFAULT ''"},
+		{"keywords", "TEXT NOT NULL DEFAULT '[]'"},
+	})
+	if err != nil {
+		return err
+	}
+
+	// User metadata used to share the AI columns; on images that were never
+	// described it can only have come from the user
+	_, err = r.db.Exec(`
+		UPDATE images SET user_caption = caption, keywords = tags, caption = '', tags = '[]'
+		WHERE described_at IS NULL AND user_caption = '' AND keywords = '[]' AND (caption != '' OR tags != '[]')
+	`)
 	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanImage(row rowScanner) (*models.Image, error) {
+	var img models.Image
+	var tags, palette, keywords string
+	var describedAt sql.NullTime
+	var latitude, longitude sql.NullFloat64
+	err := row.Scan(&img.ID, &img.Filename, &img.OriginalName, &img.Path,
+		&img.Size, &img.Width, &img.Height, &img.Format, &img.CreatedAt, &img.UpdatedAt,
+		&img.Caption, &tags, &img.AltText, &describedAt,
+		&latitude, &longitude, &img.Country, &img.City, &img.Title, &img.Copyright,
+		&img.Placeholder, &img.BlurHash, &palette, &img.UserCaption, &keywords)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(tags), &img.Tags); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(palette), &img.Palette); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(keywords), &img.Keywords); err != nil {
+		return nil, err
+	}
+	if describedAt.Valid {
+		img.DescribedAt = &describedAt.Time
+	}
+	if latitude.Valid && longitude.Valid {
+		img.Latitude, img.Longitude = &latitude.Float64, &longitude.Float64
+	}
+	img.SetVersion()
+	return &img, nil
 }
\ No newline at end of file
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"database/sql"
+	"goga/internal/models"
+)
+
+type MaskRepository struct {
+	db *sql.DB
+}
+
+func NewMaskRepository(db *sql.DB) *MaskRepository {
+	return &MaskRepository{db: db}
+}
+
+func (r *MaskRepository) Create(mask *models.Mask) error {
+	_, err := r.db.Exec(`
+		INSERT INTO masks (id, image_id, image_version, subject, provider, width, height, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, mask.ID, mask.ImageID, mask.ImageVersion, mask.Subject, mask.Provider, mask.Width, mask.Height, mask.CreatedAt)
+	return err
+}
+
+func (r *MaskRepository) Delete(id string) error {
+	result, err := r.db.Exec(`DELETE FROM masks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const maskColumns = `id, image_id, image_version, subject, provider, width, height, created_at`
+
+func (r *MaskRepository) GetByID(id string) (*models.Mask, error) {
+	return scanMask(r.db.QueryRow(`SELECT `+maskColumns+` FROM masks WHERE id = ?`, id))
+}
+
+// ListByImage returns the masks of an image, newest first.
+func (r *MaskRepository) ListByImage(imageID string) ([]models.Mask, error) {
+	rows, err := r.db.Query(`SELECT `+maskColumns+` FROM masks WHERE image_id = ? ORDER BY created_at DESC`, imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	masks := []models.Mask{}
+	for rows.Next() {
+		mask, err := scanMask(rows)
+		if err != nil {
+			return nil, err
+		}
+		masks = append(masks, *mask)
+	}
+	return masks, rows.Err()
+}
+
+func (r *MaskRepository) InitSchema() error {
+	_, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS masks (
+			id TEXT PRIMARY KEY,
+			image_id TEXT NOT NULL,
+			image_version TEXT NOT NULL,
+			subject TEXT NOT NULL DEFAULT '',
+			provider TEXT NOT NULL,
+			width INTEGER NOT NULL,
+			height INTEGER NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_masks_image_id ON masks(image_id);
+	`)
+	return err
+}
+
+func scanMask(row rowScanner) (*models.Mask, error) {
+	var mask models.Mask
+	err := row.Scan(&mask.ID, &mask.ImageID, &mask.ImageVersion, &mask.Subject, &mask.Provider,
+		&mask.Width, &mask.Height, &mask.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &mask, nil
+}
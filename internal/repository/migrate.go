@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type column struct {
+	name       string
+	definition string
+}
+
+// addColumns adds any of the given columns that the table does not have yet,
+// so that databases created by older versions keep working.
+func addColumns(db *sql.DB, table string, columns []column) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid        int
+			name, typ  string
+			notNull    bool
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &defaultVal, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, col := range columns {
+		if existing[col.name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, col.name, col.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"database/sql"
+	"goga/internal/models"
+)
+
+type PresetRepository struct {
+	db *sql.DB
+}
+
+func NewPresetRepository(db *sql.DB) *PresetRepository {
+	return &PresetRepository{db: db}
+}
+
+func (r *PresetRepository) Create(preset *models.Preset) error {
+	_, err := r.db.Exec(`
+		INSERT INTO presets (id, name, owner, edit, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, preset.ID, preset.Name, preset.Owner, string(preset.Edit), preset.CreatedAt, preset.UpdatedAt)
+	return err
+}
+
+func (r *PresetRepository) Update(preset *models.Preset) error {
+	result, err := r.db.Exec(`UPDATE presets SET name = ?, owner = ?, edit = ?, updated_at = ? WHERE id = ?`,
+		preset.Name, preset.Owner, string(preset.Edit), preset.UpdatedAt, preset.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *PresetRepository) Delete(id string) error {
+	result, err := r.db.Exec(`DELETE FROM presets WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const presetColumns = `id, name, owner, edit, created_at, updated_at`
+
+func (r *PresetRepository) GetByID(id string) (*models.Preset, error) {
+	return scanPreset(r.db.QueryRow(`SELECT `+presetColumns+` FROM presets WHERE id = ?`, id))
+}
+
+// GetByName finds a preset of the given owner ("" for global).
+func (r *PresetRepository) GetByName(owner, name string) (*models.Preset, error) {
+	query := `SELECT ` + presetColumns + ` FROM presets WHERE owner = ? AND name = ? COLLATE NOCASE`
+	return scanPreset(r.db.QueryRow(query, owner, name))
+}
+
+// List returns global presets plus those of owner, sorted by name.
+func (r *PresetRepository) List(owner string) ([]models.Preset, error) {
+	rows, err := r.db.Query(`
+		SELECT `+presetColumns+` FROM presets
+		WHERE owner = '' OR owner = ?
+		ORDER BY name COLLATE NOCASE, owner
+	`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var presets []models.Preset
+	for rows.Next() {
+		preset, err := scanPreset(rows)
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, *preset)
+	}
+	return presets, rows.Err()
+}
+
+func (r *PresetRepository) InitSchema() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS presets (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			owner TEXT NOT NULL DEFAULT '',
+			edit TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_presets_owner_name ON presets(owner, name COLLATE NOCASE);
+	`
+	_, err := r.db.Exec(query)
+	return err
+}
+
+func scanPreset(row rowScanner) (*models.Preset, error) {
+	var preset models.Preset
+	var edit string
+	err := row.Scan(&preset.ID, &preset.Name, &preset.Owner, &edit, &preset.CreatedAt, &preset.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	preset.Edit = []byte(edit)
+	return &preset, nil
+}
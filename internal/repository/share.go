@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"database/sql"
+	"goga/internal/models"
+	"time"
+)
+
+type ShareRepository struct {
+	db *sql.DB
+}
+
+func NewShareRepository(db *sql.DB) *ShareRepository {
+	return &ShareRepository{db: db}
+}
+
+func (r *ShareRepository) Create(share *models.Share) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO shares (id, token, title, password_hash, expires_at, max_views, views, allow_download, metadata,
+			watermark, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.Exec(query, share.ID, share.Token, share.Title, share.PasswordHash, share.ExpiresAt,
+		share.MaxViews, share.Views, share.AllowDownload, share.Metadata, string(share.Watermark), share.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	for i, imageID := range share.ImageIDs {
+		_, err = tx.Exec(`INSERT INTO share_images (share_id, image_id, position) VALUES (?, ?, ?)`,
+			share.ID, imageID, i)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const shareColumns = `id, token, title, password_hash, expires_at, max_views, views, allow_download, metadata,
+	watermark, created_at, revoked_at`
+
+func (r *ShareRepository) GetByID(id string) (*models.Share, error) {
+	return r.getOne(`SELECT `+shareColumns+` FROM shares WHERE id = ?`, id)
+}
+
+func (r *ShareRepository) GetByToken(token string) (*models.Share, error) {
+	return r.getOne(`SELECT `+shareColumns+` FROM shares WHERE token = ?`, token)
+}
+
+// ListActive returns links that are not revoked or expired, newest first.
+// When imageID is non-empty only links containing that image are returned.
+func (r *ShareRepository) ListActive(imageID string) ([]models.Share, error) {
+	query := `SELECT ` + shareColumns + ` FROM shares WHERE revoked_at IS NULL`
+	var args []interface{}
+	if imageID != "" {
+		query += ` AND id IN (SELECT share_id FROM share_images WHERE image_id = ?)`
+		args = append(args, imageID)
+	}
+	query += ` ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var shares []models.Share
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		if share.Active(now) {
+			shares = append(shares, *share)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range shares {
+		if shares[i].ImageIDs, err = r.imageIDs(shares[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return shares, nil
+}
+
+// RecordView increments the view counter unless max_views has been reached.
+// It reports whether the view was counted.
+func (r *ShareRepository) RecordView(id string) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE shares SET views = views + 1
+		WHERE id = ? AND (max_views = 0 OR views < max_views)
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// StartView counts a view like RecordView and stores it as viewID, which
+// allows up to maxFetches file fetches until expiresAt. It reports false if
+// the view limit has been reached.
+func (r *ShareRepository) StartView(shareID, viewID string, expiresAt time.Time, maxFetches int) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE shares SET views = views + 1
+		WHERE id = ? AND (max_views = 0 OR views < max_views)
+	`, shareID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	now := time.Now()
+	if _, err := tx.Exec(`DELETE FROM share_views WHERE expires_at < ?`, now); err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO share_views (id, share_id, fetches, max_fetches, expires_at, created_at)
+		VALUES (?, ?, 0, ?, ?, ?)
+	`, viewID, shareID, maxFetches, expiresAt, now)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RecordFetch counts a file fetch against a view. It reports false if the
+// view does not belong to the share, has expired or has no fetches left.
+func (r *ShareRepository) RecordFetch(shareID, viewID string) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE share_views SET fetches = fetches + 1
+		WHERE id = ? AND share_id = ? AND expires_at > ? AND fetches < max_fetches
+	`, viewID, shareID, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (r *ShareRepository) Revoke(id string) error {
+	result, err := r.db.Exec(`UPDATE shares SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *ShareRepository) InitSchema() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS shares (
+			id TEXT PRIMARY KEY,
+			token TEXT NOT NULL UNIQUE,
+			title TEXT NOT NULL DEFAULT '',
+			password_hash TEXT NOT NULL DEFAULT '',
+			expires_at DATETIME,
+			max_views INTEGER NOT NULL DEFAULT 0,
+			views INTEGER NOT NULL DEFAULT 0,
+			allow_download BOOLEAN NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			revoked_at DATETIME
+		);
+		CREATE TABLE IF NOT EXISTS share_images (
+			share_id TEXT NOT NULL REFERENCES shares(id) ON DELETE CASCADE,
+			image_id TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (share_id, image_id)
+		);
+		CREATE TABLE IF NOT EXISTS share_views (
+			id TEXT PRIMARY KEY,
+			share_id TEXT NOT NULL REFERENCES shares(id) ON DELETE CASCADE,
+			fetches INTEGER NOT NULL DEFAULT 0,
+			max_fetches INTEGER NOT NULL,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+	`
+	if _, err := r.db.Exec(query); err != nil {
+		return err
+	}
+
+	// Existing links default to hiding GPS positions
+	return addColumns(r.db, "shares", []column{
+		{"metadata", "TEXT NOT NULL DEFAULT 'strip_gps'"},
+		{"watermark", "TEXT NOT NULL DEFAULT ''"},
+	})
+}
+
+func (r *ShareRepository) getOne(query string, arg string) (*models.Share, error) {
+	share, err := scanShare(r.db.QueryRow(query, arg))
+	if err != nil {
+		return nil, err
+	}
+	if share.ImageIDs, err = r.imageIDs(share.ID); err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+func (r *ShareRepository) imageIDs(shareID string) ([]string, error) {
+	rows, err := r.db.Query(`SELECT image_id FROM share_images WHERE share_id = ? ORDER BY position`, shareID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func scanShare(row rowScanner) (*models.Share, error) {
+	var share models.Share
+	var expiresAt, revokedAt sql.NullTime
+	var watermark string
+	err := row.Scan(&share.ID, &share.Token, &share.Title, &share.PasswordHash, &expiresAt,
+		&share.MaxViews, &share.Views, &share.AllowDownload, &share.Metadata, &watermark, &share.CreatedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if watermark != "" {
+		share.Watermark = []byte(watermark)
+	}
+	if expiresAt.Valid {
+		share.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		share.RevokedAt = &revokedAt.Time
+	}
+	return &share, nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"database/sql"
+	"goga/internal/models"
+)
+
+type WatermarkRepository struct {
+	db *sql.DB
+}
+
+func NewWatermarkRepository(db *sql.DB) *WatermarkRepository {
+	return &WatermarkRepository{db: db}
+}
+
+func (r *WatermarkRepository) Create(watermark *models.Watermark) error {
+	_, err := r.db.Exec(`INSERT INTO watermarks (id, name, spec, created_at) VALUES (?, ?, ?, ?)`,
+		watermark.ID, watermark.Name, string(watermark.Spec), watermark.CreatedAt)
+	return err
+}
+
+func (r *WatermarkRepository) Delete(id string) error {
+	result, err := r.db.Exec(`DELETE FROM watermarks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *WatermarkRepository) GetByID(id string) (*models.Watermark, error) {
+	return scanWatermark(r.db.QueryRow(`SELECT id, name, spec, created_at FROM watermarks WHERE id = ?`, id))
+}
+
+// List returns all saved watermarks sorted by name.
+func (r *WatermarkRepository) List() ([]models.Watermark, error) {
+	rows, err := r.db.Query(`SELECT id, name, spec, created_at FROM watermarks ORDER BY name COLLATE NOCASE`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	watermarks := []models.Watermark{}
+	for rows.Next() {
+		watermark, err := scanWatermark(rows)
+		if err != nil {
+			return nil, err
+		}
+		watermarks = append(watermarks, *watermark)
+	}
+	return watermarks, rows.Err()
+}
+
+func (r *WatermarkRepository) InitSchema() error {
+	_, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS watermarks (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			spec TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+func scanWatermark(row rowScanner) (*models.Watermark, error) {
+	var watermark models.Watermark
+	var spec string
+	if err := row.Scan(&watermark.ID, &watermark.Name, &spec, &watermark.CreatedAt); err != nil {
+		return nil, err
+	}
+	watermark.Spec = []byte(spec)
+	return &watermark, nil
+}
@@ -1,11 +1,21 @@
 package server
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"goga/internal/ai"
+	"goga/internal/config"
+	"goga/internal/faces"
+	"goga/internal/geo"
 	"goga/internal/handlers"
+	"goga/internal/jobs"
 	"goga/internal/repository"
+	"goga/internal/watermark"
+	"goga/pkg/utils"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,13 +23,26 @@ import (
 type Server struct {
 	router        *gin.Engine
 	db            *sql.DB
-	uploadDir     string
+	config        *config.Config
 	configHandler *handlers.ConfigHandler
+	jobs          *jobs.Manager
 }
 
-func New(dbPath, uploadDir string) (*Server, error) {
-	// Initialize database
-	db, err := sql.Open("sqlite3", dbPath)
+func New(cfg *config.Config) (*Server, error) {
+	dbPath, uploadDir := cfg.DBPath, cfg.UploadDir
+
+	signingKey := []byte(cfg.URLSigningKey)
+	if len(signingKey) == 0 {
+		// Signed URLs will not survive a restart without a configured key
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			return nil, err
+		}
+	}
+
+	// Initialize database. Foreign keys are off in SQLite unless enabled
+	// per connection, which the DSN does for every connection of the pool
+	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
 	if err != nil {
 		return nil, err
 	}
@@ -29,6 +52,26 @@ func New(dbPath, uploadDir string) (*Server, error) {
 	if err := imageRepo.InitSchema(); err != nil {
 		return nil, err
 	}
+	shareRepo := repository.NewShareRepository(db)
+	if err := shareRepo.InitSchema(); err != nil {
+		return nil, err
+	}
+	faceRepo := repository.NewFaceRepository(db)
+	if err := faceRepo.InitSchema(); err != nil {
+		return nil, err
+	}
+	presetRepo := repository.NewPresetRepository(db)
+	if err := presetRepo.InitSchema(); err != nil {
+		return nil, err
+	}
+	watermarkRepo := repository.NewWatermarkRepository(db)
+	if err := watermarkRepo.InitSchema(); err != nil {
+		return nil, err
+	}
+	maskRepo := repository.NewMaskRepository(db)
+	if err := maskRepo.InitSchema(); err != nil {
+		return nil, err
+	}
 
 	// Create upload directory
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
@@ -36,11 +79,61 @@ func New(dbPath, uploadDir string) (*Server, error) {
 	}
 
 	// Initialize handlers
-	configHandler := handlers.NewConfigHandler()
+	configHandler := handlers.NewConfigHandler(cfg.SettingsPath)
 	configHandler.LoadConfig()
-	imageHandler := handlers.NewImageHandler(imageRepo, uploadDir)
-	editHandler := handlers.NewEditHandler(imageRepo, uploadDir)
+	aiProvider, err := ai.New(cfg.AIProvider, cfg.AIModel, configHandler.APIKey)
+	if err != nil {
+		return nil, err
+	}
+	// ai_model names the describe model; segmentation uses its own default
+	segmenter, err := ai.NewSegmenter(cfg.Segmenter, "", configHandler.APIKey)
+	if err != nil {
+		return nil, err
+	}
+	jobManager := jobs.NewManager(cfg.JobWorkers)
+	faceDetector, err := faces.NewPigoDetector()
+	if err != nil {
+		return nil, err
+	}
+	geocoder := geo.Bundled()
+	if cfg.GeoNamesPath != "" {
+		if geocoder, err = geo.LoadGeoNames(cfg.GeoNamesPath); err != nil {
+			return nil, err
+		}
+	}
+
+	logos, err := watermark.NewLogos(filepath.Join(uploadDir, "watermarks"))
+	if err != nil {
+		return nil, err
+	}
+
+	imageHandler, err := handlers.NewImageHandler(imageRepo, watermarkRepo, logos, uploadDir, signingKey, cfg.Images)
+	if err != nil {
+		return nil, err
+	}
+	segmentHandler := handlers.NewSegmentHandler(imageRepo, imageHandler, maskRepo, segmenter, uploadDir)
+	editHandler, err := handlers.NewEditHandler(imageRepo, presetRepo, watermarkRepo, segmentHandler, logos, uploadDir, jobManager, cfg.Images)
+	if err != nil {
+		return nil, err
+	}
 	webHandler := handlers.NewWebHandler(imageRepo)
+	shareHandler := handlers.NewShareHandler(shareRepo, imageRepo, watermarkRepo, imageHandler)
+	aiHandler := handlers.NewAIHandler(imageRepo, aiProvider, jobManager)
+	jobHandler := handlers.NewJobHandler(jobManager)
+	faceHandler := handlers.NewFaceHandler(faceRepo, imageRepo, faceDetector, faces.NewLBPEmbedder(), jobManager)
+	geoHandler := handlers.NewGeoHandler(imageRepo, geocoder, jobManager)
+	presetHandler := handlers.NewPresetHandler(presetRepo)
+	watermarkHandler := handlers.NewWatermarkHandler(watermarkRepo, logos)
+
+	imageHandler.OnUpload(geoHandler.LocateOnUpload)
+	imageHandler.OnUpload(aiHandler.DescribeOnUpload)
+	imageHandler.OnUpload(faceHandler.DetectOnUpload)
+	imageHandler.OnUpload(editHandler.PlaceholderOnUpload)
+	if cfg.OptimizeUploads {
+		imageHandler.OnUpload(editHandler.OptimizeOnUpload(utils.OptimizeOptions{}))
+	}
+	imageHandler.OnDelete(faceHandler.DeleteForImage)
+	imageHandler.OnDelete(segmentHandler.DeleteForImage)
 
 	// Setup router
 	router := gin.Default()
@@ -51,18 +144,75 @@ func New(dbPath, uploadDir string) (*Server, error) {
 	router.GET("/", webHandler.Dashboard)
 	router.GET("/image/:id", webHandler.ImageDetail)
 
+	// Public share routes
+	router.GET("/s/:token", shareHandler.Gallery)
+	router.POST("/s/:token", shareHandler.Gallery)
+	router.GET("/s/:token/images/:id", shareHandler.ServeFile)
+
+	// Signed image URLs
+	router.GET("/signed/images/:id", imageHandler.ServeSignedImage)
+
 	// API routes
 	api := router.Group("/api")
 	{
 		api.GET("/images", imageHandler.GetImages)
+		api.GET("/images/compare", editHandler.Compare)
+		api.GET("/images/geo", geoHandler.GetGeoJSON)
+		api.POST("/images/geo/scan", geoHandler.ScanLocations)
+		api.POST("/images/placeholders/scan", editHandler.ScanPlaceholders)
 		api.GET("/images/:id", imageHandler.GetImage)
 		api.POST("/images/upload", imageHandler.UploadImage)
 		api.POST("/images/:id/convert", imageHandler.ConvertImage)
+		api.POST("/images/:id/optimize", editHandler.OptimizeImage)
 		api.DELETE("/images/:id", imageHandler.DeleteImage)
 		api.GET("/images/:id/file", imageHandler.ServeImage)
+		api.GET("/images/:id/download", imageHandler.DownloadImage)
+		api.PUT("/images/:id/metadata", imageHandler.UpdateMetadata)
+		api.GET("/images/:id/v/:version", imageHandler.ServeVersionedImage)
+		api.GET("/images/:id/signed-url", imageHandler.SignedURL)
+		api.GET("/images/:id/render", editHandler.Render)
+		api.GET("/images/:id/srcset", editHandler.Srcset)
+		api.GET("/images/:id/histogram", editHandler.GetHistogram)
+		api.GET("/edit/operations", editHandler.GetOperations)
+		api.POST("/images/batch/edit", editHandler.BatchEdit)
+		api.POST("/images/batch/optimize", editHandler.BatchOptimize)
 		api.POST("/images/:id/edit/preview", editHandler.PreviewEdit)
 		api.POST("/images/:id/edit/apply", editHandler.ApplyEdit)
+		api.POST("/images/:id/edit/auto", editHandler.AutoEdit)
 		api.POST("/images/:id/edit/reset", editHandler.ResetImage)
+		api.POST("/images/:id/edit/remove-background", segmentHandler.RemoveBackground)
+		api.GET("/images/:id/masks", segmentHandler.GetMasks)
+		api.POST("/images/:id/masks", segmentHandler.CreateMask)
+		api.GET("/images/:id/masks/:mask", segmentHandler.ServeMask)
+		api.DELETE("/images/:id/masks/:mask", segmentHandler.DeleteMask)
+		api.PUT("/images/:id/location", geoHandler.SetLocation)
+		api.POST("/images/:id/share", shareHandler.ShareImage)
+		api.GET("/presets", presetHandler.GetPresets)
+		api.POST("/presets", presetHandler.CreatePreset)
+		api.GET("/presets/export", presetHandler.ExportPresets)
+		api.POST("/presets/import", presetHandler.ImportPresets)
+		api.GET("/presets/:id", presetHandler.GetPreset)
+		api.PUT("/presets/:id", presetHandler.UpdatePreset)
+		api.DELETE("/presets/:id", presetHandler.DeletePreset)
+		api.GET("/watermarks", watermarkHandler.ListWatermarks)
+		api.POST("/watermarks", watermarkHandler.CreateWatermark)
+		api.DELETE("/watermarks/:id", watermarkHandler.DeleteWatermark)
+		api.GET("/watermarks/logos", watermarkHandler.ListLogos)
+		api.POST("/watermarks/logos", watermarkHandler.UploadLogo)
+		api.DELETE("/watermarks/logos/:id", watermarkHandler.DeleteLogo)
+		api.GET("/shares", shareHandler.ListShares)
+		api.POST("/shares", shareHandler.CreateShare)
+		api.DELETE("/shares/:id", shareHandler.RevokeShare)
+		api.POST("/images/:id/ai/describe", aiHandler.Describe)
+		api.GET("/images/:id/faces", faceHandler.GetImageFaces)
+		api.POST("/images/:id/faces/detect", faceHandler.DetectFaces)
+		api.GET("/people", faceHandler.GetPeople)
+		api.POST("/people/recluster", faceHandler.Recluster)
+		api.GET("/people/:id", faceHandler.GetPerson)
+		api.PUT("/people/:id", faceHandler.UpdatePerson)
+		api.GET("/people/:id/images", faceHandler.GetPersonImages)
+		api.GET("/jobs", jobHandler.GetJobs)
+		api.GET("/jobs/:id", jobHandler.GetJob)
 		api.GET("/config", configHandler.GetConfig)
 		api.POST("/config", configHandler.UpdateConfig)
 	}
@@ -70,17 +220,19 @@ func New(dbPath, uploadDir string) (*Server, error) {
 	return &Server{
 		router:        router,
 		db:            db,
-		uploadDir:     uploadDir,
+		config:        cfg,
 		configHandler: configHandler,
+		jobs:          jobManager,
 	}, nil
 }
 
-func (s *Server) Start(port string) error {
-	log.Printf("Starting server on port %s", port)
-	log.Printf("Upload directory: %s", s.uploadDir)
-	return s.router.Run(":" + port)
+func (s *Server) Start() error {
+	log.Printf("Starting server on port %d", s.config.Port)
+	log.Printf("Upload directory: %s", s.config.UploadDir)
+	return s.router.Run(":" + strconv.Itoa(s.config.Port))
 }
 
 func (s *Server) Close() error {
+	s.jobs.Close()
 	return s.db.Close()
 }
\ No newline at end of file
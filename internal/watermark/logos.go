@@ -0,0 +1,121 @@
+package watermark
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// MaxLogoDimension limits the size of uploaded logos; they are scaled down
+// to a fraction of the image anyway.
+const MaxLogoDimension = 4096
+
+var (
+	ErrLogoNotFound = errors.New("logo not found")
+	ErrInvalidLogo  = errors.New("logo must be a PNG image")
+)
+
+// Logos stores uploaded PNG logos in a directory under generated ids and
+// keeps the decoded images in memory once used.
+type Logos struct {
+	dir string
+
+	mu      sync.Mutex
+	decoded map[string]image.Image
+}
+
+func NewLogos(dir string) (*Logos, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Logos{dir: dir, decoded: make(map[string]image.Image)}, nil
+}
+
+// Save stores data, which must be a PNG, and returns the new logo's id.
+func (l *Logos) Save(data []byte) (string, error) {
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", ErrInvalidLogo
+	}
+	if cfg.Width > MaxLogoDimension || cfg.Height > MaxLogoDimension {
+		return "", errors.New("logo is too large")
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		return "", ErrInvalidLogo
+	}
+
+	id := uuid.New().String()
+	if err := os.WriteFile(l.path(id), data, 0644); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Open returns the decoded logo with the given id.
+func (l *Logos) Open(id string) (image.Image, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrLogoNotFound
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if img, ok := l.decoded[id]; ok {
+		return img, nil
+	}
+
+	file, err := os.Open(l.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrLogoNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	l.decoded[id] = img
+	return img, nil
+}
+
+// List returns the ids of all stored logos.
+func (l *Logos) List() ([]string, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, e := range entries {
+		if id, ok := strings.CutSuffix(e.Name(), ".png"); ok && !e.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (l *Logos) Delete(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrLogoNotFound
+	}
+	l.mu.Lock()
+	delete(l.decoded, id)
+	l.mu.Unlock()
+	if err := os.Remove(l.path(id)); errors.Is(err, os.ErrNotExist) {
+		return ErrLogoNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (l *Logos) path(id string) string {
+	return filepath.Join(l.dir, id+".png")
+}
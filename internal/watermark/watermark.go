@@ -0,0 +1,240 @@
+// Package watermark draws text and logo overlays onto images, placed at an
+// edge or corner or tiled across the whole image. Sizes are relative to the
+// image, so a watermark looks the same on a thumbnail and on the original.
+package watermark
+
+import (
+	"errors"
+	"fmt"
+	"goga/internal/adjust"
+	"goga/pkg/utils"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Defaults for the optional Spec fields.
+const (
+	defaultTextSize = 5
+	defaultLogoSize = 15
+	defaultOpacity  = 50
+	defaultMargin   = 3
+	defaultSpacing  = 100
+	defaultPosition = "bottom_right"
+	defaultColor    = "#ffffff"
+)
+
+// Spec describes a watermark: either Text, drawn in the bundled Go Bold
+// font, or Logo, the id of an uploaded PNG. Size is the height of the mark
+// and Margin its distance from the edges, both as a percentage of the
+// image's shorter side. Tiled marks repeat over the whole image with gaps
+// of Spacing percent of the mark's size and ignore Position.
+type Spec struct {
+	Text     string   `json:"text,omitempty"`
+	Logo     string   `json:"logo,omitempty"`
+	Size     float64  `json:"size,omitempty" min:"0" max:"100"`
+	Color    string   `json:"color,omitempty"`
+	Opacity  *float64 `json:"opacity,omitempty" min:"0" max:"100"`
+	Position string   `json:"position,omitempty" enum:",center,top_left,top,top_right,left,right,bottom_left,bottom,bottom_right"`
+	Margin   *float64 `json:"margin,omitempty" min:"0" max:"50"`
+	Tile     bool     `json:"tile,omitempty"`
+	Spacing  *float64 `json:"spacing,omitempty" min:"0" max:"500"`
+	Angle    float64  `json:"angle,omitempty" min:"-90" max:"90"`
+}
+
+// maxTextLength keeps text marks to something that fits on a photo.
+const maxTextLength = 200
+
+func (s *Spec) Validate() error {
+	switch {
+	case s.Text == "" && s.Logo == "":
+		return errors.New("watermark needs text or a logo")
+	case s.Text != "" && s.Logo != "":
+		return errors.New("watermark takes text or a logo, not both")
+	case len(s.Text) > maxTextLength:
+		return fmt.Errorf("watermark text must be at most %d characters", maxTextLength)
+	}
+	if s.Color != "" {
+		if _, err := utils.ParseHexColor(s.Color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply draws the watermark onto img in place and returns it. logo is the
+// decoded image for Spec.Logo and is ignored for text marks.
+func (s *Spec) Apply(img *image.NRGBA, logo image.Image) *image.NRGBA {
+	short := float64(min(img.Rect.Dx(), img.Rect.Dy()))
+	if short <= 0 {
+		return img
+	}
+
+	var mark *image.NRGBA
+	if s.Text != "" {
+		size := orDefault(s.Size, defaultTextSize)
+		c, err := utils.ParseHexColor(orString(s.Color, defaultColor))
+		if err != nil {
+			return img
+		}
+		mark = renderText(s.Text, max(1, size/100*short), c)
+	} else if logo != nil {
+		height := max(1, int(math.Round(orDefault(s.Size, defaultLogoSize)/100*short)))
+		mark = imaging.Resize(logo, 0, height, imaging.Lanczos)
+	}
+	if mark == nil || mark.Rect.Empty() {
+		return img
+	}
+	if s.Angle != 0 {
+		mark = imaging.Rotate(mark, s.Angle, color.Transparent)
+	}
+
+	opacity := defaultOpacity / 100.0
+	if s.Opacity != nil {
+		opacity = *s.Opacity / 100
+	}
+	if s.Tile {
+		spacing := float64(defaultSpacing)
+		if s.Spacing != nil {
+			spacing = *s.Spacing
+		}
+		gap := spacing / 100 * float64(min(mark.Rect.Dx(), mark.Rect.Dy()))
+		pitch := image.Pt(mark.Rect.Dx()+int(gap), mark.Rect.Dy()+int(gap))
+		tile(img, mark, pitch, opacity)
+		return img
+	}
+
+	margin := float64(defaultMargin)
+	if s.Margin != nil {
+		margin = *s.Margin
+	}
+	at := place(img.Rect.Size(), mark.Rect.Size(), orString(s.Position, defaultPosition), int(margin/100*short))
+	overlay(img, mark, at, opacity)
+	return img
+}
+
+// place returns the top left corner of a mark of the given size at position.
+func place(img, mark image.Point, position string, margin int) image.Point {
+	x := (img.X - mark.X) / 2
+	y := (img.Y - mark.Y) / 2
+	if strings.HasSuffix(position, "left") {
+		x = margin
+	} else if strings.HasSuffix(position, "right") {
+		x = img.X - mark.X - margin
+	}
+	if strings.HasPrefix(position, "top") {
+		y = margin
+	} else if strings.HasPrefix(position, "bottom") {
+		y = img.Y - mark.Y - margin
+	}
+	return image.Pt(x, y)
+}
+
+var (
+	fontOnce sync.Once
+	boldFont *opentype.Font
+	fontErr  error
+)
+
+// renderText draws text at the given pixel size onto a transparent image
+// just large enough to hold it.
+func renderText(text string, size float64, c color.NRGBA) *image.NRGBA {
+	fontOnce.Do(func() {
+		boldFont, fontErr = opentype.Parse(gobold.TTF)
+	})
+	if fontErr != nil {
+		return nil
+	}
+	face, err := opentype.NewFace(boldFont, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	width := font.MeasureString(face, text).Ceil()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+	if width <= 0 || height <= 0 {
+		return nil
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.Point26_6{Y: metrics.Ascent},
+	}
+	d.DrawString(text)
+	return dst
+}
+
+// overlay blends mark onto dst with its top left corner at at.
+func overlay(dst, mark *image.NRGBA, at image.Point, opacity float64) {
+	r := mark.Rect.Sub(mark.Rect.Min).Add(at).Intersect(dst.Rect.Sub(dst.Rect.Min))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := dst.Pix[y*dst.Stride : y*dst.Stride+dst.Rect.Dx()*4]
+		src := mark.Pix[(y-at.Y)*mark.Stride:]
+		for x := r.Min.X; x < r.Max.X; x++ {
+			blend(row[x*4:x*4+4], src[(x-at.X)*4:(x-at.X)*4+4], opacity)
+		}
+	}
+}
+
+// tile repeats mark over dst every pitch pixels, shifting every other row
+// by half a pitch.
+func tile(dst, mark *image.NRGBA, pitch image.Point, opacity float64) {
+	w, h := mark.Rect.Dx(), mark.Rect.Dy()
+	adjust.ForEachRow(dst, func(y int, row []uint8) {
+		ty := y % pitch.Y
+		if ty >= h {
+			return
+		}
+		shift := 0
+		if (y/pitch.Y)%2 == 1 {
+			shift = pitch.X / 2
+		}
+		src := mark.Pix[ty*mark.Stride:]
+		for x := 0; x < len(row)/4; x++ {
+			if tx := (x + shift) % pitch.X; tx < w {
+				blend(row[x*4:x*4+4], src[tx*4:tx*4+4], opacity)
+			}
+		}
+	})
+}
+
+// blend composites the non-premultiplied src pixel over dst.
+func blend(dst, src []uint8, opacity float64) {
+	a := float64(src[3]) / 255 * opacity
+	if a == 0 {
+		return
+	}
+	da := float64(dst[3]) / 255
+	outA := a + da*(1-a)
+	for i := 0; i < 3; i++ {
+		v := (float64(src[i])*a + float64(dst[i])*da*(1-a)) / outA
+		dst[i] = uint8(v + 0.5)
+	}
+	dst[3] = uint8(outA*255 + 0.5)
+}
+
+func orDefault(v, def float64) float64 {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+func orString(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
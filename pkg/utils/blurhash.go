@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// blurHashSampleSize is the long edge the image is reduced to before
+// encoding; the hash only holds a handful of frequencies anyway.
+const blurHashSampleSize = 64
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash encodes img as a BlurHash (https://blurha.sh) with xComponents
+// by yComponents cosine components, each between 1 and 9. The hash is a
+// short string that clients decode into a blurred preview of the image.
+// Transparent pixels are encoded as if they were opaque.
+func BlurHash(img image.Image, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", fmt.Errorf("blurhash components must be between 1 and 9")
+	}
+	small := imaging.Fit(img, blurHashSampleSize, blurHashSampleSize, imaging.Box)
+	w, h := small.Rect.Dx(), small.Rect.Dy()
+	if w == 0 || h == 0 {
+		return "", fmt.Errorf("empty image")
+	}
+
+	linear := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		row := small.Pix[y*small.Stride:]
+		for x := 0; x < w; x++ {
+			p := row[x*4:]
+			linear[y*w+x] = [3]float64{srgbToLinear(p[0]), srgbToLinear(p[1]), srgbToLinear(p[2])}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	cosX := make([]float64, w)
+	cosY := make([]float64, h)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			for x := range cosX {
+				cosX[x] = math.Cos(math.Pi * float64(i) * float64(x) / float64(w))
+			}
+			for y := range cosY {
+				cosY[y] = math.Cos(math.Pi * float64(j) * float64(y) / float64(h))
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := cosX[x] * cosY[y]
+					for c := range f {
+						f[c] += basis * linear[y*w+x][c]
+					}
+				}
+			}
+			scale := 2.0 / float64(w*h)
+			if i == 0 && j == 0 {
+				scale = 1.0 / float64(w*h)
+			}
+			for c := range f {
+				f[c] *= scale
+			}
+			factors = append(factors, f)
+		}
+	}
+
+	var b strings.Builder
+	encodeBase83(&b, (xComponents-1)+(yComponents-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, f := range ac {
+			for _, v := range f {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		encodeBase83(&b, quantisedMax, 1)
+	} else {
+		encodeBase83(&b, 0, 1)
+	}
+
+	encodeBase83(&b, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	for _, f := range ac {
+		var q [3]int
+		for c, v := range f {
+			q[c] = int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		encodeBase83(&b, q[0]*19*19+q[1]*19+q[2], 2)
+	}
+	return b.String(), nil
+}
+
+// BlurHashComponents returns the components to encode an image of the given
+// size with: four along the long edge and three along the short one.
+func BlurHashComponents(width, height int) (int, int) {
+	if height > width {
+		return 3, 4
+	}
+	return 4, 3
+}
+
+func encodeBase83(b *strings.Builder, value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		digit := value / int(math.Pow(83, float64(i))) % 83
+		b.WriteByte(base83Chars[digit])
+	}
+}
+
+// linearToSRGB returns the sRGB channel value of a linear light value.
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
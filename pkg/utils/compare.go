@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// ssimWindow is the side of the square windows SSIM is computed over.
+const ssimWindow = 8
+
+// Metrics describe how much two images of the same size differ. PSNR is in
+// decibels and nil for identical images, SSIM runs from 0 (unrelated) to 1
+// (identical) and MAE is the mean absolute difference per channel on the
+// 0-255 scale.
+type Metrics struct {
+	PSNR *float64 `json:"psnr"`
+	SSIM float64  `json:"ssim"`
+	MAE  float64  `json:"mae"`
+}
+
+// Align returns a and b at the same size, no larger than maxSize on the
+// long edge: a is fitted into the box and b is scaled and, if its aspect
+// ratio differs, centre-cropped to match. Transparent pixels are placed on
+// white so that they compare the way they are displayed.
+func Align(a, b image.Image, maxSize int) (*image.NRGBA, *image.NRGBA) {
+	na := imaging.Clone(Flatten(a, color.White))
+	if maxSize > 0 && (na.Rect.Dx() > maxSize || na.Rect.Dy() > maxSize) {
+		na = imaging.Fit(na, maxSize, maxSize, imaging.Lanczos)
+	}
+	w, h := na.Rect.Dx(), na.Rect.Dy()
+	nb := imaging.Clone(Flatten(b, color.White))
+	if nb.Rect.Dx() != w || nb.Rect.Dy() != h {
+		nb = imaging.Fill(nb, w, h, imaging.Center, imaging.Lanczos)
+	}
+	return na, nb
+}
+
+// Compare measures the difference between two images of the same size.
+// Colour differences count towards PSNR and MAE; SSIM is computed on luma.
+func Compare(a, b *image.NRGBA) Metrics {
+	w, h := a.Rect.Dx(), a.Rect.Dy()
+	var sumAbs, sumSq float64
+	for y := 0; y < h; y++ {
+		ra, rb := a.Pix[y*a.Stride:], b.Pix[y*b.Stride:]
+		for i := 0; i < w*4; i++ {
+			if i%4 == 3 {
+				continue
+			}
+			d := float64(ra[i]) - float64(rb[i])
+			sumAbs += math.Abs(d)
+			sumSq += d * d
+		}
+	}
+
+	var m Metrics
+	n := float64(w * h * 3)
+	if n == 0 {
+		return m
+	}
+	m.MAE = sumAbs / n
+	if mse := sumSq / n; mse > 0 {
+		psnr := 10 * math.Log10(255*255/mse)
+		m.PSNR = &psnr
+	}
+	m.SSIM = SSIM(a, b)
+	return m
+}
+
+// SSIM returns the mean structural similarity of the luma of two images of
+// the same size, over non-overlapping 8×8 windows.
+func SSIM(a, b *image.NRGBA) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	w, h := a.Rect.Dx(), a.Rect.Dy()
+
+	// Images smaller than a window are compared as a single window
+	win := min(ssimWindow, w, h)
+	if win == 0 {
+		return 1
+	}
+	total, windows := 0.0, 0
+	for wy := 0; wy+win <= h; wy += win {
+		for wx := 0; wx+win <= w; wx += win {
+			var sa, sb, saa, sbb, sab float64
+			for y := wy; y < wy+win; y++ {
+				ra, rb := a.Pix[y*a.Stride+wx*4:], b.Pix[y*b.Stride+wx*4:]
+				for x := 0; x < win*4; x += 4 {
+					va, vb := luma(ra[x:]), luma(rb[x:])
+					sa += va
+					sb += vb
+					saa += va * va
+					sbb += vb * vb
+					sab += va * vb
+				}
+			}
+			n := float64(win * win)
+			ma, mb := sa/n, sb/n
+			varA, varB := saa/n-ma*ma, sbb/n-mb*mb
+			cov := sab/n - ma*mb
+			total += (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
+
+// Difference returns the absolute difference of two images of the same
+// size per channel, multiplied by gain so that small changes are visible.
+func Difference(a, b *image.NRGBA, gain float64) *image.NRGBA {
+	w, h := a.Rect.Dx(), a.Rect.Dy()
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		ra, rb, ro := a.Pix[y*a.Stride:], b.Pix[y*b.Stride:], out.Pix[y*out.Stride:]
+		for x := 0; x < w; x++ {
+			for c := 0; c < 3; c++ {
+				i := x*4 + c
+				d := math.Abs(float64(ra[i])-float64(rb[i])) * gain
+				ro[i] = uint8(math.Min(255, d+0.5))
+			}
+			ro[x*4+3] = 255
+		}
+	}
+	return out
+}
+
+// SideBySide places a and b next to each other, separated by gap pixels of
+// white.
+func SideBySide(a, b *image.NRGBA, gap int) *image.NRGBA {
+	w, h := a.Rect.Dx(), a.Rect.Dy()
+	out := imaging.New(2*w+gap, h, color.White)
+	out = imaging.Paste(out, a, image.Pt(0, 0))
+	return imaging.Paste(out, b, image.Pt(w+gap, 0))
+}
+
+// luma returns the Rec. 601 luma of the pixel at the start of p.
+func luma(p []uint8) float64 {
+	return 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+}
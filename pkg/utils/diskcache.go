@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DiskCache stores generated files in a directory and evicts the least
+// recently used ones once the total size exceeds maxBytes.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type diskCacheEntry struct {
+	key  string
+	size int64
+}
+
+// NewDiskCache opens the cache directory, indexing files left over from
+// previous runs by modification time.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := EnsureDir(dir); err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(f.Name(), ".tmp-") {
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		if info, err := f.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		c.entries[info.Name()] = c.order.PushBack(&diskCacheEntry{key: info.Name(), size: info.Size()})
+		c.size += info.Size()
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// Get opens a cached file and marks it as recently used. The file is
+// opened while the cache is locked, so it stays readable even if a
+// concurrent Put evicts it; the caller must close it.
+func (c *DiskCache) Get(key string) (*os.File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	f, err := os.Open(filepath.Join(c.dir, key))
+	if err != nil {
+		// Removed behind the cache's back
+		c.size -= el.Value.(*diskCacheEntry).size
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return f, true
+}
+
+// Has reports whether key is cached, without marking it as used.
+func (c *DiskCache) Has(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[key]
+	return ok
+}
+
+// Put writes data under key.
+func (c *DiskCache) Put(key string, data []byte) error {
+	path := filepath.Join(c.dir, key)
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*diskCacheEntry).size
+		c.order.Remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&diskCacheEntry{key: key, size: int64(len(data))})
+	c.size += int64(len(data))
+	c.evict()
+	return nil
+}
+
+// evict removes old entries until the cache fits. The newest entry is kept
+// even if it alone exceeds the limit. Callers must hold c.mu.
+func (c *DiskCache) evict() {
+	for c.size > c.maxBytes && c.order.Len() > 1 {
+		el := c.order.Back()
+		entry := el.Value.(*diskCacheEntry)
+		os.Remove(filepath.Join(c.dir, entry.key))
+		c.order.Remove(el)
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+	}
+}
@@ -0,0 +1,304 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+)
+
+// ErrNoExif is returned when a file carries no EXIF block.
+var ErrNoExif = errors.New("no EXIF data")
+
+// EXIF tags used by the application
+const (
+	tagOrientation   = 0x0112
+	tagGPSIFD        = 0x8825
+	tagGPSLatRef     = 0x0001
+	tagGPSLat        = 0x0002
+	tagGPSLonRef     = 0x0003
+	tagGPSLon        = 0x0004
+	tagGPSAltRef     = 0x0005
+	tagGPSAltitude   = 0x0006
+	tiffTypeShort    = 3
+	tiffTypeLong     = 4
+	tiffTypeRational = 5
+)
+
+var tiffTypeSize = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+type ExifInfo struct {
+	Orientation int
+	HasGPS      bool
+	Latitude    float64
+	Longitude   float64
+	Altitude    float64
+}
+
+// ReadExif extracts orientation and GPS position from a JPEG, PNG or WebP file.
+func ReadExif(path string) (*ExifInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tiff, _, err := findExif(data)
+	if err != nil {
+		return nil, err
+	}
+	return parseExif(tiff)
+}
+
+// findExif locates the TIFF structure inside an image file and returns it
+// together with its offset in data.
+func findExif(data []byte) ([]byte, int, error) {
+	switch {
+	case len(data) > 4 && data[0] == 0xFF && data[1] == 0xD8:
+		return findJPEGExif(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return findPNGExif(data)
+	case len(data) > 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return findWebPExif(data)
+	}
+	return nil, 0, ErrNoExif
+}
+
+func findJPEGExif(data []byte) ([]byte, int, error) {
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, 0, ErrNoExif
+		}
+		marker := data[pos+1]
+		if marker == 0xD8 || (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 || marker == 0xFF {
+			pos++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // start of scan / end of image
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		payload := data[pos+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return payload[6:], pos + 4 + 6, nil
+		}
+		pos = end
+	}
+	return nil, 0, ErrNoExif
+}
+
+func findPNGExif(data []byte) ([]byte, int, error) {
+	pos := 8
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			break
+		}
+		if kind == "eXIf" {
+			return data[pos+8 : pos+8+length], pos + 8, nil
+		}
+		if kind == "IDAT" || kind == "IEND" {
+			break
+		}
+		pos = end
+	}
+	return nil, 0, ErrNoExif
+}
+
+func findWebPExif(data []byte) ([]byte, int, error) {
+	pos := 12
+	for pos+8 <= len(data) {
+		kind := string(data[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + length
+		if length < 0 || end > len(data) {
+			break
+		}
+		if kind == "EXIF" {
+			payload := data[pos+8 : end]
+			offset := pos + 8
+			// Some writers keep the JPEG-style prefix
+			if bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				payload, offset = payload[6:], offset+6
+			}
+			return payload, offset, nil
+		}
+		pos = end + length%2
+	}
+	return nil, 0, ErrNoExif
+}
+
+// tiffReader walks the IFDs of a TIFF structure.
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type tiffEntry struct {
+	tag    uint16
+	typ    uint16
+	count  uint32
+	offset uint32 // position of the value within data
+}
+
+func newTIFFReader(data []byte) (*tiffReader, uint32, error) {
+	if len(data) < 8 {
+		return nil, 0, ErrNoExif
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, errors.New("invalid TIFF header")
+	}
+	if order.Uint16(data[2:]) != 42 {
+		return nil, 0, errors.New("invalid TIFF header")
+	}
+	return &tiffReader{data: data, order: order}, order.Uint32(data[4:]), nil
+}
+
+// entries returns the entries of the IFD at offset and the offset of the
+// next IFD (0 if none).
+func (r *tiffReader) entries(offset uint32) ([]tiffEntry, uint32, error) {
+	if offset < 8 || int(offset)+2 > len(r.data) {
+		return nil, 0, errors.New("invalid IFD offset")
+	}
+	count := int(r.order.Uint16(r.data[offset:]))
+	start := int(offset) + 2
+	if start+count*12+4 > len(r.data) {
+		return nil, 0, errors.New("truncated IFD")
+	}
+
+	list := make([]tiffEntry, 0, count)
+	for i := 0; i < count; i++ {
+		p := start + i*12
+		e := tiffEntry{
+			tag:   r.order.Uint16(r.data[p:]),
+			typ:   r.order.Uint16(r.data[p+2:]),
+			count: r.order.Uint32(r.data[p+4:]),
+		}
+		size := tiffTypeSize[e.typ] * e.count
+		if size <= 4 {
+			e.offset = uint32(p + 8)
+		} else {
+			e.offset = r.order.Uint32(r.data[p+8:])
+		}
+		if size > 0 && uint64(e.offset)+uint64(size) > uint64(len(r.data)) {
+			continue
+		}
+		list = append(list, e)
+	}
+	next := r.order.Uint32(r.data[start+count*12:])
+	return list, next, nil
+}
+
+func (r *tiffReader) uint(e tiffEntry, index uint32) uint32 {
+	switch e.typ {
+	case tiffTypeShort:
+		return uint32(r.order.Uint16(r.data[e.offset+2*index:]))
+	case tiffTypeLong:
+		return r.order.Uint32(r.data[e.offset+4*index:])
+	case 1, 7:
+		return uint32(r.data[e.offset+index])
+	}
+	return 0
+}
+
+func (r *tiffReader) rational(e tiffEntry, index uint32) float64 {
+	p := e.offset + 8*index
+	num := r.order.Uint32(r.data[p:])
+	den := r.order.Uint32(r.data[p+4:])
+	if den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+func (r *tiffReader) ascii(e tiffEntry) string {
+	b := r.data[e.offset : e.offset+e.count]
+	return string(bytes.TrimRight(b, "\x00"))
+}
+
+func parseExif(tiff []byte) (*ExifInfo, error) {
+	r, ifd0, err := newTIFFReader(tiff)
+	if err != nil {
+		return nil, err
+	}
+	entries, _, err := r.entries(ifd0)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ExifInfo{Orientation: 1}
+	var gpsOffset uint32
+	for _, e := range entries {
+		switch e.tag {
+		case tagOrientation:
+			info.Orientation = int(r.uint(e, 0))
+		case tagGPSIFD:
+			gpsOffset = r.uint(e, 0)
+		}
+	}
+	if gpsOffset == 0 {
+		return info, nil
+	}
+
+	gps, _, err := r.entries(gpsOffset)
+	if err != nil {
+		return info, nil
+	}
+	var latRef, lonRef string
+	var lat, lon []float64
+	altRef := uint32(0)
+	for _, e := range gps {
+		switch e.tag {
+		case tagGPSLatRef:
+			latRef = r.ascii(e)
+		case tagGPSLonRef:
+			lonRef = r.ascii(e)
+		case tagGPSLat:
+			lat = r.degrees(e)
+		case tagGPSLon:
+			lon = r.degrees(e)
+		case tagGPSAltRef:
+			altRef = r.uint(e, 0)
+		case tagGPSAltitude:
+			if e.typ == tiffTypeRational && e.count > 0 {
+				info.Altitude = r.rational(e, 0)
+			}
+		}
+	}
+	if len(lat) != 3 || len(lon) != 3 {
+		return info, nil
+	}
+
+	info.HasGPS = true
+	info.Latitude = lat[0] + lat[1]/60 + lat[2]/3600
+	info.Longitude = lon[0] + lon[1]/60 + lon[2]/3600
+	if latRef == "S" {
+		info.Latitude = -info.Latitude
+	}
+	if lonRef == "W" {
+		info.Longitude = -info.Longitude
+	}
+	if altRef == 1 {
+		info.Altitude = -info.Altitude
+	}
+	return info, nil
+}
+
+// degrees reads a degrees/minutes/seconds rational triple.
+func (r *tiffReader) degrees(e tiffEntry) []float64 {
+	if e.typ != tiffTypeRational || e.count != 3 {
+		return nil
+	}
+	return []float64{r.rational(e, 0), r.rational(e, 1), r.rational(e, 2)}
+}
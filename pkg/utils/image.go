@@ -3,10 +3,17 @@ package utils
 import (
 	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/chai2010/webp"
 	"github.com/disintegration/imaging"
 )
 
@@ -21,6 +28,8 @@ func ProcessImage(inputPath, outputPath string, format string, quality int) erro
 		err = imaging.Save(src, outputPath, imaging.JPEGQuality(quality))
 	case "png":
 		err = imaging.Save(src, outputPath, imaging.PNGCompressionLevel(6))
+	case "webp":
+		err = saveWebP(src, outputPath, quality)
 	default:
 		return fmt.Errorf("unsupported format: %s", format)
 	}
@@ -32,6 +41,59 @@ func ProcessImage(inputPath, outputPath string, format string, quality int) erro
 	return nil
 }
 
+// EncodeImage writes img in the given format ("jpeg", "png" or "webp").
+func EncodeImage(w io.Writer, img image.Image, format string, quality int) error {
+	switch strings.ToLower(format) {
+	case "jpeg", "jpg":
+		return jpeg.Encode(w, Flatten(img, color.White), &jpeg.Options{Quality: quality})
+	case "png":
+		return png.Encode(w, img)
+	case "webp":
+		return webp.Encode(w, img, &webp.Options{Quality: float32(quality)})
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+// Flatten composites img over bg if it has any transparent pixels, for
+// formats without alpha that would otherwise show them black.
+func Flatten(img image.Image, bg color.Color) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(bounds)
+	draw.Draw(dst, bounds, image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Over)
+	return dst
+}
+
+// ContentType returns the MIME type for a format name.
+func ContentType(format string) string {
+	switch strings.ToLower(format) {
+	case "jpeg", "jpg":
+		return "image/jpeg"
+	case "png":
+		return "image/png"
+	case "webp":
+		return "image/webp"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+func saveWebP(img image.Image, outputPath string, quality int) error {
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	if err := webp.Encode(file, img, &webp.Options{Quality: float32(quality)}); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 func GetImageDimensions(imagePath string) (int, int, error) {
 	file, err := os.Open(imagePath)
 	if err != nil {
@@ -44,6 +106,10 @@ func GetImageDimensions(imagePath string) (int, int, error) {
 		return 0, 0, err
 	}
 
+	// Orientations 5 to 8 display the stored pixels turned by 90 degrees
+	if info, err := ReadExif(imagePath); err == nil && info.Orientation >= 5 && info.Orientation <= 8 {
+		return img.Height, img.Width, nil
+	}
 	return img.Width, img.Height, nil
 }
 
@@ -63,4 +129,19 @@ func GetImageFormat(filename string) string {
 
 func EnsureDir(path string) error {
 	return os.MkdirAll(path, 0755)
-}
\ No newline at end of file
+}
+// ParseHexColor accepts #rgb, #rrggbb and #rrggbbaa.
+func ParseHexColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 8 || err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid colour %q", s)
+	}
+	return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
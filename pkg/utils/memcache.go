@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+)
+
+// MemoryCache keeps values in memory and evicts the least recently used ones
+// once the total of their declared sizes exceeds maxBytes.
+type MemoryCache struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key   string
+	value any
+	size  int64
+}
+
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns a cached value and marks it as recently used.
+func (c *MemoryCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*memoryCacheEntry).value, true
+}
+
+// Put stores value under key, accounting it as size bytes.
+func (c *MemoryCache) Put(key string, value any, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*memoryCacheEntry).size
+		c.order.Remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&memoryCacheEntry{key: key, value: value, size: size})
+	c.size += size
+
+	// As with DiskCache the newest entry stays even if it alone is too big
+	for c.size > c.maxBytes && c.order.Len() > 1 {
+		el := c.order.Back()
+		entry := el.Value.(*memoryCacheEntry)
+		c.order.Remove(el)
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+	}
+}
@@ -0,0 +1,520 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strings"
+)
+
+// MetadataPolicy controls which embedded metadata leaves the server.
+type MetadataPolicy string
+
+const (
+	MetadataKeep     MetadataPolicy = "keep"
+	MetadataStripGPS MetadataPolicy = "strip_gps"
+	MetadataStripAll MetadataPolicy = "strip_all"
+)
+
+var policyRank = map[MetadataPolicy]int{MetadataKeep: 0, MetadataStripGPS: 1, MetadataStripAll: 2}
+
+// ParseMetadataPolicy validates a policy name, returning def for "".
+func ParseMetadataPolicy(s string, def MetadataPolicy) (MetadataPolicy, error) {
+	if s == "" {
+		return def, nil
+	}
+	p := MetadataPolicy(strings.ToLower(s))
+	if _, ok := policyRank[p]; !ok {
+		return "", fmt.Errorf("metadata must be one of keep, strip_gps, strip_all")
+	}
+	return p, nil
+}
+
+// Stricter returns whichever of p and q removes more.
+func (p MetadataPolicy) Stricter(q MetadataPolicy) MetadataPolicy {
+	if policyRank[q] > policyRank[p] {
+		return q
+	}
+	return p
+}
+
+// XMPMetadata is the descriptive metadata written into downloaded files.
+type XMPMetadata struct {
+	Title       string
+	Description string
+	Copyright   string
+	Keywords    []string
+}
+
+func (m XMPMetadata) empty() bool {
+	return m.Title == "" && m.Description == "" && m.Copyright == "" && len(m.Keywords) == 0
+}
+
+const xmpJPEGPrefix = "http://ns.adobe.com/xap/1.0/\x00"
+const xmpPNGKeyword = "XML:com.adobe.xmp"
+
+// SanitizeMetadata applies policy to an encoded JPEG, PNG or WebP file.
+// Colour profiles are always kept. Unknown formats are returned unchanged.
+func SanitizeMetadata(data []byte, policy MetadataPolicy) ([]byte, error) {
+	if policy == MetadataKeep {
+		return data, nil
+	}
+	// GPS is removed in place, so work on a copy
+	data = append([]byte(nil), data...)
+	switch {
+	case isJPEG(data):
+		return sanitizeJPEG(data, policy)
+	case isPNG(data):
+		return sanitizePNG(data, policy)
+	case isWebP(data):
+		return sanitizeWebP(data, policy)
+	}
+	return data, nil
+}
+
+// CopyExif copies the EXIF block of the encoded image src into the JPEG or
+// PNG at dstPath, which is expected to have been re-encoded upright from src.
+// The orientation tag is therefore reset and GPS is dropped when the policy
+// asks for it.
+func CopyExif(src []byte, dstPath string, policy MetadataPolicy) error {
+	if policy == MetadataStripAll {
+		return nil
+	}
+	tiff, _, err := findExif(src)
+	if errors.Is(err, ErrNoExif) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	tiff = append([]byte(nil), tiff...)
+	if err := SetExifOrientation(tiff, 1); err != nil {
+		return err
+	}
+	if policy == MetadataStripGPS {
+		if err := stripGPS(tiff); err != nil {
+			return err
+		}
+	}
+
+	dst, err := os.ReadFile(dstPath)
+	if err != nil {
+		return err
+	}
+	var out []byte
+	switch {
+	case isJPEG(dst):
+		if len(tiff)+8 > 0xFFFF {
+			return errors.New("EXIF block too large")
+		}
+		out, err = insertJPEGSegment(dst, 0xE1, append([]byte("Exif\x00\x00"), tiff...), false)
+	case isPNG(dst):
+		out, err = insertPNGChunk(dst, "eXIf", tiff)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dstPath, out, 0644)
+}
+
+// EmbedXMP writes meta as an XMP packet into a JPEG or PNG file, replacing
+// any packet already present. Other formats are returned unchanged.
+func EmbedXMP(data []byte, meta XMPMetadata) ([]byte, error) {
+	if meta.empty() {
+		return data, nil
+	}
+	packet := xmpPacket(meta)
+	switch {
+	case isJPEG(data):
+		if len(packet)+len(xmpJPEGPrefix)+2 > 0xFFFF {
+			return nil, errors.New("metadata too large")
+		}
+		segments, scan, err := jpegSegments(data)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		buf.Write(data[:2])
+		for _, s := range segments {
+			if !(s.marker == 0xE1 && bytes.HasPrefix(s.payload(data), []byte(xmpJPEGPrefix))) {
+				buf.Write(data[s.start:s.end])
+			}
+		}
+		buf.Write(data[scan:])
+		return insertJPEGSegment(buf.Bytes(), 0xE1, append([]byte(xmpJPEGPrefix), packet...), true)
+	case isPNG(data):
+		chunks, err := pngChunks(data)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		buf.Write(data[:8])
+		for _, ch := range chunks {
+			if !(ch.kind == "iTXt" && ch.keyword(data) == xmpPNGKeyword) {
+				buf.Write(data[ch.start:ch.end])
+			}
+		}
+		// keyword, compression flag and method, empty language and translated keyword
+		text := append([]byte(xmpPNGKeyword+"\x00\x00\x00\x00\x00"), packet...)
+		return insertPNGChunk(buf.Bytes(), "iTXt", text)
+	}
+	return data, nil
+}
+
+func xmpPacket(meta XMPMetadata) []byte {
+	var buf bytes.Buffer
+	alt := func(name, value string) {
+		if value == "" {
+			return
+		}
+		buf.WriteString("<dc:" + name + "><rdf:Alt><rdf:li xml:lang=\"x-default\">")
+		xml.EscapeText(&buf, []byte(value))
+		buf.WriteString("</rdf:li></rdf:Alt></dc:" + name + ">")
+	}
+
+	buf.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>")
+	buf.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`)
+	buf.WriteString(`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">`)
+	alt("title", meta.Title)
+	alt("description", meta.Description)
+	alt("rights", meta.Copyright)
+	if len(meta.Keywords) > 0 {
+		buf.WriteString("<dc:subject><rdf:Bag>")
+		for _, k := range meta.Keywords {
+			buf.WriteString("<rdf:li>")
+			xml.EscapeText(&buf, []byte(k))
+			buf.WriteString("</rdf:li>")
+		}
+		buf.WriteString("</rdf:Bag></dc:subject>")
+	}
+	buf.WriteString(`</rdf:Description></rdf:RDF></x:xmpmeta><?xpacket end="w"?>`)
+	return buf.Bytes()
+}
+
+func isJPEG(data []byte) bool {
+	return len(data) > 4 && data[0] == 0xFF && data[1] == 0xD8
+}
+
+func isPNG(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n"))
+}
+
+func isWebP(data []byte) bool {
+	return len(data) > 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// containsGPS is a cheap check for location data in XMP or text chunks.
+func containsGPS(b []byte) bool {
+	return bytes.Contains(b, []byte("GPS"))
+}
+
+// --- JPEG ---
+
+type jpegSegment struct {
+	marker     byte
+	start, end int // whole segment including the marker
+}
+
+func (s jpegSegment) payload(data []byte) []byte {
+	if s.end-s.start < 4 {
+		return nil
+	}
+	return data[s.start+4 : s.end]
+}
+
+// jpegSegments lists the marker segments before the first scan and returns
+// the offset of the start-of-scan marker.
+func jpegSegments(data []byte) ([]jpegSegment, int, error) {
+	var segments []jpegSegment
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, 0, errors.New("corrupt JPEG")
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == 0xFF:
+			pos++
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			return segments, pos, nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			segments = append(segments, jpegSegment{marker, pos, pos + 2})
+			pos += 2
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, 0, errors.New("corrupt JPEG")
+		}
+		segments = append(segments, jpegSegment{marker, pos, end})
+		pos = end
+	}
+	return nil, 0, errors.New("corrupt JPEG")
+}
+
+// insertJPEGSegment adds a segment after the leading JFIF APP0 and, when
+// afterExif is set, after the EXIF APP1 so readers find it where expected.
+func insertJPEGSegment(data []byte, marker byte, payload []byte, afterExif bool) ([]byte, error) {
+	segments, _, err := jpegSegments(data)
+	if err != nil {
+		return nil, err
+	}
+	at := 2
+	for _, s := range segments {
+		isExif := s.marker == 0xE1 && bytes.HasPrefix(s.payload(data), []byte("Exif\x00\x00"))
+		if s.marker == 0xE0 || (afterExif && isExif) {
+			at = s.end
+			continue
+		}
+		break
+	}
+
+	out := make([]byte, 0, len(data)+len(payload)+4)
+	out = append(out, data[:at]...)
+	out = append(out, 0xFF, marker)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+	out = append(out, payload...)
+	return append(out, data[at:]...), nil
+}
+
+func sanitizeJPEG(data []byte, policy MetadataPolicy) ([]byte, error) {
+	segments, scan, err := jpegSegments(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:2])
+	for _, s := range segments {
+		payload := s.payload(data)
+		keep := true
+		switch {
+		case s.marker == 0xE0 || s.marker == 0xE2 || s.marker == 0xEE:
+			// JFIF, ICC profile and Adobe colour transform affect rendering
+		case s.marker == 0xFE || (s.marker >= 0xE1 && s.marker <= 0xEF):
+			if policy == MetadataStripAll {
+				keep = false
+				// Orientation is display information; without it the image
+				// would be shown sideways
+				if s.marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+					if info, err := parseExif(payload[6:]); err == nil && info.Orientation > 1 && info.Orientation <= 8 {
+						minimal := append([]byte("Exif\x00\x00"), orientationTIFF(info.Orientation)...)
+						buf.Write([]byte{0xFF, 0xE1})
+						buf.Write(binary.BigEndian.AppendUint16(nil, uint16(len(minimal)+2)))
+						buf.Write(minimal)
+					}
+				}
+			} else if s.marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				if err := stripGPS(payload[6:]); err != nil {
+					keep = false
+				}
+			} else if s.marker == 0xE1 && containsGPS(payload) {
+				keep = false
+			}
+		}
+		if keep {
+			buf.Write(data[s.start:s.end])
+		}
+	}
+	buf.Write(data[scan:])
+	return buf.Bytes(), nil
+}
+
+// --- PNG ---
+
+type pngChunk struct {
+	kind       string
+	start, end int // whole chunk including length and CRC
+}
+
+func (ch pngChunk) data(data []byte) []byte {
+	return data[ch.start+8 : ch.end-4]
+}
+
+// keyword returns the keyword of a tEXt, zTXt or iTXt chunk.
+func (ch pngChunk) keyword(data []byte) string {
+	d := ch.data(data)
+	if i := bytes.IndexByte(d, 0); i >= 0 {
+		return string(d[:i])
+	}
+	return ""
+}
+
+func pngChunks(data []byte) ([]pngChunk, error) {
+	var chunks []pngChunk
+	pos := 8
+	for pos < len(data) {
+		if pos+12 > len(data) {
+			return nil, errors.New("corrupt PNG")
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errors.New("corrupt PNG")
+		}
+		chunks = append(chunks, pngChunk{string(data[pos+4 : pos+8]), pos, end})
+		pos = end
+	}
+	return chunks, nil
+}
+
+func appendPNGChunk(out []byte, kind string, payload []byte) []byte {
+	out = binary.BigEndian.AppendUint32(out, uint32(len(payload)))
+	start := len(out)
+	out = append(out, kind...)
+	out = append(out, payload...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out[start:]))
+}
+
+// insertPNGChunk adds a chunk right after IHDR.
+func insertPNGChunk(data []byte, kind string, payload []byte) ([]byte, error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].kind != "IHDR" {
+		return nil, errors.New("corrupt PNG")
+	}
+	at := chunks[0].end
+	out := make([]byte, 0, len(data)+len(payload)+12)
+	out = append(out, data[:at]...)
+	out = appendPNGChunk(out, kind, payload)
+	return append(out, data[at:]...), nil
+}
+
+func sanitizePNG(data []byte, policy MetadataPolicy) ([]byte, error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), data[:8]...)
+	for _, ch := range chunks {
+		switch ch.kind {
+		case "eXIf":
+			if policy == MetadataStripAll {
+				continue
+			}
+			tiff := append([]byte(nil), ch.data(data)...)
+			if err := stripGPS(tiff); err != nil {
+				continue
+			}
+			out = appendPNGChunk(out, ch.kind, tiff)
+			continue
+		case "tEXt", "zTXt", "iTXt", "tIME":
+			// ImageMagick stores EXIF and XMP as compressed "Raw profile"
+			// text, so those are dropped too rather than inspected
+			raw := strings.HasPrefix(ch.keyword(data), "Raw profile type")
+			if policy == MetadataStripAll || raw || containsGPS(ch.data(data)) {
+				continue
+			}
+		}
+		out = append(out, data[ch.start:ch.end]...)
+	}
+	return out, nil
+}
+
+// --- WebP ---
+
+func sanitizeWebP(data []byte, policy MetadataPolicy) ([]byte, error) {
+	out := append([]byte(nil), data[:12]...)
+	vp8x := -1
+	var flagsCleared byte
+	pos := 12
+	for pos+8 <= len(data) {
+		kind := string(data[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + length + length%2
+		if length < 0 || end > len(data) {
+			return nil, errors.New("corrupt WebP")
+		}
+		chunk := data[pos:end]
+		pos = end
+
+		switch kind {
+		case "VP8X":
+			vp8x = len(out)
+		case "EXIF":
+			if policy == MetadataStripAll {
+				flagsCleared |= 0x08
+				continue
+			}
+			tiff := chunk[8 : 8+length]
+			if bytes.HasPrefix(tiff, []byte("Exif\x00\x00")) {
+				tiff = tiff[6:]
+			}
+			if err := stripGPS(tiff); err != nil {
+				flagsCleared |= 0x08
+				continue
+			}
+		case "XMP ":
+			if policy == MetadataStripAll || containsGPS(chunk) {
+				flagsCleared |= 0x04
+				continue
+			}
+		}
+		out = append(out, chunk...)
+	}
+	if vp8x >= 0 && vp8x+8 < len(out) {
+		out[vp8x+8] &^= flagsCleared
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// --- EXIF ---
+
+// stripGPS empties the GPS IFD of a TIFF block in place. Offsets of other
+// data stay valid because nothing moves.
+func stripGPS(tiff []byte) error {
+	r, ifd0, err := newTIFFReader(tiff)
+	if err != nil {
+		return err
+	}
+	entries, _, err := r.entries(ifd0)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.tag != tagGPSIFD {
+			continue
+		}
+		offset := r.uint(e, 0)
+		gps, _, err := r.entries(offset)
+		if err != nil {
+			return err
+		}
+		for _, g := range gps {
+			if size := tiffTypeSize[g.typ] * g.count; size > 4 {
+				clear(tiff[g.offset : g.offset+size])
+			}
+		}
+		count := uint32(r.order.Uint16(tiff[offset:]))
+		// Zero count, entries and next-IFD pointer
+		clear(tiff[offset : offset+2+12*count+4])
+	}
+	return nil
+}
+
+// SetExifOrientation rewrites the orientation tag of a TIFF block in place.
+func SetExifOrientation(tiff []byte, orientation int) error {
+	r, ifd0, err := newTIFFReader(tiff)
+	if err != nil {
+		return err
+	}
+	entries, _, err := r.entries(ifd0)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.tag == tagOrientation && e.typ == tiffTypeShort {
+			r.order.PutUint16(tiff[e.offset:], uint16(orientation))
+		}
+	}
+	return nil
+}
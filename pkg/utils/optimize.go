@@ -0,0 +1,480 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"sort"
+
+	"github.com/chai2010/webp"
+	"github.com/disintegration/imaging"
+)
+
+// Defaults and bounds for OptimizeOptions.
+const (
+	DefaultTargetSSIM = 0.985
+	minOptimizeSSIM   = 0.9
+	minQuality        = 30
+	maxQuality        = 95
+	maxPaletteColors  = 256
+	minPaletteColors  = 16
+)
+
+// OptimizeOptions control Optimize. JPEG and WebP files are re-encoded at
+// the lowest quality whose SSIM against the original still reaches
+// TargetSSIM (default 0.985). With MaxBytes set the result must also fit in
+// that many bytes, which takes precedence over the target. PNG files are
+// stripped of chunks that do not affect display and recompressed; with
+// Quantize they are also tried with a palette of up to 256 colours, kept
+// when it reaches the target.
+type OptimizeOptions struct {
+	TargetSSIM float64 `json:"target_ssim"`
+	MaxBytes   int64   `json:"max_bytes"`
+	Quantize   bool    `json:"quantize"`
+}
+
+// OptimizeResult reports what Optimize did. Method is "quality" for a
+// lossy re-encode, "recompress" for a lossless PNG re-encode, "palette"
+// for a quantized PNG, "strip" for removed PNG chunks only and "none" when
+// nothing smaller was found. BudgetMet is false when MaxBytes could not be
+// reached even at the lowest quality.
+type OptimizeResult struct {
+	Format      string  `json:"format"`
+	Method      string  `json:"method"`
+	Quality     int     `json:"quality,omitempty"`
+	Colors      int     `json:"colors,omitempty"`
+	SSIM        float64 `json:"ssim"`
+	BytesBefore int64   `json:"bytes_before"`
+	BytesAfter  int64   `json:"bytes_after"`
+	BudgetMet   bool    `json:"budget_met"`
+}
+
+func (o *OptimizeOptions) Validate() error {
+	if o.TargetSSIM == 0 {
+		o.TargetSSIM = DefaultTargetSSIM
+	}
+	if o.TargetSSIM < minOptimizeSSIM || o.TargetSSIM > 1 {
+		return fmt.Errorf("target_ssim must be between %g and 1", minOptimizeSSIM)
+	}
+	if o.MaxBytes < 0 {
+		return errors.New("max_bytes must not be negative")
+	}
+	return nil
+}
+
+// optimizeCandidate is one encoding considered by Optimize.
+type optimizeCandidate struct {
+	data    []byte
+	method  string
+	quality int
+	colors  int
+	ssim    float64
+}
+
+// Optimize returns data re-encoded smaller according to opts, or data
+// itself with Method "none". Pixels keep their stored orientation and the
+// EXIF, XMP and ICC metadata of the original are carried over. WebP files
+// with metadata and CMYK JPEGs are left alone.
+func Optimize(data []byte, opts OptimizeOptions) ([]byte, *OptimizeResult, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, nil, err
+	}
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	original := imaging.Clone(src)
+
+	var best *optimizeCandidate
+	switch format {
+	case "jpeg", "webp":
+		// The encoders cannot write WebP metadata or CMYK JPEGs
+		if _, cmyk := src.(*image.CMYK); cmyk || (format == "webp" && webpHasMetadata(data)) {
+			break
+		}
+		best, err = optimizeLossy(data, src, original, format, opts)
+	case "png":
+		best, err = optimizePNG(data, src, original, opts)
+	default:
+		return nil, nil, fmt.Errorf("unsupported format: %s", format)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := &OptimizeResult{
+		Format:      format,
+		Method:      "none",
+		SSIM:        1,
+		BytesBefore: int64(len(data)),
+		BytesAfter:  int64(len(data)),
+	}
+	if best == nil || len(best.data) >= len(data) {
+		result.BudgetMet = opts.MaxBytes == 0 || result.BytesAfter <= opts.MaxBytes
+		return data, result, nil
+	}
+	result.Method, result.Quality, result.Colors, result.SSIM = best.method, best.quality, best.colors, best.ssim
+	result.BytesAfter = int64(len(best.data))
+	result.BudgetMet = opts.MaxBytes == 0 || result.BytesAfter <= opts.MaxBytes
+	return best.data, result, nil
+}
+
+// optimizeLossy binary-searches the quality of JPEG and WebP files.
+func optimizeLossy(data []byte, src image.Image, original *image.NRGBA, format string,
+	opts OptimizeOptions) (*optimizeCandidate, error) {
+	encoded := make(map[int]*optimizeCandidate)
+	encode := func(quality int) (*optimizeCandidate, error) {
+		if c, ok := encoded[quality]; ok {
+			return c, nil
+		}
+		var buf bytes.Buffer
+		var err error
+		if format == "jpeg" {
+			err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: quality})
+		} else {
+			err = webp.Encode(&buf, src, &webp.Options{Quality: float32(quality)})
+		}
+		if err != nil {
+			return nil, err
+		}
+		c := &optimizeCandidate{data: buf.Bytes(), method: "quality", quality: quality}
+		if c.ssim, err = encodedSSIM(original, c.data); err != nil {
+			return nil, err
+		}
+		if format == "jpeg" {
+			if c.data, err = copyJPEGMetadata(data, c.data); err != nil {
+				return nil, err
+			}
+		}
+		encoded[quality] = c
+		return c, nil
+	}
+
+	// Lowest quality that reaches the target
+	lo, hi := minQuality, maxQuality
+	for lo < hi {
+		mid := (lo + hi) / 2
+		c, err := encode(mid)
+		if err != nil {
+			return nil, err
+		}
+		if c.ssim >= opts.TargetSSIM {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	best, err := encode(lo)
+	if err != nil {
+		return nil, err
+	}
+
+	// Highest quality within the budget, if the target does not fit
+	if opts.MaxBytes > 0 && int64(len(best.data)) > opts.MaxBytes {
+		lo, hi := minQuality, best.quality
+		for lo < hi {
+			mid := (lo + hi + 1) / 2
+			c, err := encode(mid)
+			if err != nil {
+				return nil, err
+			}
+			if int64(len(c.data)) <= opts.MaxBytes {
+				lo = mid
+			} else {
+				hi = mid - 1
+			}
+		}
+		if best, err = encode(lo); err != nil {
+			return nil, err
+		}
+	}
+	return best, nil
+}
+
+// optimizePNG tries stripping chunks, lossless recompression, an exact
+// palette and, when allowed, a quantized palette, keeping the smallest.
+func optimizePNG(data []byte, src image.Image, original *image.NRGBA, opts OptimizeOptions) (*optimizeCandidate, error) {
+	kept, err := pngDisplayChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	stripped, err := stripPNG(data)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []*optimizeCandidate{{data: stripped, method: "strip", ssim: 1}}
+
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	encode := func(img image.Image) ([]byte, error) {
+		var buf bytes.Buffer
+		if err := encoder.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		return withPNGChunks(buf.Bytes(), kept)
+	}
+
+	recompressed, err := encode(src)
+	if err != nil {
+		return nil, err
+	}
+	candidates = append(candidates, &optimizeCandidate{data: recompressed, method: "recompress", ssim: 1})
+
+	if palette := exactPalette(original); palette != nil {
+		paletted := image.NewPaletted(original.Rect, palette)
+		draw.Draw(paletted, paletted.Rect, original, original.Rect.Min, draw.Src)
+		encoded, err := encode(paletted)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, &optimizeCandidate{data: encoded, method: "palette", colors: len(palette), ssim: 1})
+	} else if opts.Quantize {
+		// Fewer colours are only tried to meet a byte budget
+		for colors := maxPaletteColors; colors >= minPaletteColors; colors /= 2 {
+			paletted := Quantize(original, colors)
+			a, b := Align(original, paletted, 0)
+			c := &optimizeCandidate{method: "palette", colors: colors, ssim: SSIM(a, b)}
+			if c.ssim < opts.TargetSSIM && opts.MaxBytes == 0 {
+				break
+			}
+			if c.data, err = encode(paletted); err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, c)
+			if opts.MaxBytes == 0 || int64(len(c.data)) <= opts.MaxBytes {
+				break
+			}
+		}
+	}
+
+	// The smallest candidate that reaches the target; the lossless ones
+	// always do
+	sort.SliceStable(candidates, func(i, j int) bool { return len(candidates[i].data) < len(candidates[j].data) })
+	var best *optimizeCandidate
+	for _, c := range candidates {
+		if c.ssim >= opts.TargetSSIM {
+			best = c
+			break
+		}
+	}
+	if opts.MaxBytes > 0 && int64(len(best.data)) > opts.MaxBytes {
+		// The budget takes precedence: the most faithful candidate that fits
+		var fits *optimizeCandidate
+		for _, c := range candidates {
+			if int64(len(c.data)) <= opts.MaxBytes && (fits == nil || c.ssim > fits.ssim) {
+				fits = c
+			}
+		}
+		if fits != nil {
+			best = fits
+		}
+	}
+	return best, nil
+}
+
+// encodedSSIM decodes data and compares it with original.
+func encodedSSIM(original *image.NRGBA, data []byte) (float64, error) {
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	a, b := Align(original, decoded, 0)
+	return SSIM(a, b), nil
+}
+
+// exactPalette returns the colours of img if there are at most 256 of
+// them, or nil.
+func exactPalette(img *image.NRGBA) color.Palette {
+	seen := make(map[color.NRGBA]bool)
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{row[x*4], row[x*4+1], row[x*4+2], row[x*4+3]}
+			if !seen[c] {
+				if len(seen) == maxPaletteColors {
+					return nil
+				}
+				seen[c] = true
+			}
+		}
+	}
+	palette := make(color.Palette, 0, len(seen))
+	for c := range seen {
+		palette = append(palette, c)
+	}
+	return palette
+}
+
+// Quantize reduces img to at most colors colours chosen by median cut and
+// dithers it with Floyd-Steinberg.
+func Quantize(img *image.NRGBA, colors int) *image.Paletted {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	// Sample at most about a million pixels
+	step := max(1, w*h/(1<<20))
+	pixels := make([][4]uint8, 0, w*h/step+1)
+	for i := 0; i < w*h; i += step {
+		p := img.Pix[(i/w)*img.Stride+(i%w)*4:]
+		pixels = append(pixels, [4]uint8{p[0], p[1], p[2], p[3]})
+	}
+
+	palette := medianCut(pixels, colors)
+	out := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+	draw.FloydSteinberg.Draw(out, out.Rect, img, img.Rect.Min)
+	return out
+}
+
+// medianCut chooses at most colors colours representing pixels, each the
+// mean of a box of pixels that median cut arrived at.
+func medianCut(pixels [][4]uint8, colors int) color.Palette {
+	boxes := []colorBox{newColorBox(pixels)}
+	for len(boxes) < colors {
+		// Split the box with the widest channel range at its median
+		split := 0
+		for i, box := range boxes {
+			if box.spread > boxes[split].spread {
+				split = i
+			}
+		}
+		box := boxes[split]
+		if box.spread == 0 {
+			break
+		}
+		sort.Slice(box.pixels, func(i, j int) bool { return box.pixels[i][box.channel] < box.pixels[j][box.channel] })
+		half := len(box.pixels) / 2
+		boxes[split] = newColorBox(box.pixels[:half])
+		boxes = append(boxes, newColorBox(box.pixels[half:]))
+	}
+
+	palette := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		var sum [4]int
+		for _, p := range box.pixels {
+			for c := range sum {
+				sum[c] += int(p[c])
+			}
+		}
+		n := max(1, len(box.pixels))
+		palette = append(palette, color.NRGBA{
+			uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), uint8(sum[3] / n),
+		})
+	}
+	return palette
+}
+
+// colorBox is a group of pixels in median cut, with the channel whose
+// values spread the most.
+type colorBox struct {
+	pixels  [][4]uint8
+	channel int
+	spread  int
+}
+
+func newColorBox(pixels [][4]uint8) colorBox {
+	box := colorBox{pixels: pixels}
+	if len(pixels) < 2 {
+		return box
+	}
+	lo, hi := [4]uint8{255, 255, 255, 255}, [4]uint8{}
+	for _, p := range pixels {
+		for c := range p {
+			lo[c], hi[c] = min(lo[c], p[c]), max(hi[c], p[c])
+		}
+	}
+	for c := range lo {
+		if spread := int(hi[c]) - int(lo[c]); spread > box.spread {
+			box.channel, box.spread = c, spread
+		}
+	}
+	return box
+}
+
+// pngDisplayChunkKinds are the ancillary chunks that affect how a PNG looks
+// or is oriented, or carry its EXIF, and are kept when optimizing.
+var pngDisplayChunkKinds = map[string]bool{
+	"gAMA": true, "cHRM": true, "sRGB": true, "iCCP": true, "sBIT": true, "eXIf": true,
+}
+
+// keptPNGChunk is an ancillary chunk copied into a re-encoded PNG.
+type keptPNGChunk struct {
+	kind string
+	data []byte
+}
+
+// pngDisplayChunks returns the ancillary chunks of data that optimization
+// keeps, including XMP.
+func pngDisplayChunks(data []byte) ([]keptPNGChunk, error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	var kept []keptPNGChunk
+	for _, ch := range chunks {
+		if pngDisplayChunkKinds[ch.kind] || (ch.kind == "iTXt" && ch.keyword(data) == xmpPNGKeyword) {
+			kept = append(kept, keptPNGChunk{ch.kind, ch.data(data)})
+		}
+	}
+	return kept, nil
+}
+
+// stripPNG drops comments, timestamps, physical size and other chunks that
+// do not change how the image is displayed.
+func stripPNG(data []byte) ([]byte, error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), data[:8]...)
+	for _, ch := range chunks {
+		critical := ch.kind[0] >= 'A' && ch.kind[0] <= 'Z'
+		if critical || pngDisplayChunkKinds[ch.kind] || ch.kind == "tRNS" ||
+			(ch.kind == "iTXt" && ch.keyword(data) == xmpPNGKeyword) {
+			out = append(out, data[ch.start:ch.end]...)
+		}
+	}
+	return out, nil
+}
+
+// withPNGChunks inserts chunks right after IHDR of an encoded PNG.
+func withPNGChunks(data []byte, chunks []keptPNGChunk) ([]byte, error) {
+	// Inserting each after IHDR reverses them, so go backwards
+	for i := len(chunks) - 1; i >= 0; i-- {
+		var err error
+		if data, err = insertPNGChunk(data, chunks[i].kind, chunks[i].data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// copyJPEGMetadata copies the EXIF, XMP, ICC and IPTC segments of src into
+// the freshly encoded JPEG dst.
+func copyJPEGMetadata(src, dst []byte) ([]byte, error) {
+	segments, _, err := jpegSegments(src)
+	if err != nil {
+		return nil, err
+	}
+	var meta []byte
+	for _, s := range segments {
+		if s.marker == 0xE1 || s.marker == 0xE2 || s.marker == 0xED {
+			meta = append(meta, src[s.start:s.end]...)
+		}
+	}
+	if len(meta) == 0 || !isJPEG(dst) {
+		return dst, nil
+	}
+	out := make([]byte, 0, len(dst)+len(meta))
+	out = append(out, dst[:2]...)
+	out = append(out, meta...)
+	return append(out, dst[2:]...), nil
+}
+
+// webpHasMetadata reports whether a WebP file carries EXIF, XMP or an ICC
+// profile.
+func webpHasMetadata(data []byte) bool {
+	return isWebP(data) && len(data) > 20 && string(data[12:16]) == "VP8X" && data[20]&0x2C != 0
+}
@@ -0,0 +1,251 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/chai2010/webp"
+)
+
+// photo returns a smooth gradient with fine noise, which compresses like a
+// photograph: lower qualities lose detail gradually.
+func photo() *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, 128, 96))
+	for y := 0; y < 96; y++ {
+		for x := 0; x < 128; x++ {
+			n := rng.Intn(24)
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x + n), uint8(y*2 + n), uint8(200 - x + n), 255})
+		}
+	}
+	return img
+}
+
+func encodeTest(t *testing.T, format string, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: maxQuality})
+	case "webp":
+		err = webp.Encode(&buf, img, &webp.Options{Quality: maxQuality})
+	case "png":
+		err = (&png.Encoder{CompressionLevel: png.NoCompression}).Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// reencode encodes data at quality as Optimize does and returns its size
+// and SSIM against the original.
+func reencode(t *testing.T, data []byte, quality int) (int64, float64) {
+	t.Helper()
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: quality})
+	} else {
+		err = webp.Encode(&buf, src, &webp.Options{Quality: float32(quality)})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	ssim, err := encodedSSIM(photo(), buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	if format == "jpeg" {
+		if out, err = copyJPEGMetadata(data, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return int64(len(out)), ssim
+}
+
+func TestOptimizeOptionsValidate(t *testing.T) {
+	for _, tc := range []struct {
+		opts     OptimizeOptions
+		wantSSIM float64
+		wantErr  string
+	}{
+		{OptimizeOptions{}, DefaultTargetSSIM, ""},
+		{OptimizeOptions{TargetSSIM: 0.95, MaxBytes: 1000}, 0.95, ""},
+		{OptimizeOptions{TargetSSIM: 1}, 1, ""},
+		{OptimizeOptions{TargetSSIM: 0.5}, 0, "target_ssim must be between"},
+		{OptimizeOptions{TargetSSIM: 1.01}, 0, "target_ssim must be between"},
+		{OptimizeOptions{MaxBytes: -1}, 0, "max_bytes must not be negative"},
+	} {
+		opts := tc.opts
+		err := opts.Validate()
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%+v: unexpected error: %v", tc.opts, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%+v: error is %v, want one containing %q", tc.opts, err, tc.wantErr)
+		case tc.wantErr == "" && opts.TargetSSIM != tc.wantSSIM:
+			t.Errorf("%+v: target is %g, want %g", tc.opts, opts.TargetSSIM, tc.wantSSIM)
+		}
+	}
+}
+
+// TestOptimizeTarget checks that the quality search settles on the lowest
+// quality that reaches the SSIM target.
+func TestOptimizeTarget(t *testing.T) {
+	for _, format := range []string{"jpeg", "webp"} {
+		data := encodeTest(t, format, photo())
+		lastQuality := 0
+		for _, target := range []float64{0.9, 0.95, 0.985, 0.995} {
+			out, result, err := Optimize(data, OptimizeOptions{TargetSSIM: target})
+			if err != nil {
+				t.Fatalf("%s at %g: %v", format, target, err)
+			}
+			if result.Method == "none" {
+				t.Errorf("%s at %g: nothing smaller found", format, target)
+				continue
+			}
+			if result.Method != "quality" || result.Format != format {
+				t.Errorf("%s at %g: method %s for %s", format, target, result.Method, result.Format)
+			}
+			if result.SSIM < target {
+				t.Errorf("%s at %g: SSIM %g misses the target", format, target, result.SSIM)
+			}
+			if result.Quality > minQuality {
+				if _, ssim := reencode(t, data, result.Quality-1); ssim >= target {
+					t.Errorf("%s at %g: quality %d chosen but %d reaches %g", format, target, result.Quality, result.Quality-1, ssim)
+				}
+			}
+			if result.Quality < lastQuality {
+				t.Errorf("%s at %g: quality %d below %d for a lower target", format, target, result.Quality, lastQuality)
+			}
+			lastQuality = result.Quality
+			if result.BytesBefore != int64(len(data)) || result.BytesAfter != int64(len(out)) || len(out) >= len(data) {
+				t.Errorf("%s at %g: reported %d to %d bytes, was %d to %d", format, target,
+					result.BytesBefore, result.BytesAfter, len(data), len(out))
+			}
+			if !result.BudgetMet {
+				t.Errorf("%s at %g: no budget, but not met", format, target)
+			}
+			if _, got, err := image.Decode(bytes.NewReader(out)); err != nil || got != format {
+				t.Errorf("%s at %g: result decodes as %s, %v", format, target, got, err)
+			}
+		}
+	}
+}
+
+// TestOptimizeBudget checks that a byte budget the target does not fit in
+// gets the highest quality within it.
+func TestOptimizeBudget(t *testing.T) {
+	for _, format := range []string{"jpeg", "webp"} {
+		data := encodeTest(t, format, photo())
+		_, atTarget, err := Optimize(data, OptimizeOptions{TargetSSIM: 0.995})
+		if err != nil {
+			t.Fatal(err)
+		}
+		budget, _ := reencode(t, data, (minQuality+atTarget.Quality)/2)
+
+		out, result, err := Optimize(data, OptimizeOptions{TargetSSIM: 0.995, MaxBytes: budget})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.BudgetMet || int64(len(out)) > budget {
+			t.Errorf("%s: %d bytes for a budget of %d, met %v", format, len(out), budget, result.BudgetMet)
+		}
+		if result.Quality >= atTarget.Quality {
+			t.Errorf("%s: quality %d within the budget, but %d without it", format, result.Quality, atTarget.Quality)
+		}
+		if size, _ := reencode(t, data, result.Quality+1); size <= budget {
+			t.Errorf("%s: quality %d chosen but %d fits in %d bytes", format, result.Quality, result.Quality+1, budget)
+		}
+
+		// A budget nothing fits in gets the lowest quality and says so
+		out, result, err = Optimize(data, OptimizeOptions{MaxBytes: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.BudgetMet || result.Quality != minQuality || result.BytesAfter != int64(len(out)) {
+			t.Errorf("%s: impossible budget gave quality %d, met %v", format, result.Quality, result.BudgetMet)
+		}
+	}
+}
+
+func TestOptimizePNG(t *testing.T) {
+	// Few colours: an exact palette, pixel for pixel
+	rng := rand.New(rand.NewSource(1))
+	few := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			few.SetNRGBA(x, y, color.NRGBA{uint8(rng.Intn(4) * 80), uint8(rng.Intn(4) * 80), 120, 255})
+		}
+	}
+	out, result, err := Optimize(encodeTest(t, "png", few), OptimizeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Method != "palette" || result.Colors != 16 || result.SSIM != 1 {
+		t.Errorf("few colours: %s with %d colours at SSIM %g, want an exact palette of 16", result.Method, result.Colors, result.SSIM)
+	}
+	decoded, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := Align(few, decoded, 0); !bytes.Equal(a.Pix, b.Pix) {
+		t.Error("exact palette changed pixels")
+	}
+
+	// Many colours: lossless unless quantizing is allowed
+	data := encodeTest(t, "png", photo())
+	_, result, err = Optimize(data, OptimizeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Method != "recompress" || result.SSIM != 1 {
+		t.Errorf("photo: %s at SSIM %g, want lossless recompression", result.Method, result.SSIM)
+	}
+	lossless := result.BytesAfter
+	out, result, err = Optimize(data, OptimizeOptions{Quantize: true, MaxBytes: lossless / 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Method != "palette" || result.Colors < minPaletteColors || result.Colors > maxPaletteColors {
+		t.Errorf("quantized photo: %s with %d colours", result.Method, result.Colors)
+	}
+	if result.BudgetMet != (int64(len(out)) <= lossless/2) {
+		t.Errorf("quantized photo: %d bytes for a budget of %d, met %v", len(out), lossless/2, result.BudgetMet)
+	}
+}
+
+func TestOptimizeKeepsExif(t *testing.T) {
+	data, err := insertJPEGSegment(encodeTest(t, "jpeg", photo()), 0xE1,
+		append([]byte("Exif\x00\x00"), gpsTIFF(46, 6)...), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, result, err := Optimize(data, OptimizeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Method != "quality" {
+		t.Fatalf("method %s, want quality", result.Method)
+	}
+	tiff, _, err := findExif(out)
+	if err != nil {
+		t.Fatalf("EXIF lost: %v", err)
+	}
+	info, err := parseExif(tiff)
+	if err != nil || !info.HasGPS || info.Latitude != 46 || info.Longitude != 6 {
+		t.Errorf("EXIF after optimizing is %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+)
+
+// orientationMatrices gives, for each EXIF orientation, the transform that
+// displays the stored pixels as a 2x2 matrix {a, b, c, d} mapping (x, y) to
+// (ax+by, cx+dy), with y pointing down.
+var orientationMatrices = [9][4]int{
+	1: {1, 0, 0, 1},   // normal
+	2: {-1, 0, 0, 1},  // mirrored horizontally
+	3: {-1, 0, 0, -1}, // rotated 180
+	4: {1, 0, 0, -1},  // mirrored vertically
+	5: {0, 1, 1, 0},   // transposed
+	6: {0, -1, 1, 0},  // rotated 90 clockwise
+	7: {0, -1, -1, 0}, // transversed
+	8: {0, 1, -1, 0},  // rotated 90 counter-clockwise
+}
+
+// ComposeOrientation returns the EXIF orientation that displays like
+// orientation a followed by b. Invalid orientations count as 1.
+func ComposeOrientation(a, b int) int {
+	if a < 1 || a > 8 {
+		a = 1
+	}
+	if b < 1 || b > 8 {
+		b = 1
+	}
+	m, n := orientationMatrices[b], orientationMatrices[a]
+	product := [4]int{
+		m[0]*n[0] + m[1]*n[2], m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2], m[2]*n[1] + m[3]*n[3],
+	}
+	for o := 1; o <= 8; o++ {
+		if orientationMatrices[o] == product {
+			return o
+		}
+	}
+	return 1
+}
+
+// ReorientJPEG applies transform, given as an EXIF orientation, to the JPEG
+// file at path by rewriting its orientation tag. The image data is not
+// touched, so the edit is lossless. It returns the new orientation.
+func ReorientJPEG(path string, transform int) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if !isJPEG(data) {
+		return 0, errors.New("not a JPEG file")
+	}
+
+	current := 1
+	tiff, _, err := findExif(data)
+	switch {
+	case err == nil:
+		if info, err := parseExif(tiff); err == nil {
+			current = info.Orientation
+		}
+	case !errors.Is(err, ErrNoExif):
+		return 0, err
+	}
+	orientation := ComposeOrientation(current, transform)
+
+	var updated []byte
+	if tiff == nil {
+		tiff = orientationTIFF(orientation)
+	} else if tiff, err = withOrientation(tiff, orientation); err != nil {
+		return 0, err
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	if len(payload)+2 > 0xFFFF {
+		return 0, errors.New("EXIF block too large")
+	}
+	if updated, err = replaceJPEGExif(data, payload); err != nil {
+		return 0, err
+	}
+
+	// Replace the file rather than truncate it, so a failed write keeps
+	// the original
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, updated, 0644); err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	return orientation, nil
+}
+
+// orientationTIFF builds a minimal TIFF block holding only the orientation.
+func orientationTIFF(orientation int) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	tiff = binary.BigEndian.AppendUint16(tiff, tagOrientation)
+	tiff = binary.BigEndian.AppendUint16(tiff, tiffTypeShort)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(orientation))
+	return append(tiff, 0, 0, 0, 0, 0, 0) // value padding and no next IFD
+}
+
+// withOrientation returns a copy of tiff with the given orientation. A
+// missing tag is added by appending a copy of IFD0 that includes it and
+// pointing the header there; everything else keeps its offset.
+func withOrientation(tiff []byte, orientation int) ([]byte, error) {
+	out := append([]byte(nil), tiff...)
+	r, ifd0, err := newTIFFReader(out)
+	if err != nil {
+		return nil, err
+	}
+	entries, _, err := r.entries(ifd0)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.tag == tagOrientation && e.typ == tiffTypeShort && e.count == 1 {
+			r.order.PutUint16(out[e.offset:], uint16(orientation))
+			return out, nil
+		}
+	}
+
+	var entry [12]byte
+	r.order.PutUint16(entry[0:], tagOrientation)
+	r.order.PutUint16(entry[2:], tiffTypeShort)
+	r.order.PutUint32(entry[4:], 1)
+	r.order.PutUint16(entry[8:], uint16(orientation))
+
+	count := int(r.order.Uint16(tiff[ifd0:]))
+	start := int(ifd0) + 2
+	if len(out)%2 == 1 {
+		out = append(out, 0)
+	}
+	newIFD := len(out)
+	out = append(out, 0, 0)
+	n, inserted := 0, false
+	for i := 0; i < count; i++ {
+		raw := tiff[start+12*i : start+12*i+12]
+		tag := r.order.Uint16(raw)
+		if tag == tagOrientation {
+			continue
+		}
+		if !inserted && tag > tagOrientation {
+			out = append(out, entry[:]...)
+			n, inserted = n+1, true
+		}
+		out = append(out, raw...)
+		n++
+	}
+	if !inserted {
+		out = append(out, entry[:]...)
+		n++
+	}
+	out = append(out, tiff[start+12*count:start+12*count+4]...)
+	r.order.PutUint16(out[newIFD:], uint16(n))
+	r.order.PutUint32(out[4:], uint32(newIFD))
+	return out, nil
+}
+
+// replaceJPEGExif swaps the EXIF APP1 segment for one with payload, or
+// inserts one if there is none.
+func replaceJPEGExif(data, payload []byte) ([]byte, error) {
+	segments, _, err := jpegSegments(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range segments {
+		if s.marker == 0xE1 && bytes.HasPrefix(s.payload(data), []byte("Exif\x00\x00")) {
+			out := make([]byte, 0, len(data)-(s.end-s.start)+len(payload)+4)
+			out = append(out, data[:s.start]...)
+			out = append(out, 0xFF, 0xE1)
+			out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+			out = append(out, payload...)
+			return append(out, data[s.end:]...), nil
+		}
+	}
+	return insertJPEGSegment(data, 0xE1, payload, false)
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// orient displays img as a viewer honouring EXIF orientation o would.
+func orient(img image.Image, o int) *image.NRGBA {
+	switch o {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+	return imaging.Clone(img)
+}
+
+// asymmetric returns a 3x2 image whose pixels are all different, so every
+// orientation of it is distinct.
+func asymmetric() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(40 * x), uint8(100 * y), 0, 255})
+		}
+	}
+	return img
+}
+
+func TestComposeOrientation(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		a, b int
+		want int
+	}{
+		{"identity", 1, 1, 1},
+		{"identity then rotation", 1, 6, 6},
+		{"rotation then identity", 6, 1, 6},
+		{"two quarter turns", 6, 6, 3},
+		{"four quarter turns", 3, 3, 1},
+		{"clockwise then counter-clockwise", 6, 8, 1},
+		{"mirror twice", 2, 2, 1},
+		{"both mirrors", 2, 4, 3},
+		{"mirror then quarter turn", 2, 6, 7},
+		{"quarter turn then mirror", 6, 2, 5},
+		{"invalid first", 0, 6, 6},
+		{"invalid second", 6, 9, 6},
+		{"both invalid", -1, 42, 1},
+	} {
+		if got := ComposeOrientation(tc.a, tc.b); got != tc.want {
+			t.Errorf("%s: ComposeOrientation(%d, %d) = %d, want %d", tc.name, tc.a, tc.b, got, tc.want)
+		}
+	}
+
+	// Every pair displays like the two orientations applied in turn
+	src := asymmetric()
+	for a := 1; a <= 8; a++ {
+		for b := 1; b <= 8; b++ {
+			o := ComposeOrientation(a, b)
+			want := orient(orient(src, a), b)
+			if got := orient(src, o); got.Rect != want.Rect || !bytes.Equal(got.Pix, want.Pix) {
+				t.Errorf("ComposeOrientation(%d, %d) = %d, which displays differently", a, b, o)
+			}
+		}
+	}
+}
+
+func writeTestJPEG(t *testing.T, dir string) (string, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	img := imaging.Resize(asymmetric(), 48, 32, imaging.NearestNeighbor)
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, buf.Bytes()
+}
+
+// scan returns the JPEG from its first quantisation table on: everything
+// but the metadata segments.
+func scan(t *testing.T, data []byte) []byte {
+	t.Helper()
+	i := bytes.Index(data, []byte{0xFF, 0xDB})
+	if i < 0 {
+		t.Fatal("no quantisation table")
+	}
+	return data[i:]
+}
+
+// gpsTIFF builds a little-endian TIFF block with a GPS position of whole
+// northern and eastern degrees and no orientation.
+func gpsTIFF(lat, lon uint32) []byte {
+	le := binary.LittleEndian
+	tiff := []byte("II\x2a\x00\x08\x00\x00\x00")
+	entry := func(tag, typ uint16, count, value uint32) {
+		tiff = le.AppendUint16(tiff, tag)
+		tiff = le.AppendUint16(tiff, typ)
+		tiff = le.AppendUint32(tiff, count)
+		tiff = le.AppendUint32(tiff, value)
+	}
+	// IFD0 at 8 holds only the GPS pointer; the GPS IFD follows at 26 and
+	// its two rational triples at 80 and 104
+	tiff = le.AppendUint16(tiff, 1)
+	entry(tagGPSIFD, tiffTypeLong, 1, 26)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = le.AppendUint16(tiff, 4)
+	entry(tagGPSLatRef, 2, 2, 'N')
+	entry(tagGPSLat, tiffTypeRational, 3, 80)
+	entry(tagGPSLonRef, 2, 2, 'E')
+	entry(tagGPSLon, tiffTypeRational, 3, 104)
+	tiff = le.AppendUint32(tiff, 0)
+	for _, degrees := range []uint32{lat, lon} {
+		for _, v := range []uint32{degrees, 0, 0} {
+			tiff = le.AppendUint32(tiff, v)
+			tiff = le.AppendUint32(tiff, 1)
+		}
+	}
+	return tiff
+}
+
+func TestReorientJPEG(t *testing.T) {
+	path, original := writeTestJPEG(t, t.TempDir())
+
+	for _, step := range []struct {
+		transform int
+		want      int
+	}{
+		{6, 6}, // no EXIF yet: one is added
+		{6, 3},
+		{2, 4},
+		{4, 1},
+		{0, 1}, // invalid transforms change nothing
+	} {
+		got, err := ReorientJPEG(path, step.transform)
+		if err != nil {
+			t.Fatalf("transform %d: %v", step.transform, err)
+		}
+		if got != step.want {
+			t.Errorf("transform %d gave orientation %d, want %d", step.transform, got, step.want)
+		}
+		info, err := ReadExif(path)
+		if err != nil {
+			t.Fatalf("transform %d: %v", step.transform, err)
+		}
+		if info.Orientation != step.want {
+			t.Errorf("transform %d: file says orientation %d, want %d", step.transform, info.Orientation, step.want)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(scan(t, data), scan(t, original)) {
+			t.Fatalf("transform %d changed the image data", step.transform)
+		}
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestReorientJPEGKeepsExif(t *testing.T) {
+	path, original := writeTestJPEG(t, t.TempDir())
+	payload := append([]byte("Exif\x00\x00"), gpsTIFF(46, 6)...)
+	data, err := insertJPEGSegment(original, 0xE1, payload, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first call adds the missing tag, the second rewrites it in place
+	for _, step := range []struct{ transform, want int }{{8, 8}, {8, 3}} {
+		if got, err := ReorientJPEG(path, step.transform); err != nil || got != step.want {
+			t.Fatalf("transform %d gave %d, %v; want %d", step.transform, got, err, step.want)
+		}
+		info, err := ReadExif(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Orientation != step.want {
+			t.Errorf("file says orientation %d, want %d", info.Orientation, step.want)
+		}
+		if !info.HasGPS || info.Latitude != 46 || info.Longitude != 6 {
+			t.Errorf("GPS is %v %v, %v after reorienting; want 46, 6", info.HasGPS, info.Latitude, info.Longitude)
+		}
+	}
+}
+
+func TestReorientJPEGRejectsOtherFormats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.png")
+	if err := imaging.Save(asymmetric(), path); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReorientJPEG(path, 6); err == nil {
+		t.Error("reoriented a PNG file")
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("PNG file changed")
+	}
+}
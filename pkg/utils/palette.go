@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// Palettes are taken from a small copy of the image; a few thousand pixels
+// tell which colours dominate as well as millions do.
+const (
+	paletteSampleSize    = 100
+	paletteCandidates    = 16
+	paletteMergeDistance = 12 // colours closer than this count as one
+	paletteMinShare      = 0.02
+)
+
+// PaletteColor is a dominant colour of an image and the share of the
+// image's pixels that are closest to it.
+type PaletteColor struct {
+	Color string  `json:"color"` // #rrggbb
+	Share float64 `json:"share"`
+}
+
+// Palette returns up to colors dominant colours of img, most dominant first.
+// Transparent pixels are ignored and colours covering less than 2% of the
+// image are left out.
+func Palette(img image.Image, colors int) []PaletteColor {
+	small := imaging.Fit(img, paletteSampleSize, paletteSampleSize, imaging.Box)
+	var pixels [][4]uint8
+	for y := 0; y < small.Rect.Dy(); y++ {
+		row := small.Pix[y*small.Stride : y*small.Stride+small.Rect.Dx()*4]
+		for x := 0; x < len(row); x += 4 {
+			if row[x+3] >= 128 {
+				pixels = append(pixels, [4]uint8{row[x], row[x+1], row[x+2], 255})
+			}
+		}
+	}
+	if len(pixels) == 0 {
+		return []PaletteColor{}
+	}
+
+	// Median cut finds candidates that cover the image evenly, so how
+	// dominant each is comes from counting the pixels closest to it
+	candidates := medianCut(pixels, paletteCandidates)
+	labs := make([][3]float64, len(candidates))
+	for i, c := range candidates {
+		labs[i] = lab(color.NRGBAModel.Convert(c).(color.NRGBA))
+	}
+	counts := make([]int, len(candidates))
+	for _, p := range pixels {
+		l := lab(color.NRGBA{p[0], p[1], p[2], 255})
+		nearest, best := 0, math.Inf(1)
+		for i := range labs {
+			if d := labDistance(l, labs[i]); d < best {
+				nearest, best = i, d
+			}
+		}
+		counts[nearest]++
+	}
+
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return counts[order[i]] > counts[order[j]] })
+
+	// Fold near-identical candidates into the more dominant one
+	var kept []int
+	for _, i := range order {
+		merged := false
+		for _, k := range kept {
+			if labDistance(labs[i], labs[k]) < paletteMergeDistance {
+				counts[k] += counts[i]
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			kept = append(kept, i)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return counts[kept[i]] > counts[kept[j]] })
+
+	palette := []PaletteColor{}
+	for _, k := range kept {
+		share := float64(counts[k]) / float64(len(pixels))
+		if len(palette) == colors || share < paletteMinShare {
+			break
+		}
+		c := color.NRGBAModel.Convert(candidates[k]).(color.NRGBA)
+		palette = append(palette, PaletteColor{
+			Color: fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B),
+			Share: math.Round(share*1000) / 1000,
+		})
+	}
+	return palette
+}
+
+// ColorDistance returns the perceptual difference of two colours as CIE76
+// delta E: about 2 is barely noticeable and 100 is black against white.
+func ColorDistance(a, b color.NRGBA) float64 {
+	return labDistance(lab(a), lab(b))
+}
+
+func labDistance(a, b [3]float64) float64 {
+	return math.Sqrt((a[0]-b[0])*(a[0]-b[0]) + (a[1]-b[1])*(a[1]-b[1]) + (a[2]-b[2])*(a[2]-b[2]))
+}
+
+// lab converts an sRGB colour to CIE L*a*b* under D65.
+func lab(c color.NRGBA) [3]float64 {
+	r, g, b := srgbToLinear(c.R), srgbToLinear(c.G), srgbToLinear(c.B)
+	x := (0.4124*r + 0.3576*g + 0.1805*b) / 0.95047
+	y := 0.2126*r + 0.7152*g + 0.0722*b
+	z := (0.0193*r + 0.1192*g + 0.9505*b) / 1.08883
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// srgbToLinear returns the linear light value of an sRGB channel.
+func srgbToLinear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	"github.com/disintegration/imaging"
+)
+
+// placeholderSize is the long edge of LQIP placeholders. They are meant to
+// be stretched to the size of the image and blurred with CSS while the real
+// image loads.
+const placeholderSize = 24
+
+// Placeholder returns a tiny low quality JPEG of img as a data URI, a few
+// hundred bytes that can be inlined in HTML. Transparent pixels are placed
+// on white.
+func Placeholder(img image.Image) (string, error) {
+	small := imaging.Fit(Flatten(img, color.White), placeholderSize, placeholderSize, imaging.Box)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, small, &jpeg.Options{Quality: 40}); err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
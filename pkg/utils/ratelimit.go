@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// AttemptLimiter allows at most max failed attempts per key within a sliding
+// window, e.g. password guesses per client.
+type AttemptLimiter struct {
+	max    int
+	window time.Duration
+
+	mu       sync.Mutex
+	failures map[string][]time.Time // oldest first
+}
+
+func NewAttemptLimiter(max int, window time.Duration) *AttemptLimiter {
+	return &AttemptLimiter{
+		max:      max,
+		window:   window,
+		failures: make(map[string][]time.Time),
+	}
+}
+
+// Allow reports whether key may make another attempt and, if not, how long
+// until it may.
+func (l *AttemptLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	recent := l.recent(key, now)
+	if len(recent) < l.max {
+		return true, 0
+	}
+	return false, recent[len(recent)-l.max].Add(l.window).Sub(now)
+}
+
+// Fail records a failed attempt.
+func (l *AttemptLimiter) Fail(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.failures[key] = append(l.recent(key, now), now)
+
+	// Forget keys that have not failed for a window, so the map does not
+	// grow with every client ever seen
+	if len(l.failures) > 1024 {
+		for k := range l.failures {
+			if len(l.recent(k, now)) == 0 {
+				delete(l.failures, k)
+			}
+		}
+	}
+}
+
+// Reset forgets the failures of key, e.g. after a successful attempt.
+func (l *AttemptLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
+
+// recent drops the failures of key that are older than the window.
+func (l *AttemptLimiter) recent(key string, now time.Time) []time.Time {
+	times := l.failures[key]
+	i := 0
+	for i < len(times) && !times[i].After(now.Add(-l.window)) {
+		i++
+	}
+	times = times[i:]
+	if len(times) == 0 {
+		delete(l.failures, key)
+		return nil
+	}
+	l.failures[key] = times
+	return times
+}
@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+func SignImageURL(key []byte, imageID string, thumb int, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s|%d|%d", imageID, thumb, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyImageURL(key []byte, imageID string, thumb int, expires int64, signature string) bool {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	expected, _ := hex.DecodeString(SignImageURL(key, imageID, thumb, expires))
+	return hmac.Equal(sig, expected)
+}
@@ -13,13 +13,13 @@ var (
 		"image/png":  true,
 		"image/webp": true,
 	}
-	
-	maxFileSize = int64(50 << 20) // 50MB
 )
 
-func ValidateImageUpload(file multipart.File, header *multipart.FileHeader) error {
+// ValidateImageUpload checks the size, type and content of an upload;
+// maxSize is in bytes.
+func ValidateImageUpload(file multipart.File, header *multipart.FileHeader, maxSize int64) error {
 	// Check file size
-	if header.Size > maxFileSize {
+	if header.Size > maxSize {
 		return errors.New("file too large")
 	}
 	
@@ -28,7 +28,6 @@ class Dashboard {
         this.isLoading = false;
         this.isResizing = false;
         this.panelWidth = 320;
-        this.imageVersions = new Map(); // Smart cache invalidation
         
         this.images = [];
         this.slideshowImages = [];
@@ -160,12 +159,11 @@ class Dashboard {
         const sortedImages = this.getSortedImagesByAccess();
         
         this.galleryStrip.innerHTML = sortedImages.map(image => {
-            const version = this.getImageVersion(image.id);
             return `
             <div class="flex-shrink-0 cursor-pointer group" onclick="window.dashboard.showImageDetail('${image.id}')">
                 <div class="relative w-48 h-36 bg-gray-800 rounded-lg overflow-hidden">
-                    <div class="absolute inset-0 bg-gradient-to-r from-gray-800 via-gray-700 to-gray-800 animate-pulse"></div>
-                    <img src="/api/images/${image.id}/file?thumb=280&v=${version}" 
+                    ${this.placeholderTile(image)}
+                    <img src="/api/images/${image.id}/v/${image.version}?thumb=280" 
                          alt="${image.original_name}" 
                          class="absolute inset-0 w-full h-full object-cover rounded-lg shadow-soft group-hover:scale-105 transition-all duration-300 opacity-0" 
                          style="image-orientation: from-image;" loading="lazy"
@@ -180,12 +178,11 @@ class Dashboard {
         const recent = this.images.slice(0, 8);
         
         this.recentUploads.innerHTML = recent.map(image => {
-            const version = this.getImageVersion(image.id);
             return `
             <div class="cursor-pointer group" onclick="window.dashboard.showImageDetail('${image.id}')">
                 <div class="relative w-full aspect-square bg-gray-800 rounded-lg overflow-hidden">
-                    <div class="absolute inset-0 bg-gradient-to-r from-gray-800 via-gray-700 to-gray-800 animate-pulse"></div>
-                    <img src="/api/images/${image.id}/file?thumb=120&v=${version}" 
+                    ${this.placeholderTile(image)}
+                    <img src="/api/images/${image.id}/v/${image.version}?thumb=120" 
                          alt="${image.original_name}" 
                          class="absolute inset-0 w-full h-full object-cover rounded-lg shadow-inner-custom group-hover:scale-105 transition-all duration-300 opacity-0" 
                          style="image-orientation: from-image;" loading="lazy"
@@ -199,7 +196,7 @@ class Dashboard {
     preloadSlideshowImages() {
         this.slideshowImages.forEach(image => {
             const img = new Image();
-            img.src = `/api/images/${image.id}/file`;
+            img.src = `/api/images/${image.id}/v/${image.version}`;
         });
     }
 
@@ -208,7 +205,7 @@ class Dashboard {
         
         // Set first image immediately
         const firstImage = this.slideshowImages[0];
-        this.activeLayer.style.backgroundImage = `linear-gradient(rgba(0,0,0,0.3), rgba(0,0,0,0.3)), url('/api/images/${firstImage.id}/file')`;
+        this.activeLayer.style.backgroundImage = `linear-gradient(rgba(0,0,0,0.3), rgba(0,0,0,0.3)), url('/api/images/${firstImage.id}/v/${firstImage.version}')`;
         this.activeLayer.classList.add('active');
         
         if (this.slideshowImages.length > 1) {
@@ -225,7 +222,7 @@ class Dashboard {
         const currentImage = this.slideshowImages[this.currentSlideIndex];
         
         // Set new image on inactive layer
-        this.inactiveLayer.style.backgroundImage = `linear-gradient(rgba(0,0,0,0.3), rgba(0,0,0,0.3)), url('/api/images/${currentImage.id}/file')`;
+        this.inactiveLayer.style.backgroundImage = `linear-gradient(rgba(0,0,0,0.3), rgba(0,0,0,0.3)), url('/api/images/${currentImage.id}/v/${currentImage.version}')`;
         
         // Crossfade transition
         this.inactiveLayer.classList.add('active');
@@ -299,6 +296,16 @@ class Dashboard {
         this.fileInput.value = '';
     }
 
+    // Shown until the thumbnail has loaded: the blurred placeholder over the
+    // dominant colour, or a pulse for images that were not analysed yet.
+    placeholderTile(image) {
+        if (!image.placeholder) {
+            return '<div class="absolute inset-0 bg-gradient-to-r from-gray-800 via-gray-700 to-gray-800 animate-pulse"></div>';
+        }
+        const color = image.palette && image.palette.length ? image.palette[0].color : '#1f2937';
+        return `<div class="absolute inset-0 scale-110 blur-md" style="background: ${color} url('${image.placeholder}') center / cover no-repeat;"></div>`;
+    }
+
     getSortedImagesByAccess() {
         const recentAccess = JSON.parse(localStorage.getItem('recentImageAccess') || '{}');
         
@@ -432,12 +439,11 @@ class Dashboard {
         const sortedImages = this.getSortedImagesByAccess();
         
         this.galleryGrid.innerHTML = sortedImages.map(image => {
-            const version = this.getImageVersion(image.id);
             return `
             <div class="cursor-pointer group" onclick="window.dashboard.showImageDetail('${image.id}')">
                 <div class="relative aspect-square bg-gray-800 rounded-lg overflow-hidden">
-                    <div class="absolute inset-0 bg-gradient-to-r from-gray-800 via-gray-700 to-gray-800 animate-pulse"></div>
-                    <img src="/api/images/${image.id}/file?thumb=280&v=${version}" 
+                    ${this.placeholderTile(image)}
+                    <img src="/api/images/${image.id}/v/${image.version}?thumb=280" 
                          alt="${image.original_name}" 
                          class="absolute inset-0 w-full h-full object-cover rounded-lg group-hover:scale-105 transition-all duration-300 opacity-0" 
                          style="image-orientation: from-image;" loading="lazy"
@@ -482,22 +488,15 @@ class Dashboard {
         }, 500);
     }
     
-    // Smart cache invalidation - only refresh edited images
-    getImageVersion(imageId) {
-        if (!this.imageVersions.has(imageId)) {
-            this.imageVersions.set(imageId, 1);
+    // Refresh gallery thumbnails. Image URLs carry the server-side version,
+    // so only edited images are downloaded again.
+    async refreshGallery() {
+        try {
+            const response = await fetch('/api/images');
+            this.images = await response.json();
+        } catch (error) {
+            console.error('Failed to refresh images:', error);
         }
-        return this.imageVersions.get(imageId);
-    }
-    
-    // Increment version when image is edited
-    invalidateImageCache(imageId) {
-        const currentVersion = this.getImageVersion(imageId);
-        this.imageVersions.set(imageId, currentVersion + 1);
-    }
-    
-    // Refresh gallery thumbnails
-    refreshGallery() {
         this.renderGalleryStrip();
         this.renderRecentUploads();
     }
@@ -510,8 +509,6 @@ document.addEventListener('DOMContentLoaded', () => {
     // Listen for image edit events from other tabs/windows
     window.addEventListener('storage', (e) => {
         if (e.key === 'imageEdited') {
-            const imageId = e.newValue;
-            window.dashboard.invalidateImageCache(imageId);
             window.dashboard.refreshGallery();
         }
     });
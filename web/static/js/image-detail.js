@@ -37,7 +37,7 @@ class ImageDetail {
     }
 
     renderImageDetails(image) {
-        this.mainImage.src = `/api/images/${image.id}/file`;
+        this.mainImage.src = `/api/images/${image.id}/v/${image.version}`;
         this.mainImage.alt = image.original_name;
         this.imageName.textContent = image.original_name;
         this.imageDimensions.textContent = `${image.width} × ${image.height}`;